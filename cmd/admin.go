@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/hacdias/webdav/v4/lib"
+	v "github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// connections is the number of open connections to the WebDAV server.
+var connections int64
+
+// trackConnections counts the open connections of a server.
+func trackConnections(server *http.Server) {
+	server.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt64(&connections, 1)
+		case http.StateHijacked, http.StateClosed:
+			atomic.AddInt64(&connections, -1)
+		}
+	}
+}
+
+// newAdminServer creates the server of the admin API, listening on its own
+// address and protected by a bearer token:
+//
+//	GET  /stats               transfer statistics, requests and connections
+//	POST /stats/reset         zeroes the transfer statistics
+//	GET  /locks               active locks
+//	POST /locks/unlock?path=<path>
+//	GET  /transfers           requests in progress
+//	POST /transfers/cancel?id=<id>
+//	GET  /maintenance         whether the maintenance mode is enabled
+//	POST /maintenance?enabled=true|false
+//	GET  /accept              whether accepting new connections is paused
+//	POST /accept?paused=true|false
+//	GET  /read_only           whether the read only mode is enabled
+//	POST /read_only?enabled=true|false
+//	POST /reload              reloads the users from the configuration file
+//	GET  /log_level           current log level
+//	PUT  /log_level           sets the log level, such as {"level":"debug"}
+//	GET  /events              stream of the file and lock events (Server-Sent Events)
+func newAdminServer(address, token string, cfg *lib.Config, level zap.AtomicLevel) *http.Server {
+	mux := http.NewServeMux()
+
+	events := newEventHub()
+	events.watch(cfg)
+	mux.Handle("/events", events)
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"users":              cfg.Stats(),
+			"total":              cfg.TotalStats(),
+			"active_requests":    cfg.ActiveRequests(),
+			"active_connections": atomic.LoadInt64(&connections),
+		})
+	})
+
+	mux.HandleFunc("/stats/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := ResetStats(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/locks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, cfg.ActiveLocks())
+	})
+
+	mux.HandleFunc("/locks/unlock", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if cfg.ForceUnlock(r.URL.Query().Get("path")) == 0 {
+			http.Error(w, "no such lock", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/transfers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, cfg.ActiveTransfers())
+	})
+
+	mux.HandleFunc("/transfers/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !cfg.CancelTransfer(r.URL.Query().Get("id")) {
+			http.Error(w, "no such transfer", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			cfg.SetMaintenance(enabled)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, map[string]bool{"enabled": cfg.Maintenance()})
+	})
+
+	mux.HandleFunc("/accept", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			paused, err := strconv.ParseBool(r.URL.Query().Get("paused"))
+			if err != nil {
+				http.Error(w, "paused must be true or false", http.StatusBadRequest)
+				return
+			}
+
+			if paused {
+				err = PauseAccept()
+			} else {
+				err = ResumeAccept()
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, map[string]bool{"paused": Paused()})
+	})
+
+	mux.HandleFunc("/read_only", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+				return
+			}
+			cfg.SetReadOnly(enabled)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, map[string]bool{"enabled": cfg.ReadOnly()})
+	})
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := ReloadUsers(v.ConfigFileUsed()); err != nil {
+			zap.L().Error("reloading users failed", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		zap.L().Info("users reloaded")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// zap.AtomicLevel serves GET and PUT requests of the level as JSON.
+	mux.Handle("/log_level", level)
+
+	return &http.Server{
+		Addr: address,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := []byte(r.Header.Get("Authorization"))
+			expected := []byte("Bearer " + token)
+			if subtle.ConstantTimeCompare(given, expected) != 1 {
+				zap.L().Info("admin request not authorized", zap.String("remote_address", r.RemoteAddr))
+				w.Header().Set("WWW-Authenticate", `Bearer realm="Admin"`)
+				http.Error(w, "Not authorized", http.StatusUnauthorized)
+				return
+			}
+
+			mux.ServeHTTP(w, r)
+		}),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package cmd
+
+import (
+	"errors"
+	"net"
+)
+
+// setBacklog is not supported on this platform.
+func setBacklog(ln net.Listener, backlog int) error {
+	return errors.New("listen_backlog is not supported on this platform")
+}
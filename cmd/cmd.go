@@ -1,12 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"log"
 )
 
-// Execute executes the commands.
+// Execute executes the commands, gracefully shutting down the server on
+// SIGINT and SIGTERM.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	HandleSignals()
+	if err := ExecuteContext(context.Background()); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// ExecuteContext executes the commands, gracefully shutting down the server
+// when the context is canceled.
+func ExecuteContext(ctx context.Context) error {
+	return rootCmd.ExecuteContext(ctx)
+}
@@ -1,23 +1,29 @@
 package cmd
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"go.uber.org/zap"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hacdias/webdav/v4/lib"
 	"github.com/spf13/pflag"
-	v "github.com/spf13/viper"
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/webdav"
 )
 
-func parseRules(raw []interface{}, defaultModify bool) []*lib.Rule {
+func parseRules(raw []interface{}, defaultModify bool) ([]*lib.Rule, error) {
 	rules := []*lib.Rule{}
 
 	for _, v := range raw {
@@ -50,7 +56,11 @@ func parseRules(raw []interface{}, defaultModify bool) []*lib.Rule {
 			}
 
 			if rule.Regex {
-				rule.Regexp = regexp.MustCompile(path)
+				re, err := regexp.Compile(path)
+				if err != nil {
+					return nil, err
+				}
+				rule.Regexp = re
 			} else {
 				rule.Path = path
 			}
@@ -59,7 +69,7 @@ func parseRules(raw []interface{}, defaultModify bool) []*lib.Rule {
 		}
 	}
 
-	return rules
+	return rules, nil
 }
 
 func loadFromEnv(v string) (string, error) {
@@ -76,18 +86,22 @@ func loadFromEnv(v string) (string, error) {
 	return v, nil
 }
 
-func parseUsers(raw []interface{}, c *lib.Config) {
+func parseUsers(raw []interface{}, c *lib.Config) (map[string]*lib.User, error) {
+	users := map[string]*lib.User{}
+
 	var err error
 	for _, v := range raw {
 		if u, ok := v.(map[interface{}]interface{}); ok {
 			username, ok := u["username"].(string)
 			if !ok {
-				log.Fatal("user needs an username")
+				return nil, errors.New("user needs an username")
 			}
 
 			if strings.HasPrefix(username, "{env}") {
 				username, err = loadFromEnv(username)
-				checkErr(err)
+				if err != nil {
+					return nil, err
+				}
 			}
 
 			password, ok := u["password"].(string)
@@ -101,7 +115,9 @@ func parseUsers(raw []interface{}, c *lib.Config) {
 
 			if strings.HasPrefix(password, "{env}") {
 				password, err = loadFromEnv(password)
-				checkErr(err)
+				if err != nil {
+					return nil, err
+				}
 			}
 
 			user := &lib.User{
@@ -114,37 +130,696 @@ func parseUsers(raw []interface{}, c *lib.Config) {
 
 			if scope, ok := u["scope"].(string); ok {
 				user.Scope = scope
+			} else if c.ScopeTemplate != "" {
+				user.Scope, err = templateScope(c, username)
+				if err != nil {
+					return nil, err
+				}
 			}
 
 			if modify, ok := u["modify"].(bool); ok {
 				user.Modify = modify
 			}
 
-			if rules, ok := u["rules"].([]interface{}); ok {
-				user.Rules = append(c.User.Rules, parseRules(rules, user.Modify)...)
+			if tag, ok := u["log_tag"].(string); ok {
+				user.LogTag = tag
+			}
+
+			if rawRules, ok := u["rules"].([]interface{}); ok {
+				rules, err := parseRules(rawRules, user.Modify)
+				if err != nil {
+					return nil, err
+				}
+				user.Rules = append(c.User.Rules, rules...)
+			}
+
+			user.WriteWindows = c.User.WriteWindows
+			if rawWindows, ok := u["write_windows"]; ok {
+				user.WriteWindows, err = parseWriteWindows(rawWindows)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			user.Mounts = c.User.Mounts
+			if mounts, ok := u["mounts"].([]interface{}); ok {
+				user.Mounts, err = parseMounts(mounts, c)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			user.Handler, err = newUserHandler(user, c)
+			if err != nil {
+				return nil, err
+			}
+
+			users[username] = user
+		}
+	}
+
+	return users, nil
+}
+
+// newUserHandler creates the WebDAV handler of a user.
+func newUserHandler(u *lib.User, c *lib.Config) (*webdav.Handler, error) {
+	fs, err := newUserFileSystem(u, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webdav.Handler{
+		Prefix: c.User.Handler.Prefix,
+		FileSystem: lib.WebDavDir{
+			FileSystem: fs,
+			NoSniff:    c.NoSniff,
+			MimeTypes:  c.MimeTypes,
+		},
+		LockSystem: c.NewLockSystem(),
+		Logger: func(r *http.Request, err error) {
+			if r.Method == http.MethodPut {
+				if err == nil {
+					lib.RequestLogger(r).Info(fmt.Sprintf("[Success]: PUT %s ", r.URL.Path))
+				} else {
+					lib.RequestLogger(r).Warn(fmt.Sprintf("[Error]: PUT %s: %s", r.URL.Path, err))
+				}
+			}
+		},
+	}, nil
+}
+
+// newUserFactory creates a factory of users that inherit the global
+// settings, for the external authentication methods.
+func newUserFactory(c *lib.Config) *lib.UserFactory {
+	f := &lib.UserFactory{
+		Default: c.User,
+		New: func(username, scope string, modify, readOnly bool) (*lib.User, error) {
+			user := &lib.User{
+				Username: username,
+				Scope:    scope,
+				Modify:   modify,
+				ReadOnly: readOnly,
+				Rules:    c.User.Rules,
+				Mounts:   c.User.Mounts,
+
+				WriteWindows: c.User.WriteWindows,
+			}
+
+			var err error
+			user.Handler, err = newUserHandler(user, c)
+			return user, err
+		},
+	}
+
+	if c.ScopeTemplate != "" {
+		f.DefaultScope = func(username string) (string, error) {
+			return templateScope(c, username)
+		}
+	}
+
+	return f
+}
+
+// templateScope returns the scope of a user from the scope template, in
+// which {user} is replaced by the username and the environment variables,
+// such as $HOME or ${HOME}, are expanded. The directory is created, with
+// the configured mode and group, if it doesn't exist.
+func templateScope(c *lib.Config, username string) (string, error) {
+	if username == "" || username == "." || username == ".." || strings.ContainsAny(username, "/\\\x00") {
+		return "", fmt.Errorf("username %q can't be used in a scope", username)
+	}
+
+	scope := strings.ReplaceAll(os.ExpandEnv(c.ScopeTemplate), "{user}", username)
+	if strings.HasPrefix(scope, "s3://") || strings.HasPrefix(scope, "mem:") {
+		return scope, nil
+	}
+
+	if _, err := os.Stat(scope); err == nil || !os.IsNotExist(err) {
+		return scope, err
+	}
+
+	mode := c.DirMode
+	if mode == 0 {
+		mode = 0755
+	}
+	if err := os.MkdirAll(scope, mode); err != nil {
+		return "", err
+	}
+	if c.DirMode != 0 {
+		if err := os.Chmod(scope, c.DirMode); err != nil {
+			return "", err
+		}
+	}
+	if c.FileGroup > 0 {
+		if err := os.Chown(scope, -1, c.FileGroup); err != nil {
+			return "", err
+		}
+	}
+
+	return scope, nil
+}
+
+func parseMounts(raw []interface{}, c *lib.Config) ([]lib.Mount, error) {
+	mounts := []lib.Mount{}
+
+	for _, v := range raw {
+		if m, ok := v.(map[interface{}]interface{}); ok {
+			p, ok := m["path"].(string)
+			if !ok {
+				return nil, errors.New("mount needs a path")
+			}
+
+			scope, ok := m["scope"].(string)
+			if !ok {
+				return nil, errors.New("mount needs a scope")
+			}
+
+			p = path.Clean("/" + p)
+			if p == "/" {
+				return nil, errors.New("mount path can't be the root")
+			}
+
+			fs, err := newFileSystem(scope, c)
+			if err != nil {
+				return nil, err
+			}
+
+			mounts = append(mounts, lib.Mount{
+				Path:       p,
+				FileSystem: fs,
+			})
+		}
+	}
+
+	return mounts, nil
+}
+
+// newUserFileSystem creates the filesystem for a user's scope and mounts,
+// hiding the files that match the hide patterns and normalizing the names.
+func newUserFileSystem(u *lib.User, c *lib.Config) (webdav.FileSystem, error) {
+	fs, err := newFileSystem(u.Scope, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, single := splitScope(u.Scope); single {
+		u.ReadOnly = true
+	}
+
+	if len(u.Mounts) != 0 {
+		fs = &lib.MountFS{FileSystem: fs, Mounts: u.Mounts}
+	}
+
+	if len(c.HidePatterns) != 0 {
+		fs = lib.HideFS{FileSystem: fs, Patterns: c.HidePatterns, AllowWrite: c.AllowWriteHidden}
+	}
+
+	fs = lib.NoSpaceFS{FileSystem: fs}
+	return lib.NewNormalizeFS(fs, c.NormalizeFilenames), nil
+}
+
+// newFileSystem creates the filesystem for a scope. A scope of "mem:" or
+// "mem:<bytes>" is served from memory, optionally bounded to the given size,
+// and a scope of "s3://bucket/prefix" is served from an S3 bucket. A scope
+// can also be a single file, or a glob pattern of the files of a directory.
+// Uploads to directories are atomic if enabled. If an encryption key is set, the contents are encrypted at rest.
+func newFileSystem(scope string, c *lib.Config) (webdav.FileSystem, error) {
+	scope, pattern, single := splitScope(scope)
+	var fs webdav.FileSystem = webdav.Dir(scope)
+
+	if strings.HasPrefix(scope, "s3://") {
+		u, err := url.Parse(scope)
+		if err != nil {
+			return nil, err
+		}
+		fs = lib.NewS3FS(u.Host, u.Path, c.S3)
+	} else if strings.HasPrefix(scope, "mem:") {
+		var limit int64
+		if raw := strings.TrimPrefix(scope, "mem:"); raw != "" {
+			var err error
+			limit, err = strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		fs = lib.NewMemFS(limit)
+	} else {
+		if c.FileMode != 0 || c.DirMode != 0 || c.FileGroup > 0 {
+			fs = lib.ModeFS{FileSystem: fs, Root: scope, FileMode: c.FileMode, DirMode: c.DirMode, Group: c.FileGroup}
+		}
+
+		if c.AtomicWrites {
+			fs = lib.AtomicFS{FileSystem: fs}
+		}
+
+		if c.Symlinks != lib.SymlinksFollow {
+			fs = lib.SymlinkFS{FileSystem: fs, Root: scope, Policy: c.Symlinks}
+		}
+	}
+
+	if len(c.EncryptionKey) != 0 {
+		cryptFS, err := lib.NewCryptFS(fs, c.EncryptionKey, c.EncryptNames)
+		if err != nil {
+			return nil, err
+		}
+		fs = cryptFS
+	}
+
+	if pattern != "" {
+		fs = lib.GlobFS{FileSystem: fs, Pattern: pattern, ReadOnly: single}
+	}
+
+	return fs, nil
+}
+
+// splitScope splits a directory scope that is a single file, or whose last
+// element is a glob pattern, such as "/var/log/*.log", into its directory and
+// the pattern of the files it exposes. single reports a single file. Existing
+// directories and files are never patterns, even if their names contain the
+// special characters of the patterns.
+func splitScope(scope string) (dir, pattern string, single bool) {
+	if strings.HasPrefix(scope, "s3://") || strings.HasPrefix(scope, "mem:") {
+		return scope, "", false
+	}
+
+	base := filepath.Base(scope)
+	info, err := os.Stat(scope)
+	switch {
+	case err == nil && info.IsDir():
+		return scope, "", false
+	case err == nil && info.Mode().IsRegular():
+		return filepath.Dir(scope), globEscape(base), true
+	case strings.ContainsAny(base, "*?["):
+		return filepath.Dir(scope), base, false
+	default:
+		return scope, "", false
+	}
+}
+
+// globEscape escapes the characters of name that are special in glob
+// patterns.
+func globEscape(name string) string {
+	var b strings.Builder
+	for _, ch := range name {
+		if strings.ContainsRune(`*?[\`, ch) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// tusSettings are the tus_* settings.
+type tusSettings struct {
+	path       string
+	dir        string
+	expiration time.Duration
+	maxSize    int64
+}
+
+// parseTus parses the tus_* settings. The uploads are kept in a directory
+// of tempDir by default.
+func parseTus(flags *pflag.FlagSet, tempDir string) (tusSettings, error) {
+	expiration, err := time.ParseDuration(getOpt(flags, "tus_expiration"))
+	if err != nil {
+		return tusSettings{}, fmt.Errorf("tus_expiration: %w", err)
+	}
+
+	maxSize, err := strconv.ParseInt(getOpt(flags, "tus_max_size"), 10, 64)
+	if err != nil {
+		return tusSettings{}, fmt.Errorf("tus_max_size: %w", err)
+	}
+
+	dir := getOpt(flags, "tus_dir")
+	if dir == "" {
+		dir = filepath.Join(tempDir, "webdav-tus")
+	}
+
+	return tusSettings{
+		path:       getOpt(flags, "tus_path"),
+		dir:        dir,
+		expiration: expiration,
+		maxSize:    maxSize,
+	}, nil
+}
+
+// newTus creates the Tus endpoint of the tus_* settings.
+func newTus(flags *pflag.FlagSet, tempDir string) (*lib.Tus, error) {
+	s, err := parseTus(flags, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.NewTus(s.path, s.dir, s.expiration, s.maxSize)
+}
+
+// thumbSettings are the thumb_* settings.
+type thumbSettings struct {
+	sizes       []int
+	cacheDir    string
+	cacheSize   int64
+	concurrency int
+}
+
+// parseThumbnails parses the thumb_* settings. The cache is a directory of
+// tempDir by default.
+func parseThumbnails(flags *pflag.FlagSet, tempDir string) (thumbSettings, error) {
+	sizes := []int{}
+	for _, raw := range strings.Split(getOpt(flags, "thumb_sizes"), ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || size < 1 {
+			return thumbSettings{}, fmt.Errorf("thumb_sizes: invalid size %q", raw)
+		}
+		sizes = append(sizes, size)
+	}
+
+	cacheSize, err := strconv.ParseInt(getOpt(flags, "thumb_cache_size"), 10, 64)
+	if err != nil {
+		return thumbSettings{}, fmt.Errorf("thumb_cache_size: %w", err)
+	}
+
+	concurrency, err := strconv.Atoi(getOpt(flags, "thumb_concurrency"))
+	if err != nil {
+		return thumbSettings{}, fmt.Errorf("thumb_concurrency: %w", err)
+	}
+
+	cacheDir := getOpt(flags, "thumb_cache_dir")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(tempDir, "webdav-thumbnails")
+	}
+
+	return thumbSettings{
+		sizes:       sizes,
+		cacheDir:    cacheDir,
+		cacheSize:   cacheSize,
+		concurrency: concurrency,
+	}, nil
+}
+
+// newThumbnails creates the Thumbnails of the thumb_* settings.
+func newThumbnails(flags *pflag.FlagSet, tempDir string) (*lib.Thumbnails, error) {
+	s, err := parseThumbnails(flags, tempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnails, err := lib.NewThumbnails(s.cacheDir, s.sizes, s.cacheSize<<20, s.concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	cleanTempFiles(s.cacheDir)
+	return thumbnails, nil
+}
+
+// parseSymlinks returns the symbolic links policy, which is follow if
+// follow_symlinks is set.
+func parseSymlinks(flags *pflag.FlagSet) (string, error) {
+	if getOptB(flags, "follow_symlinks") {
+		return lib.SymlinksFollow, nil
+	}
+
+	switch policy := getOpt(flags, "symlinks"); policy {
+	case lib.SymlinksFollow, lib.SymlinksDeny, lib.SymlinksScope:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%q is not follow, deny or scope", policy)
+	}
+}
+
+// parseNormalization returns the Unicode normalization of the file names.
+func parseNormalization(flags *pflag.FlagSet) (string, error) {
+	switch form := strings.ToLower(getOpt(flags, "normalize_filenames")); form {
+	case "", lib.NormalizeNone, lib.NormalizeNFC, lib.NormalizeNFD:
+		return form, nil
+	default:
+		return "", fmt.Errorf("%q is not nfc, nfd or none", form)
+	}
+}
+
+// parseHidePatterns returns the patterns of the hidden files, along with
+// the dot files if hide_dotfiles is set.
+func parseHidePatterns(flags *pflag.FlagSet) ([]string, error) {
+	patterns := stringList(getRawOpt(flags, "hide_patterns"))
+	if getOptB(flags, "hide_dotfiles") {
+		patterns = append(patterns, ".*")
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+// parseEncryptionKey returns the key of encryption_key, in hexadecimal, or
+// the one in the file of encryption_key_file, raw or in hexadecimal. There
+// is no key if neither is set.
+func parseEncryptionKey(flags *pflag.FlagSet) ([]byte, error) {
+	raw := []byte(getOpt(flags, "encryption_key"))
+	if len(raw) == 0 {
+		keyFile := getOpt(flags, "encryption_key_file")
+		if keyFile == "" {
+			return nil, nil
+		}
+
+		var err error
+		if raw, err = os.ReadFile(keyFile); err != nil {
+			return nil, err
+		}
+		if len(raw) == lib.CryptKeySize {
+			return raw, nil
+		}
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != lib.CryptKeySize {
+		return nil, fmt.Errorf("the key must be %d random bytes, written as %d hexadecimal digits", lib.CryptKeySize, 2*lib.CryptKeySize)
+	}
+	return key, nil
+}
+
+// scopesOverlap returns an error for each pair of users whose scopes, by
+// username, are the same directory or one is inside the other. Memory
+// scopes never overlap, and buckets are compared by their path.
+func scopesOverlap(scopes map[string]string) []error {
+	usernames := make([]string, 0, len(scopes))
+	dirs := map[string]string{}
+	for username, scope := range scopes {
+		if strings.HasPrefix(scope, "mem:") {
+			continue
+		}
+
+		dir, _, _ := splitScope(scope)
+		if !strings.HasPrefix(scope, "s3://") {
+			if abs, err := filepath.Abs(dir); err == nil {
+				dir = abs
+			}
+		}
+		dirs[username] = filepath.Clean(dir)
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	errs := []error{}
+	for i, a := range usernames {
+		for _, b := range usernames[i+1:] {
+			if scopeInside(dirs[a], dirs[b]) || scopeInside(dirs[b], dirs[a]) {
+				errs = append(errs, fmt.Errorf("the scopes of %s and %s overlap", a, b))
 			}
+		}
+	}
+	return errs
+}
+
+// scopeInside checks if dir is parent or inside it.
+func scopeInside(dir, parent string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// prepareTempDir returns the directory of the temporary files, which is
+// the default temporary directory if raw is empty. It is created if it
+// doesn't exist and must be writable.
+func prepareTempDir(raw string) (string, error) {
+	dir := raw
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(dir, ".webdav-validate-*")
+	if err != nil {
+		return "", fmt.Errorf("%q is not writable", dir)
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	cleanTempFiles(dir)
+	return dir, nil
+}
+
+// cleanTempFiles removes the stale temporary files of dir, left by a
+// server that didn't stop cleanly. Files younger than an hour may still be
+// in use by another server sharing the directory.
+func cleanTempFiles(dir string) {
+	removed, err := lib.CleanTempFiles(dir, time.Hour)
+	if err != nil {
+		log.Printf("cleaning the temporary files of %s failed: %s", dir, err)
+	} else if removed > 0 {
+		log.Printf("removed %d stale temporary files from %s", removed, dir)
+	}
+}
+
+// loadPage returns an HTML page, given inline if it starts with "<", or by
+// the path of its file otherwise. An empty page is nil.
+func loadPage(raw string) ([]byte, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(strings.TrimSpace(raw), "<") {
+		return []byte(raw), nil
+	}
+	return os.ReadFile(raw)
+}
+
+// parseMode parses a file mode in octal, such as "0664". An empty mode is
+// zero.
+func parseMode(raw string) (os.FileMode, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	perm, err := strconv.ParseUint(raw, 8, 32)
+	if err != nil || perm > 0o7777 {
+		return 0, fmt.Errorf("%q is not an octal mode", raw)
+	}
+
+	// The special bits are flags of their own in os.FileMode.
+	mode := os.FileMode(perm & 0o777)
+	if perm&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if perm&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if perm&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// parseMimeTypes parses the map of extensions to mime types. Extensions
+// are lowercased and get a leading dot if they lack one.
+func parseMimeTypes(raw interface{}) (map[string]string, error) {
+	mimeTypes := map[string]string{}
+
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return mimeTypes, nil
+	}
+
+	for ext, value := range m {
+		mimeType, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid mime type for %q", ext)
+		}
+
+		if _, _, err := mime.ParseMediaType(mimeType); err != nil {
+			return nil, fmt.Errorf("invalid mime type %q for %q: %w", mimeType, ext, err)
+		}
+
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		mimeTypes[ext] = mimeType
+	}
+
+	return mimeTypes, nil
+}
+
+// parseHeaders parses the map of static response headers. Names are
+// canonicalized, as the keys of the configuration are lowercased.
+func parseHeaders(raw interface{}) (http.Header, error) {
+	headers := http.Header{}
+
+	m, ok := raw.(map[string]interface{})
+	if !ok {
+		return headers, nil
+	}
+
+	for name, value := range m {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for header %q", name)
+		}
+
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(s) {
+			return nil, fmt.Errorf("invalid value for header %q", name)
+		}
+
+		headers.Set(name, s)
+	}
+
+	return headers, nil
+}
 
-			user.Handler = &webdav.Handler{
-				Prefix: c.User.Handler.Prefix,
-				FileSystem: lib.WebDavDir{
-					Dir:     webdav.Dir(user.Scope),
-					NoSniff: c.NoSniff,
-				},
-				LockSystem: webdav.NewMemLS(),
-				Logger: func(r *http.Request, err error) {
-					if r.Method == http.MethodPut {
-						if err == nil {
-							zap.L().Info(fmt.Sprintf("[Success]: PUT %s ", r.URL.Path))
-						} else {
-							zap.L().Warn(fmt.Sprintf("[Error]: PUT %s: %s", r.URL.Path, err))
-						}
-					}
-				},
+// parseWriteWindows parses a list of time windows, such as
+// "mon-fri 08:00-20:00". As days are separated by commas, the windows given
+// as a string, such as from an environment variable, are separated by
+// semicolons.
+func parseWriteWindows(raw interface{}) ([]lib.TimeWindow, error) {
+	items := stringList(raw)
+	if s, ok := raw.(string); ok {
+		items = []string{}
+		for _, item := range strings.Split(s, ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
 			}
+		}
+	}
 
-			c.Users[username] = user
+	windows := []lib.TimeWindow{}
+	for _, s := range items {
+		w, err := lib.ParseTimeWindow(s)
+		if err != nil {
+			return nil, fmt.Errorf("write_windows: %w", err)
 		}
+		windows = append(windows, w)
 	}
+	return windows, nil
+}
+
+// stringList returns the strings of a list setting, which can also be given
+// as a comma separated string, such as from an environment variable.
+func stringList(raw interface{}) []string {
+	list := []string{}
+
+	switch raw := raw.(type) {
+	case []interface{}:
+		for _, item := range raw {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+	case string:
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
 }
 
 func parseCors(cfg map[string]interface{}, c *lib.Config) {
@@ -193,14 +868,6 @@ func readConfig(flags *pflag.FlagSet) *lib.Config {
 			Scope:  getOpt(flags, "scope"),
 			Modify: getOptB(flags, "modify"),
 			Rules:  []*lib.Rule{},
-			Handler: &webdav.Handler{
-				Prefix: getOpt(flags, "prefix"),
-				FileSystem: lib.WebDavDir{
-					Dir:     webdav.Dir(getOpt(flags, "scope")),
-					NoSniff: getOptB(flags, "nosniff"),
-				},
-				LockSystem: webdav.NewMemLS(),
-			},
 		},
 		Auth:    getOptB(flags, "auth"),
 		NoSniff: getOptB(flags, "nosniff"),
@@ -208,25 +875,210 @@ func readConfig(flags *pflag.FlagSet) *lib.Config {
 			Enabled:     false,
 			Credentials: false,
 		},
-		Users:     map[string]*lib.User{},
-		LogFormat: getOpt(flags, "log_format"),
+		Users:        map[string]*lib.User{},
+		LogFormat:    getOpt(flags, "log_format"),
+		EncryptNames: getOptB(flags, "encrypt_names"),
+		AtomicWrites: getOptB(flags, "atomic_writes"),
+
+		CaseInsensitive:   getOptB(flags, "case_insensitive"),
+		DavCompliance:     getOpt(flags, "dav_compliance"),
+		DirListing:        getOptB(flags, "dir_listing"),
+		BrowserFiles:      getOptB(flags, "browser_files"),
+		Robots:            []byte(getOpt(flags, "robots_txt")),
+		ShowHidden:        getOptB(flags, "show_hidden"),
+		ForceDownload:     getOptB(flags, "force_download"),
+		DecompressUploads: getOptB(flags, "decompress_uploads"),
+		PartialUpdates:    getOptB(flags, "partial_updates"),
+		ScopeTemplate:     getOpt(flags, "scope_template"),
+	}
+
+	cfg.MaxPropfindDepth = getOpt(flags, "max_propfind_depth")
+	if err := lib.ValidateDepth(cfg.MaxPropfindDepth); err != nil {
+		log.Fatalf("invalid max_propfind_depth: %s", err)
+	}
+
+	maxPropfindEntries, err := strconv.Atoi(getOpt(flags, "max_propfind_entries"))
+	if err != nil {
+		log.Fatalf("invalid max_propfind_entries: %s", err)
+	}
+	cfg.MaxPropfindEntries = maxPropfindEntries
+
+	cfg.MaxUploadSize, err = strconv.ParseInt(getOpt(flags, "max_upload_size"), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid max_upload_size: %s", err)
+	}
+
+	cfg.FileMode, err = parseMode(getOpt(flags, "file_mode"))
+	if err != nil {
+		log.Fatalf("invalid file_mode: %s", err)
+	}
+	cfg.DirMode, err = parseMode(getOpt(flags, "dir_mode"))
+	if err != nil {
+		log.Fatalf("invalid dir_mode: %s", err)
+	}
+	if group := getOpt(flags, "file_group"); group != "" {
+		cfg.FileGroup, err = lookupGroup(group)
+		if err != nil {
+			log.Fatalf("invalid file_group: %s", err)
+		}
+	}
+
+	maxLockTimeout, err := time.ParseDuration(getOpt(flags, "max_lock_timeout"))
+	checkErr(err)
+	cfg.MaxLockTimeout = maxLockTimeout
+
+	cfg.MimeTypes, err = parseMimeTypes(getRawOpt(flags, "mime_types"))
+	checkErr(err)
+
+	cfg.HidePatterns, err = parseHidePatterns(flags)
+	if err != nil {
+		log.Fatalf("invalid hide_patterns: %s", err)
+	}
+	cfg.AllowWriteHidden = getOptB(flags, "allow_write_hidden")
+
+	cfg.NormalizeFilenames, err = parseNormalization(flags)
+	if err != nil {
+		log.Fatalf("invalid normalize_filenames: %s", err)
+	}
+
+	cfg.Symlinks, err = parseSymlinks(flags)
+	if err != nil {
+		log.Fatalf("invalid symlinks: %s", err)
+	}
+
+	tempDir, err := prepareTempDir(getOpt(flags, "temp_dir"))
+	if err != nil {
+		log.Fatalf("invalid temp_dir: %s", err)
+	}
+
+	cfg.S3 = lib.S3Config{
+		Endpoint:  getOpt(flags, "s3_endpoint"),
+		Region:    getOpt(flags, "s3_region"),
+		AccessKey: getOpt(flags, "s3_access_key"),
+		SecretKey: getOpt(flags, "s3_secret_key"),
+		TempDir:   tempDir,
+	}
+
+	if webhookURL := getOpt(flags, "webhook_url"); webhookURL != "" {
+		cfg.Webhook = lib.NewWebhook(webhookURL, getOpt(flags, "webhook_secret"), 100)
+	}
+
+	trustedProxies, err := lib.ParseTrustedProxies(getOpt(flags, "trusted_proxies"))
+	checkErr(err)
+	cfg.TrustedProxies = trustedProxies
+
+	cfg.EncryptionKey, err = parseEncryptionKey(flags)
+	if err != nil {
+		log.Fatalf("invalid encryption key: %s", err)
+	}
+
+	rawMounts := getRawOpt(flags, "mounts")
+	if mounts, ok := rawMounts.([]interface{}); ok {
+		cfg.User.Mounts, err = parseMounts(mounts, cfg)
+		checkErr(err)
+	}
+
+	fs, err := newUserFileSystem(cfg.User, cfg)
+	checkErr(err)
+
+	cfg.User.Handler = &webdav.Handler{
+		Prefix: getOpt(flags, "prefix"),
+		FileSystem: lib.WebDavDir{
+			FileSystem: fs,
+			NoSniff:    cfg.NoSniff,
+			MimeTypes:  cfg.MimeTypes,
+		},
+		LockSystem: cfg.NewLockSystem(),
 	}
 
-	rawRules := v.Get("rules")
+	rawRules := getRawOpt(flags, "rules")
 	if rules, ok := rawRules.([]interface{}); ok {
-		cfg.User.Rules = parseRules(rules, cfg.User.Modify)
+		cfg.User.Rules, err = parseRules(rules, cfg.User.Modify)
+		checkErr(err)
+	}
+
+	cfg.User.WriteWindows, err = parseWriteWindows(getRawOpt(flags, "write_windows"))
+	checkErr(err)
+
+	if timezone := getOpt(flags, "timezone"); timezone != "" {
+		cfg.Location, err = time.LoadLocation(timezone)
+		if err != nil {
+			log.Fatalf("invalid timezone: %s", err)
+		}
 	}
 
-	rawUsers := v.Get("users")
+	rawUsers := getRawOpt(flags, "users")
 	if users, ok := rawUsers.([]interface{}); ok {
-		parseUsers(users, cfg)
+		cfg.Users, err = parseUsers(users, cfg)
+		checkErr(err)
+	}
+
+	if getOptB(flags, "exclusive_scopes") {
+		scopes := map[string]string{}
+		for username, user := range cfg.Users {
+			scopes[username] = user.Scope
+		}
+		if errs := scopesOverlap(scopes); len(errs) != 0 {
+			log.Fatalf("invalid users: %s", errs[0])
+		}
 	}
 
-	rawCors := v.Get("cors")
+	rawCors := getRawOpt(flags, "cors")
 	if cors, ok := rawCors.(map[string]interface{}); ok {
 		parseCors(cors, cfg)
 	}
 
+	// Users authenticated by external services.
+	external := newUserFactory(cfg)
+
+	if authURL := getOpt(flags, "auth_webhook_url"); authURL != "" {
+		if !strings.HasPrefix(authURL, "https://") {
+			log.Print("auth_webhook_url is not using TLS, credentials will be sent in clear text")
+		}
+
+		ttl, err := time.ParseDuration(getOpt(flags, "auth_webhook_ttl"))
+		checkErr(err)
+
+		cfg.AuthWebhook = lib.NewAuthWebhook(authURL, ttl, external)
+	}
+
+	jwtSecret, jwksURL := getOpt(flags, "jwt_secret"), getOpt(flags, "jwt_jwks_url")
+	if jwtSecret != "" || jwksURL != "" {
+		cfg.JWT = &lib.JWTAuth{
+			Secret:        []byte(jwtSecret),
+			JWKSURL:       jwksURL,
+			UsernameClaim: getOpt(flags, "jwt_username_claim"),
+			ScopeClaim:    getOpt(flags, "jwt_scope_claim"),
+			Issuer:        getOpt(flags, "jwt_issuer"),
+			Audience:      getOpt(flags, "jwt_audience"),
+			Users:         external,
+		}
+	}
+
+	cfg.Shares = newShares(flags)
+
+	if getOptB(flags, "tus") {
+		cfg.Tus, err = newTus(flags, tempDir)
+		if err != nil {
+			log.Fatalf("invalid tus settings: %s", err)
+		}
+	}
+
+	if getOptB(flags, "thumbnails") {
+		cfg.Thumbnails, err = newThumbnails(flags, tempDir)
+		if err != nil {
+			log.Fatalf("invalid thumbnails settings: %s", err)
+		}
+	}
+
+	cfg.SetReadOnly(getOptB(flags, "read_only"))
+
+	cfg.UnauthorizedRedirect = getOpt(flags, "unauthorized_redirect")
+	cfg.UnauthorizedPage, err = loadPage(getOpt(flags, "unauthorized_page"))
+	if err != nil {
+		log.Fatalf("invalid unauthorized_page: %s", err)
+	}
+
 	if len(cfg.Users) != 0 && !cfg.Auth {
 		log.Print("Users will be ignored due to auth=false")
 	}
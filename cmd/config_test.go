@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hacdias/webdav/v4/lib"
+	v "github.com/spf13/viper"
+)
+
+// testConfig reads the configuration file with the given YAML content, in
+// which {dir} is replaced by a temporary directory, and returns it with the
+// directory.
+func testConfig(t *testing.T, content string) (*lib.Config, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.yml")
+	content = "temp_dir: " + filepath.Join(dir, "tmp") + "\n" + content
+	content = strings.ReplaceAll(content, "{dir}", dir)
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	v.Reset()
+	t.Cleanup(v.Reset)
+	v.SetConfigFile(name)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	return readConfig(rootCmd.PersistentFlags()), dir
+}
+
+func serve(cfg *lib.Config, r *http.Request) int {
+	w := httptest.NewRecorder()
+	cfg.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAuthWebhookReadOnly(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"read_only":true}`))
+	}))
+	defer webhook.Close()
+
+	cfg, dir := testConfig(t, `
+scope: {dir}
+modify: true
+auth_webhook_url: `+webhook.URL+`
+`)
+
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []*http.Request{
+		httptest.NewRequest("PUT", "/new.txt", nil),
+		httptest.NewRequest("DELETE", "/file.txt", nil),
+		httptest.NewRequest("MOVE", "/file.txt", nil),
+	}
+	requests[2].Header.Set("Destination", "/moved.txt")
+
+	for _, r := range requests {
+		r.SetBasicAuth("alice", "secret")
+		if code := serve(cfg, r); code != http.StatusForbidden {
+			t.Errorf("%s %s: got %d, want %d", r.Method, r.URL.Path, code, http.StatusForbidden)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "file.txt")); err != nil {
+		t.Errorf("file.txt was modified: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("new.txt was created")
+	}
+
+	r := httptest.NewRequest("GET", "/file.txt", nil)
+	r.SetBasicAuth("alice", "secret")
+	if code := serve(cfg, r); code != http.StatusOK {
+		t.Errorf("GET /file.txt: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, follow := range []bool{false, true} {
+		cfg, dir := testConfig(t, `
+scope: {dir}
+auth: false
+modify: true
+follow_symlinks: `+strconv.FormatBool(follow)+`
+`)
+
+		if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("inside"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for name, target := range map[string]string{
+			"secret.txt": filepath.Join(outside, "secret.txt"),
+			"escape":     outside,
+			"inside.txt": filepath.Join(dir, "file.txt"),
+		} {
+			if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		escaping := http.StatusForbidden
+		if follow {
+			escaping = http.StatusOK
+		}
+
+		tests := []struct {
+			method string
+			path   string
+			status int
+		}{
+			{"GET", "/secret.txt", escaping},
+			{"GET", "/escape/secret.txt", escaping},
+			{"GET", "/escape/../escape/secret.txt", escaping},
+			{"GET", "/inside.txt", http.StatusOK},
+			{"GET", "/file.txt", http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			if code := serve(cfg, httptest.NewRequest(tt.method, tt.path, nil)); code != tt.status {
+				t.Errorf("follow_symlinks %t: %s %s: got %d, want %d", follow, tt.method, tt.path, code, tt.status)
+			}
+		}
+
+		if follow {
+			continue
+		}
+
+		// Nothing can be written through the links either.
+		r := httptest.NewRequest("PUT", "/escape/new.txt", strings.NewReader("new"))
+		if code := serve(cfg, r); code != http.StatusForbidden {
+			t.Errorf("PUT /escape/new.txt: got %d, want %d", code, http.StatusForbidden)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "new.txt")); !os.IsNotExist(err) {
+			t.Errorf("new.txt was created outside of the scope")
+		}
+
+		// Nor are they listed.
+		r = httptest.NewRequest("PROPFIND", "/", nil)
+		r.Header.Set("Depth", "1")
+		w := httptest.NewRecorder()
+		cfg.ServeHTTP(w, r)
+		if !strings.Contains(w.Body.String(), "file.txt") {
+			t.Errorf("PROPFIND / doesn't list file.txt: %s", w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "secret.txt") || strings.Contains(w.Body.String(), "escape") {
+			t.Errorf("PROPFIND / lists the links leaving the scope: %s", w.Body.String())
+		}
+	}
+}
+
+func TestSplitScope(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"data[1]", "what?", "logs"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dump[1].sql"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scope   string
+		dir     string
+		pattern string
+		single  bool
+	}{
+		{filepath.Join(dir, "data[1]"), filepath.Join(dir, "data[1]"), "", false},
+		{filepath.Join(dir, "what?"), filepath.Join(dir, "what?"), "", false},
+		{filepath.Join(dir, "logs"), filepath.Join(dir, "logs"), "", false},
+		{filepath.Join(dir, "logs", "*.log"), filepath.Join(dir, "logs"), "*.log", false},
+		{filepath.Join(dir, "data[0-9]"), dir, "data[0-9]", false},
+		{filepath.Join(dir, "dump[1].sql"), dir, `dump\[1].sql`, true},
+		{filepath.Join(dir, "missing"), filepath.Join(dir, "missing"), "", false},
+		{"mem:100", "mem:100", "", false},
+		{"s3://bucket/*", "s3://bucket/*", "", false},
+	}
+
+	for _, tt := range tests {
+		dir, pattern, single := splitScope(tt.scope)
+		if dir != tt.dir || pattern != tt.pattern || single != tt.single {
+			t.Errorf("%s: got %q, %q, %t, want %q, %q, %t", tt.scope, dir, pattern, single, tt.dir, tt.pattern, tt.single)
+		}
+	}
+}
+
+func TestGlobScope(t *testing.T) {
+	root := t.TempDir()
+	data := filepath.Join(root, "data[1]")
+	if err := os.Mkdir(data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.log", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(data, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name  string
+		scope string
+		files map[string]int
+	}{
+		{
+			name:  "directory with special characters",
+			scope: data,
+			files: map[string]int{"/a.log": http.StatusOK, "/b.txt": http.StatusOK},
+		},
+		{
+			name:  "pattern",
+			scope: filepath.Join(root, "data[1]", "*.log"),
+			files: map[string]int{"/a.log": http.StatusOK, "/b.txt": http.StatusNotFound},
+		},
+	} {
+		cfg, _ := testConfig(t, `
+scope: "`+tt.scope+`"
+auth: false
+`)
+
+		for name, status := range tt.files {
+			if code := serve(cfg, httptest.NewRequest("GET", name, nil)); code != status {
+				t.Errorf("%s: GET %s: got %d, want %d", tt.name, name, code, status)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/hacdias/webdav/v4/lib"
+	"github.com/spf13/pflag"
+	v "github.com/spf13/viper"
+)
+
+var (
+	effectiveMu sync.Mutex
+	effective   map[string]interface{}
+)
+
+// EffectiveConfig returns the settings the server was last started with,
+// after merging the flags, the environment and the configuration file.
+// Passwords, keys and secrets are redacted.
+func EffectiveConfig() map[string]interface{} {
+	effectiveMu.Lock()
+	defer effectiveMu.Unlock()
+
+	if effective == nil {
+		return nil
+	}
+
+	c := make(map[string]interface{}, len(effective))
+	for k, v := range effective {
+		c[k] = v
+	}
+	return c
+}
+
+// setEffectiveConfig records the settings of the server listening on addr.
+func setEffectiveConfig(flags *pflag.FlagSet, cfg *lib.Config, addr net.Addr) {
+	usernames := make([]string, 0, len(cfg.Users))
+	for username := range cfg.Users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	// The users are listed without their password.
+	users := make([]map[string]interface{}, 0, len(usernames))
+	for _, username := range usernames {
+		u := cfg.Users[username]
+		users = append(users, map[string]interface{}{
+			"username":      u.Username,
+			"scope":         u.Scope,
+			"modify":        u.Modify,
+			"read_only":     u.ReadOnly,
+			"rules":         len(u.Rules),
+			"mounts":        len(u.Mounts),
+			"write_windows": len(u.WriteWindows),
+			"log_tag":       u.LogTag,
+		})
+	}
+
+	headers := map[string]string{}
+	if h, err := parseHeaders(getRawOpt(flags, "headers")); err == nil {
+		for name := range h {
+			headers[name] = h.Get(name)
+		}
+	}
+
+	c := map[string]interface{}{
+		"config_file":           v.ConfigFileUsed(),
+		"address":               addr.String(),
+		"port_range":            getOpt(flags, "port_range"),
+		"ip_version":            getOpt(flags, "ip_version"),
+		"dual_stack":            getOptB(flags, "dual_stack"),
+		"listen_backlog":        getOpt(flags, "listen_backlog"),
+		"tcp_keepalive":         getOpt(flags, "tcp_keepalive"),
+		"tcp_nodelay":           getOptB(flags, "tcp_nodelay"),
+		"tls":                   getOptB(flags, "tls"),
+		"http2":                 getOptB(flags, "http2"),
+		"proxy_protocol":        getOptB(flags, "proxy_protocol"),
+		"prefix":                cfg.User.Handler.Prefix,
+		"auth":                  cfg.Auth,
+		"unauthorized_page":     cfg.UnauthorizedPage != nil,
+		"unauthorized_redirect": cfg.UnauthorizedRedirect,
+		"scope":                 cfg.User.Scope,
+		"scope_template":        cfg.ScopeTemplate,
+		"exclusive_scopes":      getOptB(flags, "exclusive_scopes"),
+		"modify":                cfg.User.Modify,
+		"read_only":             cfg.ReadOnly(),
+		"write_windows":         len(cfg.User.WriteWindows),
+		"timezone":              getOpt(flags, "timezone"),
+		"rules":                 len(cfg.User.Rules),
+		"mounts":                len(cfg.User.Mounts),
+		"users":                 users,
+		"symlinks":              cfg.Symlinks,
+		"hide_patterns":         cfg.HidePatterns,
+		"allow_write_hidden":    cfg.AllowWriteHidden,
+		"normalize_filenames":   cfg.NormalizeFilenames,
+		"case_insensitive":      cfg.CaseInsensitive,
+		"atomic_writes":         cfg.AtomicWrites,
+		"temp_dir":              cfg.S3.TempDir,
+		"file_mode":             getOpt(flags, "file_mode"),
+		"dir_mode":              getOpt(flags, "dir_mode"),
+		"file_group":            getOpt(flags, "file_group"),
+		"max_propfind_depth":    cfg.MaxPropfindDepth,
+		"max_propfind_entries":  cfg.MaxPropfindEntries,
+		"max_upload_size":       cfg.MaxUploadSize,
+		"max_lock_timeout":      cfg.MaxLockTimeout.String(),
+		"nosniff":               cfg.NoSniff,
+		"force_download":        cfg.ForceDownload,
+		"decompress_uploads":    cfg.DecompressUploads,
+		"partial_updates":       cfg.PartialUpdates,
+		"dir_listing":           cfg.DirListing,
+		"browser_files":         cfg.BrowserFiles,
+		"show_hidden":           cfg.ShowHidden,
+		"thumbnails":            cfg.Thumbnails != nil,
+		"tus":                   cfg.Tus != nil,
+		"mime_types":            cfg.MimeTypes,
+		"cors":                  cfg.Cors.Enabled,
+		"trusted_proxies":       getOpt(flags, "trusted_proxies"),
+		"server_header":         getOpt(flags, "server_header"),
+		"headers":               headers,
+		"dav_compliance":        cfg.DavCompliance,
+		"request_timeout":       getOpt(flags, "request_timeout"),
+		"transfer_timeout":      getOpt(flags, "transfer_timeout"),
+		"transfer_idle_timeout": getOpt(flags, "transfer_idle_timeout"),
+		"read_header_timeout":   getOpt(flags, "read_header_timeout"),
+		"body_idle_timeout":     getOpt(flags, "body_idle_timeout"),
+		"drain_timeout":         getOpt(flags, "drain_timeout"),
+		"stats_file":            getOpt(flags, "stats_file"),
+		"stats_interval":        getOpt(flags, "stats_interval"),
+		"disable_keepalive":     getOptB(flags, "disable_keepalive"),
+		"keepalive_timeout":     getOpt(flags, "keepalive_timeout"),
+		"log_format":            cfg.LogFormat,
+		"log_path":              getOpt(flags, "log_path"),
+		"log_level":             getOpt(flags, "log_level"),
+		"debug_requests":        getOptB(flags, "debug_requests"),
+		"debug_body_size":       getOpt(flags, "debug_body_size"),
+		"webhook_url":           getOpt(flags, "webhook_url"),
+		"webhook_secret":        redact(getOpt(flags, "webhook_secret")),
+		"auth_webhook_url":      getOpt(flags, "auth_webhook_url"),
+		"jwt_secret":            redact(getOpt(flags, "jwt_secret")),
+		"jwt_jwks_url":          getOpt(flags, "jwt_jwks_url"),
+		"jwt_issuer":            getOpt(flags, "jwt_issuer"),
+		"jwt_audience":          getOpt(flags, "jwt_audience"),
+		"admin_address":         getOpt(flags, "admin_address"),
+		"admin_token":           redact(getOpt(flags, "admin_token")),
+		"share_secret":          redact(getOpt(flags, "share_secret")),
+		"share_revoked_file":    getOpt(flags, "share_revoked_file"),
+		"encryption_key":        redact(string(cfg.EncryptionKey)),
+		"encrypt_names":         cfg.EncryptNames,
+		"s3_endpoint":           cfg.S3.Endpoint,
+		"s3_region":             cfg.S3.Region,
+		"s3_access_key":         cfg.S3.AccessKey,
+		"s3_secret_key":         redact(cfg.S3.SecretKey),
+	}
+
+	if cfg.Tus != nil {
+		c["tus_path"] = cfg.Tus.Path
+	}
+
+	if getOptB(flags, "tls") {
+		c["cert"] = getOpt(flags, "cert")
+		c["key"] = getOpt(flags, "key")
+		c["ocsp_stapling"] = getOptB(flags, "ocsp_stapling")
+		if certs, err := parseTLSCertificates(getRawOpt(flags, "tls_certificates")); err == nil {
+			hostnames := []string{}
+			for _, cert := range certs {
+				hostnames = append(hostnames, cert.hostnames...)
+			}
+			c["tls_certificates"] = hostnames
+		}
+	}
+
+	effectiveMu.Lock()
+	effective = c
+	effectiveMu.Unlock()
+}
+
+// redact hides a secret, only showing whether it is set.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[redacted]"
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hacdias/webdav/v4/lib"
+)
+
+// eventBuffer is the number of events queued for each subscriber. A
+// subscriber too slow to keep up is disconnected.
+const eventBuffer = 64
+
+// eventHub fans the events out to the subscribers of the events stream.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: map[chan []byte]struct{}{}}
+}
+
+// publish sends an event to every subscriber, as a Server-Sent Event whose
+// data is the JSON of value.
+func (h *eventHub) publish(kind string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	msg := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", kind, data))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		select {
+		case sub <- msg:
+		default:
+			// Too slow: dropped, and told so by the closed stream.
+			delete(h.subs, sub)
+			close(sub)
+		}
+	}
+}
+
+func (h *eventHub) subscribe() chan []byte {
+	sub := make(chan []byte, eventBuffer)
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub)
+	}
+}
+
+// watch publishes the file and lock events of the configuration, on top
+// of the callbacks already set.
+func (h *eventHub) watch(cfg *lib.Config) {
+	onEvent := cfg.OnEvent
+	cfg.OnEvent = func(e lib.Event) {
+		if onEvent != nil {
+			onEvent(e)
+		}
+		h.publish("file", e)
+	}
+
+	onLock := cfg.OnLock
+	cfg.OnLock = func(path, owner string, locked bool) {
+		if onLock != nil {
+			onLock(path, owner, locked)
+		}
+		h.publish("lock", map[string]interface{}{
+			"path":   path,
+			"owner":  owner,
+			"locked": locked,
+			"time":   time.Now(),
+		})
+	}
+}
+
+// ServeHTTP streams the events as Server-Sent Events until the client
+// disconnects, or falls behind.
+func (h *eventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub := h.subscribe()
+	defer h.unsubscribe(sub)
+
+	// Comments keep the idle connections open through proxies.
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case msg, ok := <-sub:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultConfig = `# WebDAV server configuration. Every setting is documented in the README.
+
+# Address and port to listen on. Port 0 picks a free port, shown when the
+# server starts.
+address: 0.0.0.0
+port: 0
+# Prefix of the URLs served, such as /dav/.
+prefix: /
+
+# TLS is off. To serve HTTPS, set tls to true, and cert and key to the
+# certificate and private key files.
+tls: false
+cert: cert.pem
+key: key.pem
+
+# Logging: level (debug, info, warn or error), format (console or json)
+# and file, in addition to the standard output (none if empty).
+log_level: info
+log_format: console
+log_path: ./webdav.log
+
+# Users must log in with their username and password.
+auth: true
+
+# Default user settings, inherited by the users: the directory served, and
+# whether files can be created, modified and deleted.
+scope: .
+modify: true
+rules: []
+
+# The users. Passwords can be hashed with bcrypt, written as {bcrypt}HASH.
+users:
+  - username: %s
+    password: %s
+`
+
+func init() {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "init [path]",
+		Short: "Write a default configuration file",
+		Long: `Writes a minimal, working configuration file with a single user and a
+random password. The file is written to "config.yml" unless a path is given.
+Existing files are never overwritten.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "config.yml"
+			if len(args) == 1 {
+				path = args[0]
+			}
+
+			checkErr(WriteDefaultConfig(path))
+			fmt.Println("Configuration written to " + path)
+		},
+	})
+}
+
+// DefaultConfig returns a minimal, working configuration file in YAML, with
+// comments, which serves the working directory with TLS off and a single
+// user, admin, of random password.
+func DefaultConfig() (string, error) {
+	password := make([]byte, 12)
+	if _, err := rand.Read(password); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(defaultConfig, "admin", hex.EncodeToString(password)), nil
+}
+
+// WriteDefaultConfig writes the default configuration to path, failing if
+// the file already exists.
+func WriteDefaultConfig(path string) error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(config); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	v "github.com/spf13/viper"
+)
+
+func TestWriteDefaultConfig(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yml")
+	if err := WriteDefaultConfig(name); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing files are never overwritten.
+	if err := WriteDefaultConfig(name); !os.IsExist(err) {
+		t.Errorf("writing again: got %v, want an existing file error", err)
+	}
+
+	if errs := ValidateConfig(name); len(errs) != 0 {
+		t.Errorf("got %v, want no error", errs)
+	}
+
+	config := v.New()
+	config.SetConfigFile(name)
+	if err := config.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]interface{}{"tls": false, "auth": true, "log_level": "info"} {
+		if got := config.Get(key); got != want {
+			t.Errorf("%s: got %v, want %v", key, got, want)
+		}
+	}
+	if users, ok := config.Get("users").([]interface{}); !ok || len(users) != 1 {
+		t.Errorf("got users %v, want a single user", config.Get("users"))
+	}
+}
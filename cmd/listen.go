@@ -0,0 +1,472 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// tcpOptions are the options of a TCP listener.
+type tcpOptions struct {
+	// fallback makes the listener use a port chosen by the system if the
+	// port is already in use.
+	fallback bool
+
+	// portRange, such as "8080-8090", makes the listener use the first free
+	// port of the range instead of the port.
+	portRange string
+
+	// reuseAddr and reusePort set SO_REUSEADDR and SO_REUSEPORT on the
+	// socket.
+	reuseAddr bool
+	reusePort bool
+
+	// keepAlive is the period of the TCP keepalives of the connections, or
+	// zero to disable them. Negative leaves the default of Go. noDelay
+	// disables Nagle's algorithm, as by default.
+	keepAlive time.Duration
+	noDelay   bool
+
+	// ipVersion is "4" or "6" to listen to IPv4 or IPv6 only, or "dual" to
+	// listen to both on a wildcard address, with a listener each.
+	ipVersion string
+
+	// dualStack makes a listener on an IP address, without IP version,
+	// accept the connections of both families by clearing IPV6_V6ONLY.
+	// Otherwise it only accepts the family of its address.
+	dualStack bool
+
+	// backlog, if positive, is the size of the queue of the connections
+	// waiting to be accepted, instead of the default of the system.
+	backlog int
+}
+
+// listenTCP listens on the address and port, or the first free port of the
+// port range.
+func listenTCP(address, port string, opts tcpOptions) (net.Listener, error) {
+	// IPv6 addresses may be written between brackets, such as "[::]".
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+
+	lc := net.ListenConfig{}
+	if opts.reuseAddr || opts.reusePort {
+		control, err := reuseControl(opts.reuseAddr, opts.reusePort)
+		if err != nil {
+			return nil, err
+		}
+		lc.Control = control
+	}
+
+	var ln net.Listener
+	var err error
+	switch opts.ipVersion {
+	case "":
+		// Go listens to both families on the IPv4 wildcard address too.
+		network := "tcp"
+		if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+			lc.Control = chainControls(lc.Control, v6OnlyControl(!opts.dualStack))
+			if !opts.dualStack {
+				network = "tcp6"
+			}
+		} else if ip != nil && !opts.dualStack {
+			network = "tcp4"
+		}
+		ln, err = listenPort(lc, network, address, port, opts)
+	case "4", "6":
+		ln, err = listenPort(lc, ipNetwork(opts.ipVersion), address, port, opts)
+	case "dual":
+		if isWildcard(address) {
+			ln, err = listenDual(lc, port, opts)
+		} else {
+			ln, err = listenPort(lc, "tcp", address, port, opts)
+		}
+	default:
+		return nil, fmt.Errorf("invalid IP version %q", opts.ipVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.backlog > 0 {
+		for _, l := range listeners(ln) {
+			if err := setBacklog(l, opts.backlog); err != nil {
+				ln.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if opts.keepAlive >= 0 || !opts.noDelay {
+		ln = &tcpOptionsListener{Listener: ln, keepAlive: opts.keepAlive, noDelay: opts.noDelay}
+	}
+	return ln, nil
+}
+
+// tcpOptionsListener sets the keepalive and no delay options of the
+// accepted TCP connections.
+type tcpOptionsListener struct {
+	net.Listener
+	keepAlive time.Duration
+	noDelay   bool
+}
+
+func (l *tcpOptionsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+
+	if l.keepAlive == 0 {
+		_ = tc.SetKeepAlive(false)
+	} else if l.keepAlive > 0 {
+		_ = tc.SetKeepAlive(true)
+		_ = tc.SetKeepAlivePeriod(l.keepAlive)
+	}
+	_ = tc.SetNoDelay(l.noDelay)
+
+	return tc, nil
+}
+
+// listenPort listens on the network, address and port, or the first free
+// port of the port range.
+func listenPort(lc net.ListenConfig, network, address, port string, opts tcpOptions) (net.Listener, error) {
+	if opts.portRange != "" {
+		first, last, err := parsePortRange(opts.portRange)
+		if err != nil {
+			return nil, err
+		}
+
+		for p := first; p <= last; p++ {
+			ln, err := lc.Listen(context.Background(), network, net.JoinHostPort(address, strconv.Itoa(p)))
+			if err == nil || !errors.Is(err, errAddrInUse) {
+				return ln, err
+			}
+		}
+		return nil, fmt.Errorf("every port of the range %s is already in use", opts.portRange)
+	}
+
+	ln, err := lc.Listen(context.Background(), network, net.JoinHostPort(address, port))
+	if err == nil || !opts.fallback || port == "0" || !errors.Is(err, errAddrInUse) {
+		return ln, err
+	}
+
+	log.Printf("Port %s is already in use, falling back to a random port", port)
+	return lc.Listen(context.Background(), network, net.JoinHostPort(address, "0"))
+}
+
+// listenDual listens to the wildcard addresses of IPv4 and IPv6, on the
+// same port.
+func listenDual(lc net.ListenConfig, port string, opts tcpOptions) (net.Listener, error) {
+	ln4, err := listenPort(lc, "tcp4", "0.0.0.0", port, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The IPv6 listener takes the port the IPv4 one got.
+	_, port, _ = net.SplitHostPort(ln4.Addr().String())
+	ln6, err := lc.Listen(context.Background(), "tcp6", net.JoinHostPort("::", port))
+	if err != nil {
+		ln4.Close()
+		return nil, err
+	}
+
+	return newMultiListener(ln4, ln6), nil
+}
+
+// chainControls returns a function calling the non-nil controls in turn,
+// until one fails.
+func chainControls(controls ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	chained := controls[:0:0]
+	for _, control := range controls {
+		if control != nil {
+			chained = append(chained, control)
+		}
+	}
+	if len(chained) == 0 {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		for _, control := range chained {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ipNetwork returns the network to listen to for an IP version.
+func ipNetwork(version string) string {
+	switch version {
+	case "4":
+		return "tcp4"
+	case "6":
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// isWildcard returns whether address is the wildcard address of IPv4 or
+// IPv6, or empty.
+func isWildcard(address string) bool {
+	if address == "" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// multiListener accepts the connections of several listeners, such as the
+// IPv4 and IPv6 listeners of a dual stack.
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners ...net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		conns:     make(chan net.Conn),
+		errs:      make(chan error),
+		done:      make(chan struct{}),
+	}
+
+	for _, l := range listeners {
+		go m.accept(l)
+	}
+	return m
+}
+
+// accept passes the connections of l to Accept until it is closed.
+func (m *multiListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			select {
+			case m.errs <- err:
+				continue
+			case <-m.done:
+				return
+			}
+		}
+
+		select {
+		case m.conns <- conn:
+		case <-m.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Accept returns the next connection of any of the listeners.
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case err := <-m.errs:
+		return nil, err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all the listeners.
+func (m *multiListener) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		for _, l := range m.listeners {
+			if e := l.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener.
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
+
+// listeners returns the listeners a listener is made of, several if it is a
+// multiListener.
+func listeners(ln net.Listener) []net.Listener {
+	if l, ok := ln.(*tcpOptionsListener); ok {
+		ln = l.Listener
+	}
+	if m, ok := ln.(*multiListener); ok {
+		return m.listeners
+	}
+	return []net.Listener{ln}
+}
+
+// parsePortRange parses a port range such as "8080-8090".
+func parsePortRange(portRange string) (int, int, error) {
+	bounds := strings.SplitN(portRange, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+
+	first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+
+	if first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+	return first, last, nil
+}
+
+// interfaceAddress returns the address of the network interface with the
+// given name. The version can be "4" or "6" to require an IPv4 or IPv6
+// address. Otherwise IPv4 addresses are preferred.
+func interfaceAddress(name, version string) (string, error) {
+	if version == "dual" {
+		version = ""
+	}
+	if version != "" && version != "4" && version != "6" {
+		return "", fmt.Errorf("invalid IP version %q", version)
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("interface %s: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("interface %s: %w", name, err)
+	}
+
+	var ipv4, ipv6, linkLocal string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := ipnet.IP
+		if ip.To4() != nil {
+			if ipv4 == "" {
+				ipv4 = ip.String()
+			}
+			continue
+		}
+
+		if ip.IsLinkLocalUnicast() {
+			// Link-local addresses are only usable with the interface zone.
+			if linkLocal == "" {
+				linkLocal = ip.String() + "%" + name
+			}
+		} else if ipv6 == "" {
+			ipv6 = ip.String()
+		}
+	}
+
+	if ipv6 == "" {
+		ipv6 = linkLocal
+	}
+
+	switch {
+	case version != "6" && ipv4 != "":
+		return ipv4, nil
+	case version != "4" && ipv6 != "":
+		return ipv6, nil
+	case version == "4" || version == "6":
+		return "", fmt.Errorf("interface %s has no IPv%s address", name, version)
+	default:
+		return "", fmt.Errorf("interface %s has no address", name)
+	}
+}
+
+// listenUnix listens to a unix socket at path. A stale socket left behind
+// by a previous process is removed first, unless something is still
+// listening to it. If set, the mode (in octal) and group are applied to the
+// socket file.
+func listenUnix(path, mode, group string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: a server is already listening to this socket", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != "" {
+		perm, err := parseMode(mode)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid socket mode: %w", err)
+		}
+
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+
+		if err := os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// lookupGroup returns the ID of a group, given by name or ID.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestListenPortInUse(t *testing.T) {
+	taken, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	// Without fallback, the error is returned.
+	lc := net.ListenConfig{}
+	if ln, err := listenPort(lc, "tcp4", "127.0.0.1", strconv.Itoa(port), tcpOptions{}); err == nil {
+		ln.Close()
+		t.Fatal("listened to a port already in use")
+	}
+
+	ln, err := listenPort(lc, "tcp4", "127.0.0.1", strconv.Itoa(port), tcpOptions{fallback: true})
+	if err != nil {
+		t.Fatalf("fallback: %s", err)
+	}
+	if got := ln.Addr().(*net.TCPAddr).Port; got == port {
+		t.Errorf("fallback: listened to the port in use")
+	}
+	ln.Close()
+
+	// The port range skips the port in use.
+	portRange := strconv.Itoa(port) + "-" + strconv.Itoa(port+1)
+	ln, err = listenPort(lc, "tcp4", "127.0.0.1", "", tcpOptions{portRange: portRange})
+	if err != nil {
+		t.Skipf("port %d is in use too: %s", port+1, err)
+	}
+	if got := ln.Addr().(*net.TCPAddr).Port; got != port+1 {
+		t.Errorf("port range: got port %d, want %d", got, port+1)
+	}
+	ln.Close()
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Options are settings given programmatically. They take precedence over
+// the environment and the configuration file. Empty fields are ignored.
+type Options struct {
+	Address string
+	Port    string
+	Prefix  string
+	TLS     *bool
+	Cert    string
+	Key     string
+
+	// CertPEM and KeyPEM, if set, are the TLS certificate and key
+	// themselves, instead of the files of Cert and Key.
+	CertPEM []byte
+	KeyPEM  []byte
+
+	Auth   *bool
+	Scope  string
+	Modify *bool
+	Users  []UserOptions
+
+	// Debug, if set, logs at the debug level, or at the info level if
+	// false.
+	Debug     *bool
+	LogFormat string
+	LogPath   string
+
+	DrainTimeout string
+	Timeouts     Timeouts
+}
+
+// Timeouts are the timeouts of the server given programmatically, as the
+// settings of the same names. Zero fields are ignored, and negative ones
+// disable the timeout.
+type Timeouts struct {
+	Request      time.Duration
+	Transfer     time.Duration
+	TransferIdle time.Duration
+	ReadHeader   time.Duration
+	BodyIdle     time.Duration
+	Keepalive    time.Duration
+}
+
+// UserOptions are the settings of a user given programmatically. Empty
+// fields inherit the global settings.
+type UserOptions struct {
+	Username string
+	Password string
+	Scope    string
+	Modify   *bool
+}
+
+type optionsKey struct{}
+
+// rawValue is the value of a setting given by the options that isn't a
+// flag, such as the users, or the settings read when validating a file. It
+// is only found in the flags of a run or of a validation.
+type rawValue struct {
+	value interface{}
+}
+
+func (r *rawValue) String() string   { return "" }
+func (r *rawValue) Set(string) error { return nil }
+func (r *rawValue) Type() string     { return "raw" }
+
+// runFlags returns the flags Run reads the settings from. Without options,
+// they are the flags of the command. Otherwise, they are a copy of them
+// with their default values, so that the command line of an earlier run is
+// ignored, in which the options are set: getOpt and getOptB take them
+// first, and nothing global is modified.
+func runFlags(ctx context.Context, flags *pflag.FlagSet) *pflag.FlagSet {
+	o, ok := ctx.Value(optionsKey{}).(Options)
+	if !ok {
+		return flags
+	}
+
+	fs := pflag.NewFlagSet("options", pflag.ContinueOnError)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Value.Type() == "bool" {
+			fs.Bool(f.Name, f.DefValue == "true", f.Usage)
+		} else {
+			fs.String(f.Name, f.DefValue, f.Usage)
+		}
+	})
+
+	// Some settings, such as scope, are only read from viper: they are
+	// flags of the run only.
+	set := func(key, value string) {
+		if value != "" {
+			if fs.Lookup(key) == nil {
+				fs.String(key, "", "")
+			}
+			_ = fs.Set(key, value)
+		}
+	}
+	setBool := func(key string, value *bool) {
+		if value != nil {
+			if fs.Lookup(key) == nil {
+				fs.Bool(key, false, "")
+			}
+			_ = fs.Set(key, strconv.FormatBool(*value))
+		}
+	}
+	setDuration := func(key string, value time.Duration) {
+		if value < 0 {
+			_ = fs.Set(key, "0")
+		} else if value > 0 {
+			_ = fs.Set(key, value.String())
+		}
+	}
+	setRaw := func(key string, value interface{}) {
+		fs.Var(&rawValue{value: value}, key, "")
+		fs.Lookup(key).Changed = true
+	}
+
+	set("address", o.Address)
+	set("port", o.Port)
+	set("prefix", o.Prefix)
+	set("cert", o.Cert)
+	set("key", o.Key)
+	set("scope", o.Scope)
+	set("log_format", o.LogFormat)
+	set("log_path", o.LogPath)
+	set("drain_timeout", o.DrainTimeout)
+	setBool("tls", o.TLS)
+	setBool("auth", o.Auth)
+	setBool("modify", o.Modify)
+
+	if o.Debug != nil {
+		level := "info"
+		if *o.Debug {
+			level = "debug"
+		}
+		set("log_level", level)
+	}
+
+	setDuration("request_timeout", o.Timeouts.Request)
+	setDuration("transfer_timeout", o.Timeouts.Transfer)
+	setDuration("transfer_idle_timeout", o.Timeouts.TransferIdle)
+	setDuration("read_header_timeout", o.Timeouts.ReadHeader)
+	setDuration("body_idle_timeout", o.Timeouts.BodyIdle)
+	setDuration("keepalive_timeout", o.Timeouts.Keepalive)
+
+	if o.CertPEM != nil || o.KeyPEM != nil {
+		setRaw("cert_pem", o.CertPEM)
+		setRaw("key_pem", o.KeyPEM)
+	}
+
+	if o.Users != nil {
+		// Same shape as the users of a YAML configuration file.
+		users := make([]interface{}, 0, len(o.Users))
+		for _, u := range o.Users {
+			user := map[interface{}]interface{}{
+				"username": u.Username,
+				"password": u.Password,
+			}
+			if u.Scope != "" {
+				user["scope"] = u.Scope
+			}
+			if u.Modify != nil {
+				user["modify"] = *u.Modify
+			}
+			users = append(users, user)
+		}
+		setRaw("users", users)
+	}
+
+	return fs
+}
+
+// ExecuteWithOptions starts the server with the given options, without
+// parsing the command line arguments. The server is shut down gracefully
+// when the context is canceled. The options only apply to this run.
+func ExecuteWithOptions(ctx context.Context, opts Options) error {
+	rootCmd.SetArgs([]string{})
+	return rootCmd.ExecuteContext(context.WithValue(ctx, optionsKey{}, opts))
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v "github.com/spf13/viper"
+)
+
+// selfSignedPEM returns a self-signed certificate for 127.0.0.1 and its key.
+func selfSignedPEM(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// runWithOptions runs the server in the background with the options, and
+// returns its address and a function stopping it.
+func runWithOptions(t *testing.T, opts Options) (string, func() error) {
+	t.Helper()
+
+	v.Reset()
+	t.Cleanup(v.Reset)
+	return runServer(t, func(ctx context.Context) error {
+		return ExecuteWithOptions(ctx, opts)
+	})
+}
+
+func request(t *testing.T, client *http.Client, method, url, username string) int {
+	t.Helper()
+
+	r, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "" {
+		r.SetBasicAuth(username, "secret")
+	}
+
+	res, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestExecuteWithOptions(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	for _, dir := range []string{first, second} {
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(dir)+".txt"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logPath := filepath.Join(t.TempDir(), "webdav.log")
+	yes, no := true, false
+
+	certPEM, keyPEM := selfSignedPEM(t)
+	addr, stop := runWithOptions(t, Options{
+		Address:  "127.0.0.1",
+		Port:     "0",
+		TLS:      &yes,
+		CertPEM:  certPEM,
+		KeyPEM:   keyPEM,
+		Auth:     &no,
+		Scope:    first,
+		Modify:   &no,
+		Debug:    &yes,
+		LogPath:  logPath,
+		Timeouts: Timeouts{Request: time.Minute, ReadHeader: -1},
+	})
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	base := "https://" + addr + "/"
+	if status := request(t, client, "GET", base+filepath.Base(first)+".txt", ""); status != http.StatusOK {
+		t.Errorf("first run: GET: got %d, want %d", status, http.StatusOK)
+	}
+	if status := request(t, client, "MKCOL", base+"new", ""); status != http.StatusForbidden {
+		t.Errorf("first run: MKCOL: got %d, want %d", status, http.StatusForbidden)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing global was modified by the options.
+	for _, key := range []string{"scope", "modify", "tls", "auth"} {
+		if v.IsSet(key) {
+			t.Errorf("%s is set in viper", key)
+		}
+	}
+
+	// The options of the first run don't apply to the second.
+	addr, stop = runWithOptions(t, Options{
+		Address: "127.0.0.1",
+		Port:    "0",
+		Scope:   second,
+		Modify:  &yes,
+		Users:   []UserOptions{{Username: "alice", Password: "secret"}},
+		LogPath: logPath,
+	})
+	defer func() {
+		if err := stop(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	base = "http://" + addr + "/"
+	if status := request(t, http.DefaultClient, "GET", base+filepath.Base(second)+".txt", ""); status != http.StatusUnauthorized {
+		t.Errorf("second run: GET without credentials: got %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := request(t, http.DefaultClient, "GET", base+filepath.Base(second)+".txt", "alice"); status != http.StatusOK {
+		t.Errorf("second run: GET: got %d, want %d", status, http.StatusOK)
+	}
+	if status := request(t, http.DefaultClient, "MKCOL", base+"new", "alice"); status != http.StatusCreated {
+		t.Errorf("second run: MKCOL: got %d, want %d", status, http.StatusCreated)
+	}
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hacdias/webdav/v4/lib"
+	"github.com/spf13/viper"
+)
+
+// errNotRunning is returned when operating the server while it isn't
+// running.
+var errNotRunning = errors.New("server is not running")
+
+// running is the configuration of the running server, runningServer the
+// HTTP server serving it, and runningListener the listener it accepts the
+// connections of. runningStatsFile is where its statistics are saved. They
+// are set while Run serves, and guarded by runningMu since the functions
+// operating the server are called from other goroutines. runningDone is
+// closed once Run returned.
+var (
+	runningMu        sync.Mutex
+	running          *lib.Config
+	runningServer    *http.Server
+	runningListener  *lib.Listener
+	runningStatsFile string
+	runningDone      chan struct{}
+)
+
+// startRunning records that Run started, before it listens.
+func startRunning() {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	runningDone = make(chan struct{})
+}
+
+// setRunning records the server Run is about to serve.
+func setRunning(cfg *lib.Config, server *http.Server, listener *lib.Listener, statsFile string) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	running, runningServer, runningListener, runningStatsFile = cfg, server, listener, statsFile
+}
+
+// clearRunning forgets the server once Run stopped serving it, and wakes up
+// WaitForStop.
+func clearRunning() {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	running, runningServer, runningListener, runningStatsFile = nil, nil, nil, ""
+	if runningDone != nil {
+		close(runningDone)
+		runningDone = nil
+	}
+}
+
+// WaitForStop waits until the server stopped, once the context given to
+// ExecuteContext or ExecuteWithOptions is canceled: its listener is closed
+// and the statistics saved, so that it can be started again on the same
+// port. It returns at once if no server is running, and an error if it
+// doesn't stop within timeout, unless it is zero.
+func WaitForStop(timeout time.Duration) error {
+	runningMu.Lock()
+	done := runningDone
+	runningMu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-expired:
+		return errors.New("timed out waiting for the server to stop")
+	}
+}
+
+// runningConfig returns the configuration of the running server and the
+// file its statistics are saved to, or errNotRunning.
+func runningConfig() (*lib.Config, string, error) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	if running == nil {
+		return nil, "", errNotRunning
+	}
+	return running, runningStatsFile, nil
+}
+
+// runningHTTP returns the HTTP server and the listener of the running
+// server, or errNotRunning.
+func runningHTTP() (*http.Server, *lib.Listener, error) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	if runningServer == nil {
+		return nil, nil, errNotRunning
+	}
+	return runningServer, runningListener, nil
+}
+
+// SetKeepAlivesEnabled enables or disables the keep-alive of the
+// connections of the running server, without restarting it.
+func SetKeepAlivesEnabled(enabled bool) error {
+	server, _, err := runningHTTP()
+	if err != nil {
+		return err
+	}
+
+	server.SetKeepAlivesEnabled(enabled)
+	return nil
+}
+
+// PauseAccept stops accepting new connections on the running server, which
+// wait in the queue of the listener until ResumeAccept is called. The
+// connections already accepted are served as usual.
+func PauseAccept() error {
+	_, listener, err := runningHTTP()
+	if err != nil {
+		return err
+	}
+
+	listener.Pause()
+	return nil
+}
+
+// ResumeAccept resumes accepting new connections on the running server.
+func ResumeAccept() error {
+	_, listener, err := runningHTTP()
+	if err != nil {
+		return err
+	}
+
+	listener.Resume()
+	return nil
+}
+
+// Pause stops accepting new connections on the running server, without
+// closing its socket nor dropping the active requests, such as while the
+// app embedding it is in the background. It is the same as PauseAccept.
+func Pause() error {
+	return PauseAccept()
+}
+
+// Resume resumes accepting new connections on the running server after
+// Pause. It is the same as ResumeAccept.
+func Resume() error {
+	return ResumeAccept()
+}
+
+// Paused reports whether accepting new connections on the running server
+// is paused.
+func Paused() bool {
+	_, listener, err := runningHTTP()
+	return err == nil && listener.Paused()
+}
+
+// ResetStats zeroes the transfer statistics of the running server, saving
+// them at once if they are kept in stats_file.
+func ResetStats() error {
+	cfg, statsFile, err := runningConfig()
+	if err != nil {
+		return err
+	}
+
+	cfg.ResetStats()
+	if statsFile != "" {
+		saveStats(cfg, statsFile)
+	}
+	return nil
+}
+
+// ActiveTransfers returns the requests in progress on the running server,
+// or nil if it isn't running.
+func ActiveTransfers() []lib.TransferInfo {
+	cfg, _, err := runningConfig()
+	if err != nil {
+		return nil
+	}
+	return cfg.ActiveTransfers()
+}
+
+// Cancel cancels the request in progress with the given ID, as listed by
+// ActiveTransfers.
+func Cancel(id string) error {
+	cfg, _, err := runningConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.CancelTransfer(id) {
+		return errors.New("no such transfer")
+	}
+	return nil
+}
+
+// ActiveLocks returns the active locks of the running server, or nil if it
+// isn't running.
+func ActiveLocks() []lib.LockInfo {
+	cfg, _, err := runningConfig()
+	if err != nil {
+		return nil
+	}
+	return cfg.ActiveLocks()
+}
+
+// ForceUnlock releases the locks whose root is the given path, whoever holds
+// them, such as a lock left by a client that crashed while editing.
+func ForceUnlock(path string) error {
+	cfg, _, err := runningConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.ForceUnlock(path) == 0 {
+		return errors.New("no such lock")
+	}
+	return nil
+}
+
+// ReloadUsers re-reads the users section of the configuration file and
+// replaces the users of the running server. The listener and the default
+// user are left untouched, and requests in progress finish as the user
+// they started with.
+func ReloadUsers(configFile string) error {
+	cfg, _, err := runningConfig()
+	if err != nil {
+		return err
+	}
+
+	fv := viper.New()
+	fv.SetConfigFile(configFile)
+	if err := fv.ReadInConfig(); err != nil {
+		return err
+	}
+
+	raw, _ := fv.Get("users").([]interface{})
+	users, err := parseUsers(raw, cfg)
+	if err != nil {
+		return err
+	}
+
+	cfg.SetUsers(users)
+	return nil
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const lockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope><D:exclusive/></D:lockscope>
+  <D:locktype><D:write/></D:locktype>
+  <D:owner>alice</D:owner>
+</D:lockinfo>`
+
+func TestReloadUsersKeepsLocks(t *testing.T) {
+	usersConfig := func(dir, scope, password string) string {
+		return `
+scope: ` + dir + `
+modify: true
+users:
+  - username: alice
+    password: ` + password + `
+    scope: ` + scope + `
+`
+	}
+
+	for _, tt := range []struct {
+		name       string
+		sameScope  bool
+		lockStatus int
+	}{
+		{"same scope", true, http.StatusLocked},
+		{"other scope", false, http.StatusNoContent},
+	} {
+		cfg, dir := testConfig(t, usersConfig("{dir}", "{dir}/alice", "a"))
+		if err := os.Mkdir(filepath.Join(dir, "alice"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(dir, "other"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		setRunning(cfg, nil, nil, "")
+		t.Cleanup(clearRunning)
+		name := filepath.Join(dir, "config.yml")
+
+		r := httptest.NewRequest("LOCK", "/file.txt", strings.NewReader(lockBody))
+		r.SetBasicAuth("alice", "a")
+		w := httptest.NewRecorder()
+		cfg.ServeHTTP(w, r)
+		token := w.Header().Get("Lock-Token")
+		if w.Code != http.StatusCreated || token == "" {
+			t.Fatalf("LOCK: got status %d and token %q", w.Code, token)
+		}
+
+		// The password changes, and the scope too unless sameScope.
+		scope := filepath.Join(dir, "alice")
+		if !tt.sameScope {
+			scope = filepath.Join(dir, "other")
+			if err := os.WriteFile(filepath.Join(scope, "file.txt"), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(name, []byte(usersConfig(dir, scope, "b")), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ReloadUsers(name); err != nil {
+			t.Fatal(err)
+		}
+
+		// Another client of the user can't overwrite the locked file.
+		r = httptest.NewRequest("PUT", "/file.txt", strings.NewReader("overwritten"))
+		r.SetBasicAuth("alice", "b")
+		if code := serve(cfg, r); code != tt.lockStatus {
+			t.Errorf("%s: PUT without the token: got %d, want %d", tt.name, code, tt.lockStatus)
+		}
+
+		if !tt.sameScope {
+			continue
+		}
+
+		// The client holding the lock still can.
+		r = httptest.NewRequest("PUT", "/file.txt", strings.NewReader("updated"))
+		r.SetBasicAuth("alice", "b")
+		r.Header.Set("If", "("+token+")")
+		if code := serve(cfg, r); code != http.StatusNoContent {
+			t.Errorf("%s: PUT with the token: got %d, want %d", tt.name, code, http.StatusNoContent)
+		}
+	}
+}
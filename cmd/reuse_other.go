@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package cmd
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errAddrInUse is the error of a listener whose port is already in use. On
+// Windows, it is WSAEADDRINUSE, which syscall.EADDRINUSE doesn't match.
+const errAddrInUse = syscall.Errno(10048)
+
+// reuseControl is not supported on this platform. On Windows, SO_REUSEADDR
+// lets other processes steal the port, so it is never set.
+func reuseControl(reuseAddr, reusePort bool) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("reuse_addr and reuse_port are not supported on this platform")
+}
@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"crypto/tls"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hacdias/webdav/v4/lib"
 	"github.com/spf13/cobra"
 	v "github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -19,17 +24,101 @@ var (
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	flags := rootCmd.Flags()
+	flags := rootCmd.PersistentFlags()
 	flags.StringVarP(&cfgFile, "config", "c", "", "config file path")
 	flags.BoolP("tls", "t", false, "enable tls")
 	flags.Bool("auth", true, "enable auth")
+	flags.String("unauthorized_page", "", "HTML page, inline or the path of its file, answered to the browsers that aren't authenticated")
+	flags.String("unauthorized_redirect", "", "URL the browsers that aren't authenticated are redirected to")
+	flags.String("auth_webhook_url", "", "URL of a webhook that checks the credentials instead of the users list")
+	flags.String("auth_webhook_ttl", "0s", "how long to cache the credentials accepted by the auth webhook")
+	flags.String("jwt_secret", "", "secret to verify HS256/384/512 bearer tokens")
+	flags.String("jwt_jwks_url", "", "JWKS URL with the keys to verify RS256/384/512 bearer tokens")
+	flags.String("jwt_username_claim", "sub", "token claim holding the username")
+	flags.String("jwt_scope_claim", "", "token claim holding the scope of the user, inside the scope")
+	flags.String("jwt_issuer", "", "iss claim the bearer tokens must have")
+	flags.String("jwt_audience", "", "aud claim the bearer tokens must have")
+	flags.String("scope_template", "", "scope of the users that have none, where {user} is replaced by the username (e.g. /data/{user})")
+	flags.Bool("exclusive_scopes", false, "refuse users whose scopes are the same directory or one inside the other")
+	flags.String("share_secret", "", "secret to sign the share links with, share links are disabled if empty")
+	flags.String("share_base_url", "", "URL of the server to use in the share links")
+	flags.String("share_revoked_file", "", "file the revoked share links are kept in, so that they stay revoked after a restart")
 	flags.String("cert", "cert.pem", "TLS certificate")
 	flags.String("key", "key.pem", "TLS key")
+	flags.Bool("ocsp_stapling", false, "staple the OCSP response of the TLS certificate, whose file must contain its issuer")
+	flags.String("server_header", "", "value of the Server response header, none if empty")
+	flags.Bool("disable_keepalive", false, "close the connections after each request")
+	flags.String("keepalive_timeout", "", "time to keep idle connections open (e.g. 30s)")
+	flags.String("request_timeout", "0", "time after which requests are canceled, except GET and PUT (0 for none)")
+	flags.String("transfer_timeout", "0", "time after which GET and PUT requests are canceled (0 for none)")
+	flags.String("read_header_timeout", "10s", "time to receive the headers of a request, after which it's answered with 408 (0 for none)")
+	flags.String("body_idle_timeout", "0", "time after which requests whose body receives nothing are answered with 408 (0 for none)")
+	flags.String("transfer_idle_timeout", "0", "time after which GET and PUT requests transferring nothing are canceled (0 for none)")
+	flags.String("drain_timeout", "30s", "time to wait for active requests when shutting down")
+	flags.String("stats_file", "", "file the transfer statistics are saved to, and loaded from when starting")
+	flags.String("stats_interval", "1m", "time between the saves of the transfer statistics to stats_file")
+	flags.String("admin_address", "", "address of the admin API, disabled if empty (e.g. 127.0.0.1:9090)")
+	flags.String("admin_token", "", "bearer token required by the admin API")
+	flags.Bool("http2", true, "enable HTTP/2 when serving TLS")
 	flags.StringP("address", "a", "0.0.0.0", "address to listen to")
+	flags.String("interface", "", "network interface to listen to, instead of address")
+	flags.String("socket_mode", "", "file mode of the unix socket, in octal")
+	flags.String("socket_group", "", "group owning the unix socket")
+	flags.String("ip_version", "", "IP version to listen to: 4, 6, or dual for both on a wildcard address")
+	flags.Bool("dual_stack", true, "listen to both IPv4 and IPv6 on an IP address, such as [::] or 0.0.0.0, by clearing IPV6_V6ONLY")
 	flags.StringP("port", "p", "0", "port to listen to")
+	flags.String("port_range", "", "range of ports to listen to the first free one of, instead of port (e.g. 8080-8090)")
+	flags.Bool("port_fallback", false, "listen to a random port if the port is already in use")
+	flags.String("listen_backlog", "0", "size of the queue of the connections waiting to be accepted (0 for the system default)")
+	flags.String("tcp_keepalive", "", "period of the TCP keepalives (0 to disable them, the Go default if empty)")
+	flags.Bool("tcp_nodelay", true, "disable Nagle's algorithm on the TCP connections")
+	flags.Bool("reuse_addr", false, "set SO_REUSEADDR on the listener (not supported on Windows)")
+	flags.Bool("reuse_port", false, "set SO_REUSEPORT on the listener (not supported on Windows)")
 	flags.StringP("prefix", "P", "/", "URL path prefix")
+	flags.Bool("proxy_protocol", false, "require the PROXY protocol header on connections")
+	flags.String("trusted_proxies", "", "comma separated CIDRs of the reverse proxies to trust X-Forwarded-* headers from")
+	flags.Bool("browser_files", true, "answer /favicon.ico with 204 and /robots.txt before the authentication")
+	flags.String("robots_txt", "", "content of /robots.txt (disallows everything if empty)")
+	flags.Bool("dir_listing", false, "answer GET requests on directories with an HTML listing")
+	flags.Bool("show_hidden", false, "list dotfiles in the HTML listing")
+	flags.String("timezone", "", "time zone of the write windows, such as Europe/Lisbon (local if empty)")
+	flags.Bool("read_only", false, "refuse the requests modifying the files, whatever the permissions of the users")
+	flags.Bool("partial_updates", false, "accept PATCH requests updating a part of a file, as SabreDAV does")
+	flags.Bool("decompress_uploads", false, "decompress the PUT requests sent with the gzip or deflate Content-Encoding")
+	flags.Bool("force_download", false, "answer GET requests on files as attachments, not only with ?download=1")
+	flags.Bool("tus", false, "accept resumable uploads with the tus protocol on tus_path")
+	flags.String("tus_path", "/.tus/", "URL path of the tus endpoint")
+	flags.String("temp_dir", "", "directory of the temporary files, created if missing (defaults to the system temporary directory)")
+	flags.String("tus_dir", "", "directory of the incomplete tus uploads (defaults to a directory in temp_dir)")
+	flags.String("tus_expiration", "24h", "time after which incomplete tus uploads that received nothing are removed (0 for never)")
+	flags.String("tus_max_size", "0", "largest tus upload, in bytes (0 for no limit)")
+	flags.Bool("thumbnails", false, "answer GET requests on images with ?thumb=<size> with a JPEG thumbnail")
+	flags.String("thumb_sizes", "128,256,512", "comma separated sizes allowed for the thumbnails, in pixels")
+	flags.String("thumb_cache_dir", "", "directory of the cached thumbnails (defaults to a directory in temp_dir)")
+	flags.String("thumb_cache_size", "100", "largest size of the thumbnails cache, in MiB (0 for no limit)")
+	flags.String("thumb_concurrency", "2", "largest number of thumbnails made at once")
+	flags.String("dav_compliance", "", "DAV compliance classes advertised on OPTIONS, instead of the detected ones")
+	flags.Bool("hide_dotfiles", false, "hide the files whose name starts with a dot, as if in hide_patterns")
+	flags.Bool("allow_write_hidden", false, "allow clients to write the files matching hide_patterns")
+	flags.String("max_propfind_depth", "infinity", "deepest Depth allowed for PROPFIND requests (0, 1 or infinity)")
+	flags.String("max_upload_size", "0", "largest PUT request body in bytes, chunked or not (0 for no limit)")
+	flags.String("max_propfind_entries", "0", "largest number of entries a PROPFIND request can list (0 for no limit)")
+	flags.String("max_lock_timeout", "0", "maximum timeout of the locks taken by the clients (0 for none)")
+	flags.Bool("atomic_writes", true, "write uploads to a temporary file that replaces the file once complete")
+	flags.String("file_mode", "", "permissions of the created files, in octal (e.g. 0664), unchanged if empty")
+	flags.String("dir_mode", "", "permissions of the created directories, in octal (e.g. 0775), unchanged if empty")
+	flags.String("file_group", "", "group of the created files and directories, unchanged if empty")
+	flags.Bool("case_insensitive", false, "resolve the paths against the existing files case insensitively")
+	flags.String("normalize_filenames", "none", "Unicode normalization of the file names (nfc, nfd or none)")
+	flags.String("symlinks", "scope", "symbolic links policy (follow, deny or scope)")
+	flags.Bool("follow_symlinks", false, "follow every symbolic link, even outside the scope (same as symlinks: follow)")
+	flags.Bool("otel_enabled", false, "export a trace span per request to an OpenTelemetry collector")
+	flags.String("otel_endpoint", "http://localhost:4318", "URL of the OpenTelemetry collector (OTLP over HTTP)")
 	flags.String("log_format", "console", "logging format")
-	flags.String("log_path", "./webdav.log", "logging file path")
+	flags.String("log_path", "./webdav.log", "logging file path, in addition to the standard output (none if empty)")
+	flags.String("log_level", "info", "logging level (debug, info, warn or error)")
+	flags.Bool("debug_requests", false, "log the headers and the beginning of the body of the requests, with log_level debug")
+	flags.String("debug_body_size", "1024", "bytes of the request bodies logged by debug_requests")
 }
 
 var rootCmd = &cobra.Command{
@@ -52,31 +141,73 @@ The environment variables are prefixed by "WD_" followed by the option
 name in caps. So to set "cert" via an env variable, you should
 set WD_CERT.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		flags := cmd.Flags()
+		flags := runFlags(cmd.Context(), cmd.Flags())
 
 		cfg := readConfig(flags)
+		startRunning()
+		defer clearRunning()
 
 		// Build address and listener
 		laddr := getOpt(flags, "address")
-		var lnet string
+		var ln net.Listener
+		var err error
 		if strings.HasPrefix(laddr, "unix:") {
-			laddr = laddr[5:]
-			lnet = "unix"
+			ln, err = listenUnix(laddr[5:], getOpt(flags, "socket_mode"), getOpt(flags, "socket_group"))
 		} else {
-			laddr = laddr + ":" + getOpt(flags, "port")
-			lnet = "tcp"
+			if iface := getOpt(flags, "interface"); iface != "" {
+				addr, err := interfaceAddress(iface, getOpt(flags, "ip_version"))
+				if err != nil {
+					log.Fatal(err)
+				}
+				laddr = addr
+			}
+
+			keepAlive := time.Duration(-1)
+			if raw := getOpt(flags, "tcp_keepalive"); raw != "" {
+				keepAlive, err = time.ParseDuration(raw)
+				if err != nil {
+					log.Fatalf("invalid tcp_keepalive: %s", err)
+				}
+			}
+
+			var backlog int
+			backlog, err = strconv.Atoi(getOpt(flags, "listen_backlog"))
+			if err != nil {
+				log.Fatalf("invalid listen_backlog: %s", err)
+			}
+
+			ln, err = listenTCP(laddr, getOpt(flags, "port"), tcpOptions{
+				fallback:  getOptB(flags, "port_fallback"),
+				portRange: getOpt(flags, "port_range"),
+				reuseAddr: getOptB(flags, "reuse_addr"),
+				reusePort: getOptB(flags, "reuse_port"),
+				ipVersion: getOpt(flags, "ip_version"),
+				dualStack: getOptB(flags, "dual_stack"),
+				keepAlive: keepAlive,
+				noDelay:   getOptB(flags, "tcp_nodelay"),
+				backlog:   backlog,
+			})
 		}
-		listener, err := net.Listen(lnet, laddr)
 		if err != nil {
 			log.Fatal(err)
 		}
+		bound := listeners(ln)
+		if getOptB(flags, "proxy_protocol") {
+			ln = &lib.ProxyListener{Listener: ln}
+		}
+		// The timeouts of the headers are answered in clear, without TLS.
+		ln = &lib.HeaderTimeoutListener{Listener: ln, Reply: !getOptB(flags, "tls")}
+		listener := lib.NewListener(ln)
 		loggerConfig := zap.NewProductionConfig()
 		loggerConfig.DisableCaller = true
 		loggerConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 		loggerConfig.Encoding = cfg.LogFormat
-		loggerConfig.OutputPaths = []string{
-			getOpt(flags, "log_path"),
-			"stdout",
+		loggerConfig.OutputPaths = []string{"stdout"}
+		if logPath := getOpt(flags, "log_path"); logPath != "" {
+			loggerConfig.OutputPaths = append(loggerConfig.OutputPaths, logPath)
+		}
+		if err := loggerConfig.Level.UnmarshalText([]byte(getOpt(flags, "log_level"))); err != nil {
+			log.Fatalf("invalid log_level: %s", err)
 		}
 		logger, err := loggerConfig.Build()
 		if err != nil {
@@ -89,35 +220,191 @@ set WD_CERT.`,
 			_ = zap.L().Sync()
 		}()
 		// Tell the user the port in which is listening.
-		zap.L().Info("Listening", zap.String("address", listener.Addr().String()))
+		for _, l := range bound {
+			fields := []zap.Field{zap.String("address", l.Addr().String())}
+			if families := listenerFamilies(l); families != nil {
+				fields = append(fields, zap.Strings("families", families))
+			}
+			zap.L().Info("Listening", fields...)
+		}
+		setEffectiveConfig(flags, cfg, listener.Addr())
+
+		requestTimeout, err := time.ParseDuration(getOpt(flags, "request_timeout"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		transferTimeout, err := time.ParseDuration(getOpt(flags, "transfer_timeout"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		idleTimeout, err := time.ParseDuration(getOpt(flags, "transfer_idle_timeout"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		bodyIdleTimeout, err := time.ParseDuration(getOpt(flags, "body_idle_timeout"))
+		if err != nil {
+			log.Fatalf("invalid body_idle_timeout: %s", err)
+		}
+
+		headers, err := parseHeaders(getRawOpt(flags, "headers"))
+		if err != nil {
+			log.Fatalf("invalid headers: %s", err)
+		}
+
+		handler := lib.ServerHeader(lib.Timeout(cfg, requestTimeout, transferTimeout, idleTimeout), getOpt(flags, "server_header"))
+		handler = lib.Recover(lib.StaticHeaders(handler, headers))
+		if getOptB(flags, "debug_requests") {
+			maxBody, err := strconv.Atoi(getOpt(flags, "debug_body_size"))
+			if err != nil {
+				log.Fatalf("invalid debug_body_size: %s", err)
+			}
+			handler = lib.DebugRequests(handler, maxBody)
+		}
+		handler = lib.RequestID(lib.BodyIdleTimeout(handler, bodyIdleTimeout))
+		if getOptB(flags, "otel_enabled") {
+			handler = lib.Trace(handler, lib.NewTracer(getOpt(flags, "otel_endpoint"), "webdav"))
+		}
+
+		server := &http.Server{Handler: handler, ConnContext: lib.ConnContext}
+		server.ReadHeaderTimeout, err = time.ParseDuration(getOpt(flags, "read_header_timeout"))
+		if err != nil {
+			log.Fatalf("invalid read_header_timeout: %s", err)
+		}
+		if !getOptB(flags, "http2") {
+			// A non-nil empty map disables the automatic HTTP/2 support.
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+
+		if timeout := getOpt(flags, "keepalive_timeout"); timeout != "" {
+			server.IdleTimeout, err = time.ParseDuration(timeout)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		server.SetKeepAlivesEnabled(!getOptB(flags, "disable_keepalive"))
+		trackConnections(server)
+
+		var admin *http.Server
+		if address := getOpt(flags, "admin_address"); address != "" {
+			token := getOpt(flags, "admin_token")
+			if token == "" {
+				log.Fatal("admin_token is required to enable the admin API")
+			}
+
+			adminListener, err := net.Listen("tcp", address)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			admin = newAdminServer(address, token, cfg, loggerConfig.Level)
+			go func() {
+				if err := admin.Serve(adminListener); err != http.ErrServerClosed {
+					zap.L().Error("admin server failed", zap.Error(err))
+				}
+			}()
+			zap.L().Info("Admin API listening", zap.String("address", adminListener.Addr().String()))
+		}
+
+		drainTimeout, err := time.ParseDuration(getOpt(flags, "drain_timeout"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Reloads the users on SIGHUP, if HandleSignals was called.
+		stopped := make(chan struct{})
+		defer close(stopped)
+		go func() {
+			for {
+				select {
+				case <-reloadSignals:
+				case <-stopped:
+					return
+				}
+
+				if err := ReloadUsers(v.ConfigFileUsed()); err != nil {
+					zap.L().Error("reloading users failed", zap.Error(err))
+				} else {
+					zap.L().Info("users reloaded")
+				}
+			}
+		}()
+
+		// Keeps the statistics across restarts, saving them periodically and
+		// once the server is stopped.
+		statsFile := getOpt(flags, "stats_file")
+		if statsFile != "" {
+			statsInterval, err := time.ParseDuration(getOpt(flags, "stats_interval"))
+			if err != nil || statsInterval <= 0 {
+				log.Fatalf("invalid stats_interval: %q", getOpt(flags, "stats_interval"))
+			}
+
+			loadStats(cfg, statsFile)
+			go saveStatsEvery(cfg, statsFile, statsInterval, stopped)
+			defer saveStats(cfg, statsFile)
+		}
+
+		// Drains the active requests before exiting on SIGINT or SIGTERM, if
+		// HandleSignals was called, or when the context is canceled. A second
+		// signal exits immediately, until Run returns.
+		drained := make(chan error, 1)
+		go func() {
+			select {
+			case <-stopSignals:
+			case <-cmd.Context().Done():
+			case <-stopped:
+				return
+			}
+
+			go func() {
+				select {
+				case <-stopSignals:
+					_ = zap.L().Sync()
+					os.Exit(1)
+				case <-stopped:
+				}
+			}()
+
+			zap.L().Info("shutting down", zap.Int64("active_requests", cfg.ActiveRequests()))
+			err := lib.Drain(server, cfg, drainTimeout)
+			if admin != nil {
+				_ = admin.Close()
+			}
+			drained <- err
+		}()
 
 		// Starts the server.
+		setRunning(cfg, server, listener, statsFile)
 		if getOptB(flags, "tls") {
-			if err := http.ServeTLS(listener, cfg, getOpt(flags, "cert"), getOpt(flags, "key")); err != nil {
-				zap.L().Fatal("shutting server", zap.Error(err))
+			var stop func()
+			server.TLSConfig, stop, err = newTLSConfig(flags)
+			if err != nil {
+				log.Fatal(err)
 			}
+			defer stop()
+
+			err = server.ServeTLS(listener, "", "")
 		} else {
-			if err := http.Serve(listener, cfg); err != nil {
-				zap.L().Fatal("shutting server", zap.Error(err))
+			err = server.Serve(listener)
+		}
+
+		if err != http.ErrServerClosed {
+			if cfg.OnStop != nil {
+				cfg.OnStop()
 			}
+			zap.L().Fatal("shutting server", zap.Error(err))
+		}
+
+		if err := <-drained; err != nil {
+			zap.L().Warn("drain failed", zap.Error(err))
 		}
 	},
 }
 
 func initConfig() {
-	if cfgFile == "" {
-		v.AddConfigPath(".")
-		v.AddConfigPath("/etc/webdav/")
-		v.SetConfigName("config")
-	} else {
-		v.SetConfigFile(cfgFile)
-	}
-
-	v.SetEnvPrefix("WD")
-	v.AutomaticEnv()
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-
-	if err := v.ReadInConfig(); err != nil {
+	if err := loadConfigFile(v.GetViper(), cfgFile); err != nil {
 		if _, ok := err.(v.ConfigParseError); ok {
 			panic(err)
 		}
@@ -126,3 +413,21 @@ func initConfig() {
 		cfgFile = "Using config file: " + v.ConfigFileUsed()
 	}
 }
+
+// loadConfigFile reads the configuration file into config, file or the
+// first config.* file found, and sets up the environment variables.
+func loadConfigFile(config *v.Viper, file string) error {
+	if file == "" {
+		config.AddConfigPath(".")
+		config.AddConfigPath("/etc/webdav/")
+		config.SetConfigName("config")
+	} else {
+		config.SetConfigFile(file)
+	}
+
+	config.SetEnvPrefix("WD")
+	config.AutomaticEnv()
+	config.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	return config.ReadInConfig()
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v "github.com/spf13/viper"
+)
+
+// startServer runs the server in the background with the configuration file
+// of the given YAML content, in which {dir} is replaced by a temporary
+// directory, and returns its address and a function stopping it.
+func startServer(t *testing.T, content string) (string, func() error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.yml")
+	content = "address: 127.0.0.1\nlog_path: \"\"\ntemp_dir: " + filepath.Join(dir, "tmp") + "\n" + content
+	content = strings.ReplaceAll(content, "{dir}", dir)
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	v.Reset()
+	t.Cleanup(func() {
+		v.Reset()
+		cfgFile = ""
+		rootCmd.SetArgs(nil)
+	})
+	rootCmd.SetArgs([]string{"--config", name})
+
+	return runServer(t, ExecuteContext)
+}
+
+// runServer runs the server in the background with run, and returns its
+// address and a function stopping it.
+func runServer(t *testing.T, run func(context.Context) error) (string, func() error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx)
+	}()
+
+	stop := func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Second):
+			return errors.New("the server didn't stop")
+		}
+	}
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if _, listener, err := runningHTTP(); err == nil {
+			return listener.Addr().String(), stop
+		}
+	}
+
+	_ = stop()
+	t.Fatal("the server didn't start")
+	return "", nil
+}
+
+func TestStopClearsRunning(t *testing.T) {
+	_, stop := startServer(t, "scope: {dir}\nport: 0\n")
+
+	if Paused() {
+		t.Errorf("accepting is paused")
+	}
+	if err := PauseAccept(); err != nil {
+		t.Errorf("PauseAccept: %s", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stopped server isn't operated anymore.
+	for name, err := range map[string]error{
+		"PauseAccept":          PauseAccept(),
+		"SetKeepAlivesEnabled": SetKeepAlivesEnabled(false),
+		"ResetStats":           ResetStats(),
+		"ReloadUsers":          ReloadUsers("config.yml"),
+	} {
+		if err != errNotRunning {
+			t.Errorf("%s: got %v, want %v", name, err, errNotRunning)
+		}
+	}
+	if Paused() {
+		t.Errorf("a stopped server is paused")
+	}
+}
+
+func TestWaitForStop(t *testing.T) {
+	if err := WaitForStop(time.Second); err != nil {
+		t.Errorf("without a server: %s", err)
+	}
+
+	addr, stop := startServer(t, "scope: {dir}\nport: 0\n")
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the server stopped, it can be started again on the same port.
+	for i := 0; i < 3; i++ {
+		go func(stop func() error) {
+			_ = stop()
+		}(stop)
+		if err := WaitForStop(10 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatalf("the port is still in use: %s", err)
+		}
+		ln.Close()
+
+		var restarted string
+		restarted, stop = startServer(t, "scope: {dir}\nport: "+port+"\n")
+		if restarted != addr {
+			t.Fatalf("restarted on %s, want %s", restarted, addr)
+		}
+	}
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
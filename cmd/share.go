@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/hacdias/webdav/v4/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	shareCmd := &cobra.Command{
+		Use:   "share <path>",
+		Short: "Create a share link for a path",
+		Long: `Creates a link that gives access to a single path of the default user
+without authentication, until it expires. The links are signed with the
+share_secret of the configuration, so the server must use the same secret.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			flags := cmd.Flags()
+
+			expires, err := flags.GetDuration("expires")
+			checkErr(err)
+
+			writable, err := flags.GetBool("writable")
+			checkErr(err)
+
+			shares := newShares(flags)
+			if shares == nil {
+				checkErr(errors.New("share_secret is not set"))
+			}
+
+			_, link, err := shares.Create(args[0], time.Now().Add(expires), !writable)
+			checkErr(err)
+
+			fmt.Println(link)
+		},
+	}
+
+	shareCmd.Flags().Duration("expires", 24*time.Hour, "time until the link expires")
+	shareCmd.Flags().Bool("writable", false, "allow writing to the path, as the default user can")
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke <link or token>",
+		Short: "Revoke a share link",
+		Long: `Revokes a share link before it expires, by adding its token to the
+share_revoked_file of the configuration. The server refuses it as soon as
+the file is written, and after a restart.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			flags := cmd.Flags()
+
+			shares := newShares(flags)
+			if shares == nil {
+				checkErr(errors.New("share_secret is not set"))
+			}
+			if shares.RevokedFile == "" {
+				checkErr(errors.New("share_revoked_file is not set"))
+			}
+
+			checkErr(shares.Revoke(shareToken(args[0])))
+		},
+	}
+
+	shareCmd.AddCommand(revokeCmd)
+	rootCmd.AddCommand(shareCmd)
+}
+
+// newShares creates the share links settings, or nil if there's no secret.
+func newShares(flags *pflag.FlagSet) *lib.Shares {
+	secret := getOpt(flags, "share_secret")
+	if secret == "" {
+		return nil
+	}
+
+	return &lib.Shares{
+		Secret:      []byte(secret),
+		BaseURL:     getOpt(flags, "share_base_url"),
+		RevokedFile: getOpt(flags, "share_revoked_file"),
+	}
+}
+
+// shareToken returns the token of a share link, or the argument itself if
+// it is a token.
+func shareToken(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	if token := u.Query().Get("token"); token != "" {
+		return token
+	}
+	return link
+}
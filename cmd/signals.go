@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// stopSignals and reloadSignals receive the signals HandleSignals asks for,
+// and nothing until it is called.
+var (
+	stopSignals   = make(chan os.Signal, 1)
+	reloadSignals = make(chan os.Signal, 1)
+	signalsOnce   sync.Once
+)
+
+// HandleSignals makes the server shut down gracefully on SIGINT and SIGTERM,
+// draining the active requests before calling OnStop, and exit immediately
+// on a second one. SIGHUP reloads the users. Execute calls it, but
+// ExecuteContext and ExecuteWithOptions leave the signals to the programs
+// embedding the server unless they call it. Calling it again has no effect,
+// and the signals a platform doesn't have are never received.
+func HandleSignals() {
+	signalsOnce.Do(func() {
+		signal.Notify(stopSignals, os.Interrupt, syscall.SIGTERM)
+		signal.Notify(reloadSignals, syscall.SIGHUP)
+	})
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/hacdias/webdav/v4/lib"
+	"go.uber.org/zap"
+)
+
+// loadStats adds the statistics saved in file to the ones of the server.
+// Failures are logged, and the server starts counting from zero.
+func loadStats(cfg *lib.Config, file string) {
+	if err := cfg.LoadStats(file); err != nil {
+		zap.L().Warn("loading the statistics failed", zap.String("file", file), zap.Error(err))
+	}
+}
+
+// saveStats saves the statistics of the server to file. Failures are
+// logged, and the next save tries again.
+func saveStats(cfg *lib.Config, file string) {
+	if err := cfg.SaveStats(file); err != nil {
+		zap.L().Warn("saving the statistics failed", zap.String("file", file), zap.Error(err))
+	}
+}
+
+// saveStatsEvery saves the statistics of the server to file every interval,
+// until stopped is closed.
+func saveStatsEvery(cfg *lib.Config, file string, interval time.Duration, stopped <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			saveStats(cfg, file)
+		case <-stopped:
+			return
+		}
+	}
+}
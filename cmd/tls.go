@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/hacdias/webdav/v4/lib"
+	"github.com/spf13/pflag"
+)
+
+// tlsCertificate is an entry of the tls_certificates setting.
+type tlsCertificate struct {
+	cert, key string
+	hostnames []string
+}
+
+// parseTLSCertificates parses the tls_certificates setting, a list of
+// {cert, key, hostnames} maps.
+func parseTLSCertificates(raw interface{}) ([]tlsCertificate, error) {
+	list, ok := raw.([]interface{})
+	if raw == nil || (ok && len(list) == 0) {
+		return nil, nil
+	}
+	if !ok {
+		return nil, errors.New("must be a list")
+	}
+
+	certs := []tlsCertificate{}
+	for i, item := range list {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("entry %d: invalid definition", i)
+		}
+
+		cert, _ := m["cert"].(string)
+		key, _ := m["key"].(string)
+		if cert == "" || key == "" {
+			return nil, fmt.Errorf("entry %d: cert and key are required", i)
+		}
+
+		certs = append(certs, tlsCertificate{cert: cert, key: key, hostnames: stringList(m["hostnames"])})
+	}
+
+	return certs, nil
+}
+
+// newTLSConfig creates the TLS configuration of the server: the cert and
+// key, with their OCSP response stapled if ocsp_stapling is set, and the
+// certificates of tls_certificates selected by the server name the clients
+// ask for. The returned function stops the OCSP stapling.
+func newTLSConfig(flags *pflag.FlagSet) (*tls.Config, func(), error) {
+	extra, err := parseTLSCertificates(getRawOpt(flags, "tls_certificates"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid tls_certificates: %w", err)
+	}
+
+	stop := func() {}
+	var getDefault func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	certPEM, ok := runOption(flags, "cert_pem")
+	if ok {
+		// The certificate given by the options has no file to staple the
+		// OCSP response of.
+		keyPEM, _ := runOption(flags, "key_pem")
+		cert, err := tls.X509KeyPair(certPEM.([]byte), keyPEM.([]byte))
+		if err != nil {
+			return nil, nil, err
+		}
+		getDefault = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
+	} else if getOptB(flags, "ocsp_stapling") {
+		stapler, err := lib.NewOCSPStapler(getOpt(flags, "cert"), getOpt(flags, "key"))
+		if err != nil {
+			return nil, nil, err
+		}
+		stapler.Start()
+		stop = stapler.Stop
+		getDefault = stapler.GetCertificate
+	} else if cert, err := tls.LoadX509KeyPair(getOpt(flags, "cert"), getOpt(flags, "key")); err == nil {
+		getDefault = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
+	} else if len(extra) == 0 {
+		return nil, nil, err
+	}
+
+	if len(extra) == 0 {
+		return &tls.Config{GetCertificate: getDefault}, stop, nil
+	}
+
+	sni := &lib.SNICertificates{Default: getDefault}
+	for _, c := range extra {
+		if err := sni.Add(c.cert, c.key, c.hostnames); err != nil {
+			stop()
+			return nil, nil, fmt.Errorf("invalid tls_certificates: %w", err)
+		}
+	}
+
+	// Without cert and key, the first certificate is the default one.
+	if sni.Default == nil {
+		first := extra[0]
+		fallback, err := tls.LoadX509KeyPair(first.cert, first.key)
+		if err != nil {
+			return nil, nil, err
+		}
+		sni.Default = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &fallback, nil
+		}
+	}
+
+	return &tls.Config{GetCertificate: sni.GetCertificate}, stop, nil
+}
@@ -0,0 +1,28 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package cmd
+
+import (
+	"net"
+	"syscall"
+)
+
+// v6OnlyControl is not supported on this platform: IPv6 sockets keep the
+// IPV6_V6ONLY default of Go, which is cleared on the wildcard address when
+// listening to "tcp".
+func v6OnlyControl(only bool) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
+
+// listenerFamilies returns the IP family of the address of a TCP listener.
+func listenerFamilies(ln net.Listener) []string {
+	addr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if addr.IP.To4() != nil {
+		return []string{"ipv4"}
+	}
+	return []string{"ipv6"}
+}
@@ -0,0 +1,62 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package cmd
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// v6OnlyControl returns a function that sets IPV6_V6ONLY on an IPv6 socket
+// before it is bound. Cleared, the socket accepts IPv4 connections too, as
+// IPv4-mapped addresses.
+func v6OnlyControl(only bool) func(network, address string, c syscall.RawConn) error {
+	value := 0
+	if only {
+		value = 1
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, value)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// listenerFamilies returns the IP families a TCP listener accepts
+// connections of, "ipv4" and "ipv6", reading IPV6_V6ONLY on IPv6 sockets.
+func listenerFamilies(ln net.Listener) []string {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+
+	addr := tl.Addr().(*net.TCPAddr)
+	if addr.IP.To4() != nil {
+		return []string{"ipv4"}
+	}
+
+	rc, err := tl.SyscallConn()
+	if err != nil {
+		return []string{"ipv6"}
+	}
+
+	only := 1
+	_ = rc.Control(func(fd uintptr) {
+		if v, err := unix.GetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_V6ONLY); err == nil {
+			only = v
+		}
+	})
+	if only == 0 && addr.IP.IsUnspecified() {
+		return []string{"ipv4", "ipv6"}
+	}
+	return []string{"ipv6"}
+}
@@ -0,0 +1,436 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hacdias/webdav/v4/lib"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	v "github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration without starting the server",
+		Run: func(cmd *cobra.Command, args []string) {
+			errs := ValidateConfig(v.ConfigFileUsed())
+			if len(errs) == 0 {
+				fmt.Println("Configuration is valid")
+				return
+			}
+
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(1)
+		},
+	})
+}
+
+// ValidateConfig validates a configuration file, along with the environment
+// variables and the flags, as the validate command does, and returns every
+// problem found. If configFile is empty, the config file of the server is
+// looked for as when starting, and the defaults are validated if there is
+// none. It neither binds any address nor sets up the logger, and the file is
+// read apart from the configuration of the server.
+func ValidateConfig(configFile string) []error {
+	config := v.New()
+	if err := loadConfigFile(config, configFile); err != nil {
+		var notFound v.ConfigFileNotFoundError
+		if configFile != "" || !errors.As(err, &notFound) {
+			return []error{fmt.Errorf("config: %w", err)}
+		}
+	}
+
+	return validateConfig(withSettings(rootCmd.PersistentFlags(), config))
+}
+
+// ConfigErrors are the problems found in a configuration, one per line.
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e ConfigErrors) Unwrap() []error {
+	return e
+}
+
+// ValidateConfigError is ValidateConfig returning the problems found as a
+// single ConfigErrors, or nil if there are none.
+func ValidateConfigError(configFile string) error {
+	if errs := ValidateConfig(configFile); len(errs) != 0 {
+		return ConfigErrors(errs)
+	}
+	return nil
+}
+
+// validateConfig runs all the configuration checks without binding any
+// address, returning every problem found.
+func validateConfig(flags *pflag.FlagSet) []error {
+	errs := []error{}
+
+	address := getOpt(flags, "address")
+	if strings.HasPrefix(address, "unix:") {
+		if address == "unix:" {
+			errs = append(errs, errors.New("address: unix socket path is empty"))
+		}
+
+		if _, err := parseMode(getOpt(flags, "socket_mode")); err != nil {
+			errs = append(errs, fmt.Errorf("socket_mode: %w", err))
+		}
+	} else {
+		// The host names are not resolved, which could fail or block when
+		// validating before deploying.
+		if host := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"); net.ParseIP(host) == nil && !validHostname(host) {
+			errs = append(errs, fmt.Errorf("address: %q is not a valid IP or host", address))
+		}
+
+		port, err := strconv.Atoi(getOpt(flags, "port"))
+		if err != nil || port < 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("port: %q is not a valid port", getOpt(flags, "port")))
+		}
+
+		switch version := getOpt(flags, "ip_version"); version {
+		case "", "4", "6", "dual":
+		default:
+			errs = append(errs, fmt.Errorf("ip_version: %q is not 4, 6 or dual", version))
+		}
+
+		if keepAlive := getOpt(flags, "tcp_keepalive"); keepAlive != "" {
+			if _, err := time.ParseDuration(keepAlive); err != nil {
+				errs = append(errs, fmt.Errorf("tcp_keepalive: %w", err))
+			}
+		}
+
+		if backlog, err := strconv.Atoi(getOpt(flags, "listen_backlog")); err != nil || backlog < 0 {
+			errs = append(errs, fmt.Errorf("listen_backlog: %q is not a valid size", getOpt(flags, "listen_backlog")))
+		}
+
+		if portRange := getOpt(flags, "port_range"); portRange != "" {
+			if _, _, err := parsePortRange(portRange); err != nil {
+				errs = append(errs, fmt.Errorf("port_range: %w", err))
+			}
+		}
+	}
+
+	if getOptB(flags, "tls") {
+		certs, err := parseTLSCertificates(getRawOpt(flags, "tls_certificates"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tls_certificates: %w", err))
+		}
+		for i, c := range certs {
+			if _, err := tls.LoadX509KeyPair(c.cert, c.key); err != nil {
+				errs = append(errs, fmt.Errorf("tls_certificates[%d]: %w", i, err))
+			}
+		}
+
+		// The cert and key are optional with tls_certificates.
+		if _, err := tls.LoadX509KeyPair(getOpt(flags, "cert"), getOpt(flags, "key")); err != nil && len(certs) == 0 {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(getOpt(flags, "log_level"))); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+
+	if _, err := lib.ParseTrustedProxies(getOpt(flags, "trusted_proxies")); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+
+	if interval, err := time.ParseDuration(getOpt(flags, "stats_interval")); err != nil || interval <= 0 {
+		errs = append(errs, fmt.Errorf("stats_interval: %q is not a positive duration", getOpt(flags, "stats_interval")))
+	}
+
+	timeouts := []string{
+		"request_timeout", "transfer_timeout", "transfer_idle_timeout", "read_header_timeout",
+		"body_idle_timeout", "keepalive_timeout", "drain_timeout", "max_lock_timeout",
+	}
+	if getOpt(flags, "auth_webhook_url") != "" {
+		timeouts = append(timeouts, "auth_webhook_ttl")
+	}
+	for _, name := range timeouts {
+		// An empty keepalive_timeout is the default of the HTTP server.
+		if raw := getOpt(flags, name); raw != "" || name != "keepalive_timeout" {
+			if _, err := time.ParseDuration(raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	}
+
+	if getOptB(flags, "tus") {
+		if _, err := parseTus(flags, ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if getOptB(flags, "thumbnails") {
+		if _, err := parseThumbnails(flags, ""); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := parseEncryptionKey(flags); err != nil {
+		errs = append(errs, fmt.Errorf("encryption_key: %w", err))
+	}
+
+	if _, err := parseMimeTypes(getRawOpt(flags, "mime_types")); err != nil {
+		errs = append(errs, fmt.Errorf("mime_types: %w", err))
+	}
+
+	if _, err := parseHidePatterns(flags); err != nil {
+		errs = append(errs, fmt.Errorf("hide_patterns: %w", err))
+	}
+
+	if _, err := parseSymlinks(flags); err != nil {
+		errs = append(errs, fmt.Errorf("symlinks: %w", err))
+	}
+
+	if _, err := parseNormalization(flags); err != nil {
+		errs = append(errs, fmt.Errorf("normalize_filenames: %w", err))
+	}
+
+	for _, name := range []string{"file_mode", "dir_mode"} {
+		if _, err := parseMode(getOpt(flags, name)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	if group := getOpt(flags, "file_group"); group != "" {
+		if _, err := lookupGroup(group); err != nil {
+			errs = append(errs, fmt.Errorf("file_group: %w", err))
+		}
+	}
+
+	if _, err := parseHeaders(getRawOpt(flags, "headers")); err != nil {
+		errs = append(errs, fmt.Errorf("headers: %w", err))
+	}
+
+	if timezone := getOpt(flags, "timezone"); timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			errs = append(errs, fmt.Errorf("timezone: %w", err))
+		}
+	}
+
+	if _, err := parseWriteWindows(getRawOpt(flags, "write_windows")); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := lib.ValidateDepth(getOpt(flags, "max_propfind_depth")); err != nil {
+		errs = append(errs, fmt.Errorf("max_propfind_depth: %w", err))
+	}
+
+	if _, err := loadPage(getOpt(flags, "unauthorized_page")); err != nil {
+		errs = append(errs, fmt.Errorf("unauthorized_page: %w", err))
+	}
+
+	if _, err := strconv.Atoi(getOpt(flags, "debug_body_size")); err != nil {
+		errs = append(errs, fmt.Errorf("debug_body_size: %w", err))
+	}
+
+	if _, err := strconv.Atoi(getOpt(flags, "max_propfind_entries")); err != nil {
+		errs = append(errs, fmt.Errorf("max_propfind_entries: %w", err))
+	}
+
+	if _, err := strconv.ParseInt(getOpt(flags, "max_upload_size"), 10, 64); err != nil {
+		errs = append(errs, fmt.Errorf("max_upload_size: %w", err))
+	}
+
+	modify := getOptB(flags, "modify")
+	errs = append(errs, validateScope("scope", getOpt(flags, "scope"), modify)...)
+
+	// A missing temporary directory is created when starting.
+	if dir := getOpt(flags, "temp_dir"); dir != "" {
+		if _, err := os.Stat(dir); err == nil {
+			errs = append(errs, validateScope("temp_dir", dir, true)...)
+		}
+	}
+
+	template := getOpt(flags, "scope_template")
+	if template != "" && !strings.Contains(template, "{user}") {
+		errs = append(errs, fmt.Errorf("scope_template: %q doesn't contain {user}", template))
+	}
+
+	if rules, ok := getRawOpt(flags, "rules").([]interface{}); ok {
+		errs = append(errs, validateRules("rules", rules)...)
+	}
+
+	// The scopes of the users, by username, which must not overlap if
+	// exclusive_scopes is set.
+	scopes := map[string]string{}
+
+	if users, ok := getRawOpt(flags, "users").([]interface{}); ok {
+		for i, raw := range users {
+			name := fmt.Sprintf("users[%d]", i)
+
+			u, ok := raw.(map[interface{}]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: invalid user definition", name))
+				continue
+			}
+
+			username, ok := u["username"].(string)
+			if !ok || username == "" {
+				errs = append(errs, fmt.Errorf("%s: user needs an username", name))
+			} else if strings.HasPrefix(username, "{env}") {
+				if _, err := loadFromEnv(username); err != nil {
+					errs = append(errs, fmt.Errorf("%s: username: %w", name, err))
+				}
+			}
+
+			if tag, ok := u["log_tag"]; ok {
+				if _, ok := tag.(string); !ok {
+					errs = append(errs, fmt.Errorf("%s: log_tag must be a string", name))
+				}
+			}
+
+			if password, ok := u["password"].(string); ok && strings.HasPrefix(password, "{env}") {
+				if _, err := loadFromEnv(password); err != nil {
+					errs = append(errs, fmt.Errorf("%s: password: %w", name, err))
+				}
+			}
+
+			userModify := modify
+			if m, ok := u["modify"].(bool); ok {
+				userModify = m
+			}
+
+			scope, ok := u["scope"].(string)
+			if ok {
+				errs = append(errs, validateScope(name+".scope", scope, userModify)...)
+			} else if template != "" {
+				scope = strings.ReplaceAll(os.ExpandEnv(template), "{user}", username)
+			} else {
+				scope = getOpt(flags, "scope")
+			}
+			if username != "" {
+				scopes[username] = scope
+			}
+
+			if rules, ok := u["rules"].([]interface{}); ok {
+				errs = append(errs, validateRules(name+".rules", rules)...)
+			}
+
+			if windows, ok := u["write_windows"]; ok {
+				if _, err := parseWriteWindows(windows); err != nil {
+					errs = append(errs, fmt.Errorf("%s.%w", name, err))
+				}
+			}
+		}
+	}
+
+	if getOptB(flags, "exclusive_scopes") {
+		for _, err := range scopesOverlap(scopes) {
+			errs = append(errs, fmt.Errorf("users: %w", err))
+		}
+	}
+
+	return errs
+}
+
+// validHostname checks the syntax of a host name, without resolving it.
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, ch := range label {
+			if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateScope checks that the scope directory exists and, if the user is
+// allowed to modify it, that it is writable. Scopes of a single file are
+// read only, and the pattern of glob scopes must be valid.
+func validateScope(name, scope string, modify bool) []error {
+	if strings.HasPrefix(scope, "s3://") {
+		if u, err := url.Parse(scope); err != nil || u.Host == "" {
+			return []error{fmt.Errorf("%s: %q is not a valid bucket", name, scope)}
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(scope, "mem:") {
+		raw := strings.TrimPrefix(scope, "mem:")
+		if _, err := strconv.ParseInt(raw, 10, 64); raw != "" && err != nil {
+			return []error{fmt.Errorf("%s: invalid memory limit %q", name, raw)}
+		}
+		return nil
+	}
+
+	scope, pattern, single := splitScope(scope)
+	if single {
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return []error{fmt.Errorf("%s: invalid pattern %q: %w", name, pattern, err)}
+	}
+
+	info, err := os.Stat(scope)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", name, err)}
+	}
+
+	if !info.IsDir() {
+		return []error{fmt.Errorf("%s: %q is not a directory", name, scope)}
+	}
+
+	if modify && !writable(scope, info) {
+		return []error{fmt.Errorf("%s: %q is not writable", name, scope)}
+	}
+
+	return nil
+}
+
+func validateRules(name string, raw []interface{}) []error {
+	errs := []error{}
+
+	for i, v := range raw {
+		r, ok := v.(map[interface{}]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s[%d]: invalid rule definition", name, i))
+			continue
+		}
+
+		path, ok := r["path"].(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s[%d]: rule needs a path", name, i))
+			continue
+		}
+
+		if regex, ok := r["regex"].(bool); ok && regex {
+			if _, err := regexp.Compile(path); err != nil {
+				errs = append(errs, fmt.Errorf("%s[%d]: %w", name, i, err))
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v "github.com/spf13/viper"
+)
+
+// validateTestConfig validates a configuration file with the given YAML
+// content, in which {dir} is replaced by a temporary directory.
+func validateTestConfig(t *testing.T, content string) []error {
+	t.Helper()
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.yml")
+	content = strings.ReplaceAll(content, "{dir}", dir)
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return ValidateConfig(name)
+}
+
+func TestValidateConfig(t *testing.T) {
+	errs := validateTestConfig(t, `
+scope: {dir}
+port: 8080
+`)
+	if len(errs) != 0 {
+		t.Errorf("got %v, want no error", errs)
+	}
+}
+
+func TestValidateConfigEveryProblem(t *testing.T) {
+	errs := validateTestConfig(t, `
+scope: {dir}/missing
+port: 99999
+tls: true
+cert: {dir}/cert.pem
+key: {dir}/key.pem
+rules:
+  - regex: true
+    path: "["
+users:
+  - username: alice
+    password: secret
+    scope: {dir}/missing-too
+request_timeout: soon
+drain_timeout: 10
+max_lock_timeout: forever
+tus: true
+tus_expiration: 1 day
+thumbnails: true
+thumb_sizes: 128,0
+mime_types:
+  md: not a type
+symlinks: sometimes
+normalize_filenames: nfx
+hide_patterns:
+  - "["
+encryption_key: a password
+`)
+
+	problems := []string{
+		"port:", "tls:", "rules[0]:", "scope: stat", "users[0].scope:",
+		"request_timeout:", "drain_timeout:", "max_lock_timeout:", "tus_expiration:", "thumb_sizes:",
+		"mime_types:", "symlinks:", "normalize_filenames:", "hide_patterns:", "encryption_key:",
+	}
+	for _, problem := range problems {
+		found := false
+		for _, err := range errs {
+			if strings.Contains(err.Error(), problem) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%q isn't reported in %v", problem, errs)
+		}
+	}
+}
+
+func TestValidateConfigMissingFile(t *testing.T) {
+	errs := ValidateConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "config:") {
+		t.Errorf("got %v, want a single config error", errs)
+	}
+}
+
+func TestValidateConfigKeepsServerConfig(t *testing.T) {
+	v.Reset()
+	t.Cleanup(v.Reset)
+	v.Set("port", "8080")
+	cfgFile = "server.yml"
+	t.Cleanup(func() { cfgFile = "" })
+
+	validateTestConfig(t, "scope: {dir}\nport: 9090\n")
+
+	if cfgFile != "server.yml" {
+		t.Errorf("got config file %q, want server.yml", cfgFile)
+	}
+	if v.IsSet("scope") || v.GetString("port") != "8080" {
+		t.Errorf("the configuration of the server was modified: %v", v.AllSettings())
+	}
+}
+
+func TestValidateConfigError(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(name, []byte("scope: "+dir+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateConfigError(name); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+
+	if err := os.WriteFile(name, []byte("scope: "+dir+"/missing\nport: 99999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := ValidateConfigError(name)
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got %v, want two problems", err)
+	}
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "port:") || !strings.HasPrefix(lines[1], "scope:") {
+		t.Errorf("got %q, want a line per problem", err.Error())
+	}
+}
+
+func TestValidateConfigAddress(t *testing.T) {
+	for address, valid := range map[string]bool{
+		"0.0.0.0":             true,
+		"[::1]":               true,
+		"localhost":           true,
+		"webdav.invalid":      true,
+		"not a host":          false,
+		"-example.com":        false,
+		"unix:{dir}/dav.sock": true,
+	} {
+		errs := validateTestConfig(t, "scope: {dir}\naddress: \""+address+"\"\n")
+		if valid && len(errs) != 0 {
+			t.Errorf("%s: got %v, want no error", address, errs)
+		} else if !valid && (len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "address:")) {
+			t.Errorf("%s: got %v, want an address error", address, errs)
+		}
+	}
+
+	errs := validateTestConfig(t, "scope: {dir}\naddress: unix:{dir}/dav.sock\nsocket_mode: 999\n")
+	if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "socket_mode:") {
+		t.Errorf("got %v, want a socket_mode error", errs)
+	}
+}
+
+func TestValidateConfigExclusiveScopes(t *testing.T) {
+	content := `
+scope: {dir}
+exclusive_scopes: %t
+users:
+  - username: alice
+    password: secret
+    scope: {dir}/alice
+  - username: bob
+    password: secret
+    scope: {dir}/alice/bob
+  - username: carol
+    password: secret
+    scope: {dir}/carol
+`
+	dir := t.TempDir()
+	for _, name := range []string{"alice/bob", "carol"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content = strings.ReplaceAll(content, "{dir}", dir)
+
+	if errs := validateTestConfig(t, fmt.Sprintf(content, false)); len(errs) != 0 {
+		t.Errorf("got %v, want no error", errs)
+	}
+
+	errs := validateTestConfig(t, fmt.Sprintf(content, true))
+	if len(errs) != 1 || errs[0].Error() != "users: the scopes of alice and bob overlap" {
+		t.Errorf("got %v, want the scopes of alice and bob to overlap", errs)
+	}
+}
+
+func TestValidateConfigWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	if errs := validateTestConfig(t, "scope: "+dir+"\nmodify: true\n"); len(errs) != 0 {
+		t.Fatalf("got %v, want no error", errs)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("the scope was written to: %v", entries)
+	}
+}
@@ -23,8 +23,8 @@ func getOpt(flags *pflag.FlagSet, key string) string {
 	}
 
 	// If set through viper (env, config), return it.
-	if v.IsSet(key) {
-		return v.GetString(key)
+	if config := settings(flags); config.IsSet(key) {
+		return config.GetString(key)
 	}
 
 	// Otherwise use default value on flags.
@@ -40,14 +40,55 @@ func getOptB(flags *pflag.FlagSet, key string) bool {
 	}
 
 	// If set through viper (env, config), return it.
-	if v.IsSet(key) {
-		return v.GetBool(key)
+	if config := settings(flags); config.IsSet(key) {
+		return config.GetBool(key)
 	}
 
 	// Otherwise use default value on flags.
 	return value
 }
 
+// getRawOpt returns a setting that isn't a flag, such as a list: the value
+// given by the options of the run, if any, or the one of viper.
+func getRawOpt(flags *pflag.FlagSet, key string) interface{} {
+	if value, ok := runOption(flags, key); ok {
+		return value
+	}
+	return settings(flags).Get(key)
+}
+
+// settingsKey is the flag holding the viper instance the settings are read
+// from, instead of the global one, such as when validating a file.
+const settingsKey = "settings"
+
+// settings returns the viper instance the settings of flags are read from.
+func settings(flags *pflag.FlagSet) *v.Viper {
+	if value, ok := runOption(flags, settingsKey); ok {
+		return value.(*v.Viper)
+	}
+	return v.GetViper()
+}
+
+// withSettings returns flags reading the settings from config rather than
+// from the global viper instance.
+func withSettings(flags *pflag.FlagSet, config *v.Viper) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("settings", pflag.ContinueOnError)
+	flags.VisitAll(fs.AddFlag)
+	fs.Var(&rawValue{value: config}, settingsKey, "")
+	return fs
+}
+
+// runOption returns a setting given by the options of the run that isn't a
+// flag, if they set it.
+func runOption(flags *pflag.FlagSet, key string) (interface{}, bool) {
+	if f := flags.Lookup(key); f != nil {
+		if raw, ok := f.Value.(*rawValue); ok {
+			return raw.value, true
+		}
+	}
+	return nil, false
+}
+
 func checkErr(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package cmd
+
+import "os"
+
+// writable checks if the directory isn't read only, from its permissions.
+func writable(dir string, info os.FileInfo) bool {
+	return info.Mode().Perm()&0o222 != 0
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// writable checks if the process can create files in the directory.
+func writable(dir string, info os.FileInfo) bool {
+	return unix.Access(dir, unix.W_OK|unix.X_OK) == nil
+}
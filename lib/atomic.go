@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/net/webdav"
+)
+
+// atomicPrefix is the prefix of the temporary files uploads are written to.
+const atomicPrefix = ".webdav-upload-"
+
+// AtomicFS wraps a webdav.FileSystem so that the files opened for
+// truncation, such as uploads, are written to a temporary file in the same
+// directory, which replaces the file once it is closed. Readers never see a
+// partial file, and a failed upload leaves the previous version intact.
+// Since the temporary file is written through the wrapped filesystem, its
+// limits apply while writing.
+type AtomicFS struct {
+	webdav.FileSystem
+}
+
+// OpenFile opens a file. Files opened for writing and truncation are
+// written to a temporary file.
+func (fs AtomicFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&os.O_TRUNC == 0 || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+		return atomicDir{File: file}, nil
+	}
+
+	if info, err := fs.FileSystem.Stat(ctx, name); err == nil && info.IsDir() {
+		return nil, os.ErrExist
+	}
+
+	dir, base := path.Split(path.Clean("/" + name))
+	tmp := path.Join(dir, atomicPrefix+randomHex(8)+"-"+base)
+
+	file, err := fs.FileSystem.OpenFile(ctx, tmp, (flag&^os.O_TRUNC)|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicFile{File: file, fs: fs, ctx: ctx, tmp: tmp, name: name}, nil
+}
+
+type atomicFile struct {
+	webdav.File
+	fs     AtomicFS
+	ctx    context.Context
+	tmp    string
+	name   string
+	failed bool
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+// Close closes the temporary file, and replaces the file with it if it was
+// entirely written, or removes it otherwise.
+func (f *atomicFile) Close() error {
+	err := f.File.Close()
+	if err != nil || f.failed || !uploadComplete(f.ctx) {
+		_ = f.fs.FileSystem.RemoveAll(context.Background(), f.tmp)
+		return err
+	}
+
+	return f.fs.FileSystem.Rename(f.ctx, f.tmp, f.name)
+}
+
+// atomicDir leaves the temporary files out of the directory entries.
+type atomicDir struct {
+	webdav.File
+}
+
+func (f atomicDir) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), atomicPrefix) {
+			visible = append(visible, info)
+		}
+	}
+
+	return visible, err
+}
+
+type uploadKey struct{}
+
+// upload tracks whether the body of an upload was entirely read.
+type upload struct {
+	io.ReadCloser
+	complete int32
+}
+
+func (u *upload) Read(p []byte) (int, error) {
+	n, err := u.ReadCloser.Read(p)
+	if err == io.EOF {
+		atomic.StoreInt32(&u.complete, 1)
+	}
+	return n, err
+}
+
+// withUpload tracks the body of an upload request, so that an interrupted
+// upload doesn't replace the file.
+func withUpload(r *http.Request) *http.Request {
+	u := &upload{ReadCloser: r.Body}
+	r = r.WithContext(context.WithValue(r.Context(), uploadKey{}, u))
+	r.Body = u
+	return r
+}
+
+// uploadComplete checks if the upload of the request, if any, was entirely
+// read.
+func uploadComplete(ctx context.Context) bool {
+	if u, ok := ctx.Value(uploadKey{}).(*upload); ok {
+		return atomic.LoadInt32(&u.complete) == 1
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnauthorized is returned when the authentication webhook rejects the
+// credentials.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// AuthWebhook delegates the authentication to an external service. The
+// credentials are POSTed as JSON to the URL, which answers 200 to accept
+// them or 401 or 403 to reject them. A 200 response may contain a JSON
+// object with the "scope" and "read_only" of the user.
+type AuthWebhook struct {
+	URL    string
+	Client *http.Client
+
+	// TTL is how long accepted credentials are cached. Zero disables the
+	// cache.
+	TTL time.Duration
+
+	// Users creates the authenticated users.
+	Users *UserFactory
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]authEntry
+}
+
+type authEntry struct {
+	user    *User
+	expires time.Time
+}
+
+type authResponse struct {
+	Scope    string `json:"scope"`
+	ReadOnly *bool  `json:"read_only"`
+}
+
+// NewAuthWebhook creates a new AuthWebhook.
+func NewAuthWebhook(url string, ttl time.Duration, users *UserFactory) *AuthWebhook {
+	return &AuthWebhook{
+		URL:    url,
+		TTL:    ttl,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Users:  users,
+		cache:  map[[sha256.Size]byte]authEntry{},
+	}
+}
+
+// Authenticate checks the credentials with the webhook and returns the
+// user they belong to.
+func (h *AuthWebhook) Authenticate(username, password string) (*User, error) {
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+
+	h.mu.Lock()
+	entry, ok := h.cache[key]
+	h.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.user, nil
+	}
+
+	res, err := h.check(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.Users.Get(username, res.Scope, res.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.TTL > 0 {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		now := time.Now()
+		for k, e := range h.cache {
+			if now.After(e.expires) {
+				delete(h.cache, k)
+			}
+		}
+		h.cache[key] = authEntry{user: user, expires: now.Add(h.TTL)}
+	}
+
+	return user, nil
+}
+
+func (h *AuthWebhook) check(username, password string) (*authResponse, error) {
+	body, err := json.Marshal(map[string]string{
+		"username": username,
+		"password": password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := h.Client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrUnauthorized
+	default:
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &authResponse{}
+	if len(bytes.TrimSpace(data)) != 0 {
+		if err := json.Unmarshal(data, auth); err != nil {
+			return nil, err
+		}
+	}
+
+	return auth, nil
+}
@@ -0,0 +1,36 @@
+package lib
+
+import "net/http"
+
+// DefaultRobots is the robots.txt answered when Config.Robots is empty,
+// which disallows crawling the whole server.
+const DefaultRobots = "User-agent: *\nDisallow: /\n"
+
+// serveBrowserFile answers the requests browsers make on their own, for
+// /favicon.ico with 204 No Content and for /robots.txt with Robots. It
+// returns false for the other requests.
+func (c *Config) serveBrowserFile(w http.ResponseWriter, r *http.Request) bool {
+	if !c.BrowserFiles || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+
+	switch r.URL.Path {
+	case "/favicon.ico":
+		w.Header().Set("Cache-Control", "max-age=86400")
+		w.WriteHeader(http.StatusNoContent)
+	case "/robots.txt":
+		robots := c.Robots
+		if len(robots) == 0 {
+			robots = []byte(DefaultRobots)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if r.Method == "GET" {
+			_, _ = w.Write(robots)
+		}
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// CapabilitiesPath is the URL path of the capabilities document, which
+// tells the clients the optional features of the server.
+const CapabilitiesPath = "/.well-known/webdav-capabilities"
+
+// CapabilitiesVersion is the version of the capabilities document. It is
+// increased when the meaning of existing fields changes, while new
+// features and limits can be added without it.
+const CapabilitiesVersion = 1
+
+// capabilities returns the capabilities document. Features missing from it
+// are not supported, or disabled.
+func (c *Config) capabilities() map[string]interface{} {
+	features := map[string]interface{}{
+		"download": true,
+	}
+
+	if c.Tus != nil {
+		features["resumable_uploads"] = map[string]interface{}{
+			"protocol": "tus",
+			"version":  TusVersion,
+			"path":     c.Tus.Path,
+		}
+	}
+	if c.Shares != nil {
+		features["share_links"] = true
+	}
+	if c.Thumbnails != nil {
+		features["thumbnails"] = map[string]interface{}{
+			"sizes": c.Thumbnails.Sizes,
+		}
+	}
+	if c.DirListing {
+		features["dir_listing"] = true
+	}
+	if c.PartialUpdates {
+		features["partial_updates"] = true
+	}
+
+	depth := c.MaxPropfindDepth
+	if depth == "" {
+		depth = DepthInfinity
+	}
+
+	limits := map[string]interface{}{
+		"max_propfind_depth":   depth,
+		"max_propfind_entries": c.MaxPropfindEntries,
+		"max_lock_timeout":     int64(c.MaxLockTimeout.Seconds()),
+	}
+	if c.MaxUploadSize > 0 {
+		limits["max_upload_size"] = c.MaxUploadSize
+	}
+	if c.Tus != nil && c.Tus.maxSize(c) > 0 {
+		limits["max_upload_size"] = c.Tus.maxSize(c)
+	}
+
+	return map[string]interface{}{
+		"version":   CapabilitiesVersion,
+		"auth":      c.Auth,
+		"read_only": c.ReadOnly(),
+		"features":  features,
+		"limits":    limits,
+	}
+}
+
+// serveCapabilities answers the requests of the capabilities document,
+// which don't require authentication.
+func (c *Config) serveCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := json.Marshal(c.capabilities())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Method == "GET" {
+		_, _ = w.Write(body)
+	}
+}
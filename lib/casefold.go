@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAmbiguousName is returned when a path matches several entries that only
+// differ by case, and none of them exactly.
+var ErrAmbiguousName = errors.New("ambiguous name")
+
+// caseCacheTTL is how long the directory entries are cached for the case
+// insensitive lookups.
+const caseCacheTTL = 5 * time.Second
+
+// caseCache caches the entries of the directories looked up when resolving
+// paths case insensitively.
+type caseCache struct {
+	mu   sync.Mutex
+	dirs map[string]caseDir
+}
+
+type caseDir struct {
+	names   []string
+	expires time.Time
+}
+
+// caseNames returns the entries of dir. Unless fresh is set, they can come from
+// the cache.
+func (c *Config) caseNames(ctx context.Context, u *User, dir string, fresh bool) ([]string, error) {
+	key := u.Scope + "\x00" + dir
+
+	c.caseCache.mu.Lock()
+	cached, ok := c.caseCache.dirs[key]
+	c.caseCache.mu.Unlock()
+	if ok && !fresh && time.Now().Before(cached.expires) {
+		return cached.names, nil
+	}
+
+	f, err := u.Handler.FileSystem.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+
+	c.caseCache.mu.Lock()
+	if c.caseCache.dirs == nil || len(c.caseCache.dirs) > 1000 {
+		c.caseCache.dirs = map[string]caseDir{}
+	}
+	c.caseCache.dirs[key] = caseDir{names: names, expires: time.Now().Add(caseCacheTTL)}
+	c.caseCache.mu.Unlock()
+
+	return names, nil
+}
+
+// foldMatch returns the name matching elem: itself if it exists, or the
+// only one equal to it under case folding, or "" if there is none.
+func foldMatch(names []string, elem string) (string, error) {
+	match := ""
+	for _, name := range names {
+		if name == elem {
+			return name, nil
+		}
+		if strings.EqualFold(name, elem) {
+			if match != "" {
+				return "", ErrAmbiguousName
+			}
+			match = name
+		}
+	}
+	return match, nil
+}
+
+// resolveCase returns the path of the existing file matching name case
+// insensitively. The elements that don't exist are kept as they are, so
+// that new files are created with the case the client asked for.
+func (c *Config) resolveCase(ctx context.Context, u *User, name string) (string, error) {
+	name = path.Clean("/" + name)
+	if _, err := u.Handler.FileSystem.Stat(ctx, name); err == nil || name == "/" {
+		return name, nil
+	}
+
+	resolved, err := c.resolveCaseEntries(ctx, u, name, false)
+	if err != nil || resolved == name {
+		return resolved, err
+	}
+
+	// The cached entries may have matched a file that was since renamed.
+	if _, err := u.Handler.FileSystem.Stat(ctx, resolved); err != nil {
+		return c.resolveCaseEntries(ctx, u, name, true)
+	}
+	return resolved, nil
+}
+
+// resolveCaseEntries resolves name element by element, looking up each one
+// in the entries of its parent.
+func (c *Config) resolveCaseEntries(ctx context.Context, u *User, name string, fresh bool) (string, error) {
+	resolved := "/"
+	elems := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, elem := range elems {
+		names, err := c.caseNames(ctx, u, resolved, fresh)
+		if err != nil {
+			// The parent isn't a directory: nothing below exists.
+			return path.Join(append([]string{resolved}, elems[i:]...)...), nil
+		}
+
+		match, err := foldMatch(names, elem)
+		if err == nil && match == "" && !fresh {
+			// The entries may be outdated.
+			if names, err = c.caseNames(ctx, u, resolved, true); err == nil {
+				match, err = foldMatch(names, elem)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if match == "" {
+			return path.Join(append([]string{resolved}, elems[i:]...)...), nil
+		}
+		resolved = path.Join(resolved, match)
+	}
+
+	return resolved, nil
+}
+
+// resolveURLCase returns the path of an URL, under the prefix of the user,
+// with the case of the existing files. The trailing slash is kept.
+func (c *Config) resolveURLCase(ctx context.Context, u *User, p string) (string, error) {
+	if !strings.HasPrefix(p, u.Handler.Prefix) {
+		return p, nil
+	}
+
+	resolved, err := c.resolveCase(ctx, u, strings.TrimPrefix(p, u.Handler.Prefix))
+	if err != nil {
+		return "", err
+	}
+
+	resolved = strings.TrimSuffix(u.Handler.Prefix, "/") + resolved
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(resolved, "/") {
+		resolved += "/"
+	}
+	return resolved, nil
+}
+
+// trimResourceSlash returns the path of an URL, under the prefix of the
+// user, without the trailing slash if it names a resource rather than a
+// collection: an existing file, or the file a PUT request creates. The
+// filesystem ignores the trailing slash, but the rules, the MIME types
+// and the hidden patterns would see another name.
+func (c *Config) trimResourceSlash(ctx context.Context, u *User, method, p string) string {
+	if !strings.HasSuffix(p, "/") || !strings.HasPrefix(p, u.Handler.Prefix) {
+		return p
+	}
+
+	trimmed := strings.TrimRight(p, "/")
+	if trimmed+"/" == u.Handler.Prefix || trimmed == "" {
+		return p
+	}
+
+	if method != "PUT" {
+		info, err := u.Handler.FileSystem.Stat(ctx, strings.TrimPrefix(trimmed, u.Handler.Prefix))
+		if err != nil || info.IsDir() {
+			return p
+		}
+	}
+	return trimmed
+}
+
+// resolveDestinationCase rewrites the Destination header of a COPY or MOVE
+// request with the case of the existing files. A destination resolving to
+// the source, the resolved path of the request, is a rename changing the
+// case: only its directory is resolved.
+func (c *Config) resolveDestinationCase(ctx context.Context, u *User, header, source string) (string, error) {
+	dst, err := url.Parse(header)
+	if err != nil || dst.Path == "" {
+		return header, nil
+	}
+
+	resolved, err := c.resolveURLCase(ctx, u, dst.Path)
+	if err != nil {
+		return "", err
+	}
+
+	if path.Clean(resolved) == path.Clean(source) {
+		resolved = path.Join(path.Dir(path.Clean(source)), path.Base(dst.Path))
+	}
+
+	dst.Path = resolved
+	dst.RawPath = ""
+	return dst.String(), nil
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// pathLocks serializes the uploads to the same file, so that checking the
+// preconditions of a PUT request and writing the file happen atomically.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the given path and returns the function unlocking it.
+func (l *pathLocks) lock(name string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*pathLock{}
+	}
+	pl, ok := l.locks[name]
+	if !ok {
+		pl = &pathLock{}
+		l.locks[name] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.Lock()
+	return func() {
+		pl.Unlock()
+
+		l.mu.Lock()
+		pl.refs--
+		if pl.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// putPreconditions checks the If-Match and If-None-Match headers of a PUT
+// request against the current file. It returns whether the file exists,
+// and the status to answer with if a precondition failed, or 0.
+func putPreconditions(ctx context.Context, fs webdav.FileSystem, name string, header http.Header) (bool, int) {
+	etag := ""
+	info, err := fs.Stat(ctx, name)
+	if err == nil {
+		etag, err = fileETag(ctx, info)
+		if err != nil {
+			return true, http.StatusInternalServerError
+		}
+	} else if !os.IsNotExist(err) {
+		return false, 0
+	}
+	existed := info != nil
+
+	if match := header.Get("If-Match"); match != "" && !matchETag(match, etag) {
+		return existed, http.StatusPreconditionFailed
+	}
+
+	if noneMatch := header.Get("If-None-Match"); noneMatch != "" && matchETag(noneMatch, etag) {
+		return existed, http.StatusPreconditionFailed
+	}
+
+	return existed, 0
+}
+
+// fileETag returns the ETag of a file, as the WebDAV handler reports it.
+func fileETag(ctx context.Context, info os.FileInfo) (string, error) {
+	if e, ok := info.(webdav.ETager); ok {
+		return e.ETag(ctx)
+	}
+	return fmt.Sprintf(`"%x%x"`, info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// matchETag checks if the list of ETags of an If-Match or If-None-Match
+// header matches etag, which is empty if the file doesn't exist. Weak
+// ETags are compared as strong ones, since the files are compared as whole.
+func matchETag(list, etag string) bool {
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// overwriteWriter answers 204 No Content instead of 201 Created when a PUT
+// request replaced an existing file, as the WebDAV handler always answers
+// 201 Created.
+type overwriteWriter struct {
+	http.ResponseWriter
+}
+
+func (w overwriteWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusCreated {
+		statusCode = http.StatusNoContent
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestPutPreconditions(t *testing.T) {
+	tests := []struct {
+		name        string
+		exists      bool
+		ifMatch     string
+		ifNoneMatch string
+		status      int
+	}{
+		{"create", false, "", "", http.StatusCreated},
+		{"overwrite", true, "", "", http.StatusNoContent},
+		{"create only", false, "", "*", http.StatusCreated},
+		{"create only over a file", true, "", "*", http.StatusPreconditionFailed},
+		{"if match any", true, "*", "", http.StatusNoContent},
+		{"if match any without file", false, "*", "", http.StatusPreconditionFailed},
+		{"if match current", true, "current", "", http.StatusNoContent},
+		{"if match current in a list", true, `"other", current`, "", http.StatusNoContent},
+		{"if match stale", true, `"stale"`, "", http.StatusPreconditionFailed},
+		{"if match without file", false, `"stale"`, "", http.StatusPreconditionFailed},
+		{"if none match current", true, "", "current", http.StatusPreconditionFailed},
+		{"if none match stale", true, "", `"stale"`, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		c, dir := newTestConfig(t)
+		etag := ""
+		if tt.exists {
+			writeTestFile(t, dir, "file.txt", "old")
+			etag = serve(c, "HEAD", "/file.txt", nil).Header().Get("ETag")
+		}
+
+		headers := []string{}
+		if tt.ifMatch != "" {
+			headers = append(headers, "If-Match", strings.Replace(tt.ifMatch, "current", etag, 1))
+		}
+		if tt.ifNoneMatch != "" {
+			headers = append(headers, "If-None-Match", strings.Replace(tt.ifNoneMatch, "current", etag, 1))
+		}
+
+		w := serve(c, "PUT", "/file.txt", strings.NewReader("new"), headers...)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+
+		want := "new"
+		if tt.status == http.StatusPreconditionFailed {
+			want = map[bool]string{true: "old", false: ""}[tt.exists]
+		}
+		if got := readTestFile(t, dir, "file.txt"); got != want {
+			t.Errorf("%s: got %q in the file, want %q", tt.name, got, want)
+		}
+	}
+}
+
+// slowStatFS waits after finding the state of a file, so that concurrent
+// uploads act on the same state unless they are serialized.
+type slowStatFS struct {
+	webdav.FileSystem
+}
+
+func (fs slowStatFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.FileSystem.Stat(ctx, name)
+	time.Sleep(5 * time.Millisecond)
+	return info, err
+}
+
+// concurrentPuts uploads different contents to the same file at once, with
+// the given headers, and returns the statuses and the contents.
+func concurrentPuts(c *Config, n int, headers ...string) ([]int, []string) {
+	c.User.Handler.FileSystem = WebDavDir{FileSystem: slowStatFS{webdav.Dir(c.User.Scope)}}
+
+	statuses := make([]int, n)
+	contents := make([]string, n)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		contents[i] = fmt.Sprintf("client %d", i)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			statuses[i] = serve(c, "PUT", "/file.txt", strings.NewReader(contents[i]), headers...).Code
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+	return statuses, contents
+}
+
+func TestPutCreateOnlyRace(t *testing.T) {
+	c, dir := newTestConfig(t)
+
+	statuses, contents := concurrentPuts(c, 10, "If-None-Match", "*")
+
+	winner := -1
+	for i, status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			if winner != -1 {
+				t.Fatalf("clients %d and %d both created the file", winner, i)
+			}
+			winner = i
+		case http.StatusPreconditionFailed:
+		default:
+			t.Errorf("client %d: got status %d", i, status)
+		}
+	}
+
+	if winner == -1 {
+		t.Fatal("no client created the file")
+	}
+	if got := readTestFile(t, dir, "file.txt"); got != contents[winner] {
+		t.Errorf("got %q in the file, want %q", got, contents[winner])
+	}
+}
+
+func TestPutLostUpdateRace(t *testing.T) {
+	c, dir := newTestConfig(t)
+	writeTestFile(t, dir, "file.txt", "old")
+	etag := serve(c, "HEAD", "/file.txt", nil).Header().Get("ETag")
+
+	// Every client read the same version and updates it: only the first
+	// one may, the others must read it again.
+	statuses, contents := concurrentPuts(c, 10, "If-Match", etag)
+
+	winner := -1
+	for i, status := range statuses {
+		switch status {
+		case http.StatusNoContent:
+			if winner != -1 {
+				t.Fatalf("clients %d and %d both updated the file", winner, i)
+			}
+			winner = i
+		case http.StatusPreconditionFailed:
+		default:
+			t.Errorf("client %d: got status %d", i, status)
+		}
+	}
+
+	if winner == -1 {
+		t.Fatal("no client updated the file")
+	}
+	if got := readTestFile(t, dir, "file.txt"); got != contents[winner] {
+		t.Errorf("got %q in the file, want %q", got, contents[winner])
+	}
+}
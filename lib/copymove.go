@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// prepareCopyMove checks and normalizes the Destination and Overwrite
+// headers of a COPY or MOVE request before it reaches the WebDAV handler:
+//
+//   - the Destination may be an absolute URL, whose host must be the host
+//     of the request, default ports aside, and is replaced by its path;
+//   - the Overwrite header defaults to "T", for MOVE as well as for COPY,
+//     as RFC 4918 section 10.6 says;
+//   - the user must be allowed to modify the destination;
+//   - the source must exist, and the parent of the destination must be an
+//     existing collection, as RFC 4918 sections 9.8.5 and 9.9.4 say;
+//   - a resource can't be copied or moved onto itself, nor into itself,
+//     nor over one of its ancestors, which would delete it first.
+//
+// The WebDAV handler checks none of the latter, and would delete the
+// destination before failing to move a missing source.
+//
+// It returns the status to answer with if the request must be refused, or 0.
+func (c *Config) prepareCopyMove(r *http.Request, u *User) int {
+	dst, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || dst.Path == "" {
+		return http.StatusBadRequest
+	}
+
+	if dst.Host != "" && !sameHost(dst.Host, r.Host, dst.Scheme) {
+		return http.StatusBadGateway
+	}
+
+	// Destinations out of the prefix are in another URL namespace.
+	dst.Path = c.normalizePath(path.Clean("/" + dst.Path))
+	if !strings.HasPrefix(dst.Path, u.Handler.Prefix) && dst.Path+"/" != u.Handler.Prefix {
+		return http.StatusBadGateway
+	}
+	r.Header.Set("Destination", (&url.URL{Path: dst.Path}).EscapedPath())
+
+	switch overwrite := strings.ToUpper(strings.TrimSpace(r.Header.Get("Overwrite"))); overwrite {
+	case "", "T":
+		r.Header.Set("Overwrite", "T")
+	case "F":
+		r.Header.Set("Overwrite", "F")
+	default:
+		return http.StatusBadRequest
+	}
+
+	if !u.Allowed(dst.Path, false) {
+		return http.StatusForbidden
+	}
+
+	fs := u.Handler.FileSystem
+	src := path.Clean("/" + strings.TrimPrefix(r.URL.Path, u.Handler.Prefix))
+	dstName := path.Clean("/" + strings.TrimPrefix(dst.Path, u.Handler.Prefix))
+
+	info, err := fs.Stat(r.Context(), src)
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	} else if err != nil {
+		return http.StatusForbidden
+	}
+
+	switch {
+	case src == dstName:
+		return http.StatusForbidden
+	case info.IsDir() && isAncestor(src, dstName) && (r.Method == "MOVE" || r.Header.Get("Depth") != "0"):
+		return http.StatusForbidden
+	case isAncestor(dstName, src) && r.Header.Get("Overwrite") == "T":
+		return http.StatusForbidden
+	}
+
+	if parent, err := fs.Stat(r.Context(), path.Dir(dstName)); err != nil || !parent.IsDir() {
+		return http.StatusConflict
+	}
+
+	return 0
+}
+
+// isAncestor checks if the cleaned path dir contains the cleaned path name.
+func isAncestor(dir, name string) bool {
+	return dir == "/" || strings.HasPrefix(name, dir+"/")
+}
+
+// sameHost checks if two hosts are the same, considering that a missing
+// port is the default port of the scheme.
+func sameHost(a, b, scheme string) bool {
+	return strings.EqualFold(withPort(a, scheme), withPort(b, scheme))
+}
+
+func withPort(host, scheme string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+
+	port := "80"
+	if scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
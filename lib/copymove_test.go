@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// readTestFile returns the content of a file of the directory served by a
+// test configuration, or "" if it doesn't exist.
+func readTestFile(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCopyMoveHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		destination string
+		overwrite   string
+		exists      bool
+		status      int
+		dstName     string
+	}{
+		{"move path", "MOVE", "/dst.txt", "", false, http.StatusCreated, "dst.txt"},
+		{"copy path", "COPY", "/dst.txt", "", false, http.StatusCreated, "dst.txt"},
+		{"absolute URL", "MOVE", "http://example.com/dst.txt", "", false, http.StatusCreated, "dst.txt"},
+		{"absolute URL with default port", "COPY", "http://example.com:80/dst.txt", "", false, http.StatusCreated, "dst.txt"},
+		{"absolute URL of another host", "MOVE", "http://other.com/dst.txt", "", false, http.StatusBadGateway, ""},
+		{"percent-encoding", "MOVE", "/new%20name%3F.txt", "", false, http.StatusCreated, "new name?.txt"},
+		{"percent-encoded URL", "COPY", "http://example.com/new%20name.txt", "", false, http.StatusCreated, "new name.txt"},
+		{"trailing slash", "MOVE", "/dst.txt/", "", false, http.StatusCreated, "dst.txt"},
+		{"dot segments", "COPY", "/dir/../dst.txt", "", false, http.StatusCreated, "dst.txt"},
+		{"overwrite T", "MOVE", "/dst.txt", "T", true, http.StatusNoContent, "dst.txt"},
+		{"overwrite by default", "MOVE", "/dst.txt", "", true, http.StatusNoContent, "dst.txt"},
+		{"copy overwrite by default", "COPY", "/dst.txt", "", true, http.StatusNoContent, "dst.txt"},
+		{"overwrite F", "MOVE", "/dst.txt", "F", true, http.StatusPreconditionFailed, ""},
+		{"copy overwrite F", "COPY", "/dst.txt", "F", true, http.StatusPreconditionFailed, ""},
+		{"overwrite f", "COPY", "/dst.txt", "f", true, http.StatusPreconditionFailed, ""},
+		{"overwrite F without target", "MOVE", "/dst.txt", "F", false, http.StatusCreated, "dst.txt"},
+		{"invalid overwrite", "MOVE", "/dst.txt", "yes", true, http.StatusBadRequest, ""},
+		{"missing destination", "MOVE", "", "", false, http.StatusBadRequest, ""},
+		{"forbidden destination", "COPY", "/private/dst.txt", "", false, http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		c, dir := newTestConfig(t)
+		c.User.Rules = []*Rule{{Regex: true, Regexp: regexp.MustCompile(`^/private/`)}}
+		writeTestFile(t, dir, "src.txt", "source")
+		if err := os.Mkdir(filepath.Join(dir, "private"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if tt.exists {
+			writeTestFile(t, dir, "dst.txt", "target")
+		}
+
+		w := serve(c, tt.method, "http://example.com/src.txt", nil, "Destination", tt.destination, "Overwrite", tt.overwrite)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+
+		src := readTestFile(t, dir, "src.txt")
+		switch {
+		case tt.dstName != "":
+			if got := readTestFile(t, dir, tt.dstName); got != "source" {
+				t.Errorf("%s: got %q in %s, want the source", tt.name, got, tt.dstName)
+			}
+			if want := map[string]string{"COPY": "source", "MOVE": ""}[tt.method]; src != want {
+				t.Errorf("%s: got %q in the source, want %q", tt.name, src, want)
+			}
+		case src != "source":
+			t.Errorf("%s: the source was modified", tt.name)
+		case tt.exists && readTestFile(t, dir, "dst.txt") != "target":
+			t.Errorf("%s: the target was modified", tt.name)
+		}
+	}
+}
+
+func TestCopyMoveValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		src         string
+		destination string
+		headers     []string
+		status      int
+		exist       []string
+		missing     []string
+	}{
+		{
+			name: "move across directories", method: "MOVE", src: "/a/file.txt", destination: "/b/moved.txt",
+			status: http.StatusCreated, exist: []string{"b/moved.txt"}, missing: []string{"a/file.txt"},
+		},
+		{
+			name: "move over a file", method: "MOVE", src: "/a/file.txt", destination: "/b/file.txt",
+			status: http.StatusNoContent, missing: []string{"a/file.txt"},
+		},
+		{
+			name: "move over a file with Overwrite F", method: "MOVE", src: "/a/file.txt", destination: "/b/file.txt",
+			headers: []string{"Overwrite", "F"}, status: http.StatusPreconditionFailed, exist: []string{"a/file.txt", "b/file.txt"},
+		},
+		{
+			name: "move a directory over a directory", method: "MOVE", src: "/a", destination: "/b",
+			status: http.StatusNoContent, exist: []string{"b/sub/deep.txt"}, missing: []string{"a", "b/other.txt"},
+		},
+		{
+			name: "copy a directory over a directory with Overwrite F", method: "COPY", src: "/a", destination: "/b",
+			headers: []string{"Overwrite", "F"}, status: http.StatusPreconditionFailed, exist: []string{"a/sub/deep.txt", "b/other.txt"}, missing: []string{"b/sub"},
+		},
+		{
+			name: "missing source", method: "MOVE", src: "/missing.txt", destination: "/b/file.txt",
+			status: http.StatusNotFound, exist: []string{"b/file.txt"},
+		},
+		{
+			name: "missing destination parent", method: "MOVE", src: "/a/file.txt", destination: "/missing/file.txt",
+			status: http.StatusConflict, exist: []string{"a/file.txt"},
+		},
+		{
+			name: "destination parent is a file", method: "COPY", src: "/a/file.txt", destination: "/b/file.txt/file.txt",
+			status: http.StatusConflict, exist: []string{"a/file.txt"},
+		},
+		{
+			name: "onto itself", method: "MOVE", src: "/a/file.txt", destination: "/a/file.txt",
+			status: http.StatusForbidden, exist: []string{"a/file.txt"},
+		},
+		{
+			name: "move into itself", method: "MOVE", src: "/a", destination: "/a/sub/a",
+			status: http.StatusForbidden, exist: []string{"a/sub/deep.txt"}, missing: []string{"a/sub/a"},
+		},
+		{
+			name: "copy into itself", method: "COPY", src: "/a", destination: "/a/sub/a",
+			status: http.StatusForbidden, missing: []string{"a/sub/a"},
+		},
+		{
+			name: "copy into itself with Depth 0", method: "COPY", src: "/a", destination: "/a/sub/a",
+			headers: []string{"Depth", "0"}, status: http.StatusCreated, exist: []string{"a/sub/a"}, missing: []string{"a/sub/a/file.txt"},
+		},
+		{
+			name: "move over an ancestor", method: "MOVE", src: "/a/sub", destination: "/a",
+			status: http.StatusForbidden, exist: []string{"a/sub/deep.txt", "a/file.txt"},
+		},
+		{
+			name: "copy a directory", method: "COPY", src: "/a", destination: "/c",
+			status: http.StatusCreated, exist: []string{"c/file.txt", "c/sub/deep.txt", "a/sub/deep.txt"},
+		},
+		{
+			name: "copy a directory with Depth 0", method: "COPY", src: "/a", destination: "/c",
+			headers: []string{"Depth", "0"}, status: http.StatusCreated, exist: []string{"c"}, missing: []string{"c/file.txt", "c/sub"},
+		},
+		{
+			name: "copy a directory with Depth 1", method: "COPY", src: "/a", destination: "/c",
+			headers: []string{"Depth", "1"}, status: http.StatusBadRequest, missing: []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		c, dir := newTestConfig(t)
+		for _, name := range []string{"a", "a/sub", "b", "b/sub"} {
+			if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeTestFile(t, dir, "a/file.txt", "a")
+		writeTestFile(t, dir, "a/sub/deep.txt", "deep")
+		writeTestFile(t, dir, "b/file.txt", "b")
+		writeTestFile(t, dir, "b/other.txt", "other")
+		if err := os.Remove(filepath.Join(dir, "b/sub")); err != nil {
+			t.Fatal(err)
+		}
+
+		w := serve(c, tt.method, tt.src, nil, append([]string{"Destination", tt.destination}, tt.headers...)...)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+
+		for _, name := range tt.exist {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("%s: %s is missing", tt.name, name)
+			}
+		}
+		for _, name := range tt.missing {
+			if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+				t.Errorf("%s: %s exists", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestCopyMoveOutOfPrefix(t *testing.T) {
+	c, dir := newTestConfig(t)
+	c.User.Handler.Prefix = "/dav"
+	writeTestFile(t, dir, "file.txt", "content")
+
+	for _, destination := range []string{"/other/file.txt", "/dav/../file.txt", "http://example.com/file.txt"} {
+		w := serve(c, "MOVE", "/dav/file.txt", nil, "Destination", destination)
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("%s: got status %d, want %d", destination, w.Code, http.StatusBadGateway)
+		}
+	}
+
+	if w := serve(c, "MOVE", "/dav/file.txt", nil, "Destination", "/dav/moved.txt"); w.Code != http.StatusCreated {
+		t.Errorf("/dav/moved.txt: got status %d, want %d", w.Code, http.StatusCreated)
+	}
+	if readTestFile(t, dir, "moved.txt") != "content" {
+		t.Errorf("moved.txt is missing")
+	}
+}
@@ -0,0 +1,491 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// Encrypted files are stored as a header followed by a sequence of chunks.
+// Each chunk holds up to cryptChunkSize bytes of plain text sealed with
+// AES-GCM, so that any byte range can be read by decrypting only the chunks
+// that contain it.
+//
+// Header: magic (4) | file id (8) | key check tag (16)
+// Chunk:  cipher text (<= cryptChunkSize) | tag (16)
+//
+// Every file ends with a final chunk, which is empty only if the file is,
+// and which is authenticated as such, so that a file cut at a chunk
+// boundary can't be read as a shorter one.
+const (
+	cryptChunkSize  = 64 * 1024
+	cryptIDSize     = 8
+	cryptHeaderSize = len(cryptMagic) + cryptIDSize + 16
+)
+
+const cryptMagic = "WDE2"
+
+// CryptKeySize is the size of the keys of CryptFS.
+const CryptKeySize = 32
+
+var (
+	// ErrWrongKey is returned when a file cannot be decrypted with the configured key.
+	ErrWrongKey = errors.New("cannot decrypt file: wrong encryption key or corrupted data")
+	// ErrNotEncrypted is returned when a file is not in the encrypted format.
+	ErrNotEncrypted = errors.New("file is not encrypted")
+	// ErrRandomWrite is returned when opening an encrypted file for writing
+	// without truncating it.
+	ErrRandomWrite = errors.New("encrypted files can only be written from the start")
+)
+
+// CryptFS wraps a webdav.FileSystem and transparently encrypts the contents,
+// and optionally the names, of the files stored in it.
+type CryptFS struct {
+	webdav.FileSystem
+	EncryptNames bool
+
+	content cipher.AEAD
+	names   cipher.AEAD
+	nameMAC []byte
+}
+
+// NewCryptFS creates a new CryptFS. The key must be CryptKeySize random
+// bytes, rather than a password, as it isn't stretched: the actual
+// encryption keys are derived from it.
+func NewCryptFS(fs webdav.FileSystem, key []byte, encryptNames bool) (*CryptFS, error) {
+	if len(key) != CryptKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, not %d", CryptKeySize, len(key))
+	}
+
+	content, err := newGCM(deriveKey(key, "content"))
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := newGCM(deriveKey(key, "names"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &CryptFS{
+		FileSystem:   fs,
+		EncryptNames: encryptNames,
+		content:      content,
+		names:        names,
+		nameMAC:      deriveKey(key, "names-iv"),
+	}, nil
+}
+
+func deriveKey(master []byte, label string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptName encrypts a single path element. The nonce is derived from the
+// name itself so that the same name always maps to the same cipher text.
+func (fs *CryptFS) encryptName(name string) string {
+	mac := hmac.New(sha256.New, fs.nameMAC)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:fs.names.NonceSize()]
+
+	sealed := fs.names.Seal(nonce, nonce, []byte(name), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func (fs *CryptFS) decryptName(name string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil || len(sealed) < fs.names.NonceSize() {
+		return "", ErrWrongKey
+	}
+
+	nonce := sealed[:fs.names.NonceSize()]
+	plain, err := fs.names.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return "", ErrWrongKey
+	}
+	return string(plain), nil
+}
+
+// encryptPath encrypts every element of a slash separated path.
+func (fs *CryptFS) encryptPath(name string) string {
+	if !fs.EncryptNames {
+		return name
+	}
+
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if part != "" && part != "." && part != ".." {
+			parts[i] = fs.encryptName(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Mkdir creates a directory.
+func (fs *CryptFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.FileSystem.Mkdir(ctx, fs.encryptPath(name), perm)
+}
+
+// RemoveAll removes a file or directory.
+func (fs *CryptFS) RemoveAll(ctx context.Context, name string) error {
+	return fs.FileSystem.RemoveAll(ctx, fs.encryptPath(name))
+}
+
+// Rename renames a file or directory.
+func (fs *CryptFS) Rename(ctx context.Context, oldName, newName string) error {
+	return fs.FileSystem.Rename(ctx, fs.encryptPath(oldName), fs.encryptPath(newName))
+}
+
+// Stat returns the file info with the plain text name and size.
+func (fs *CryptFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.FileSystem.Stat(ctx, fs.encryptPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return fs.plainInfo(info, path.Base(name)), nil
+}
+
+func (fs *CryptFS) plainInfo(info os.FileInfo, name string) os.FileInfo {
+	size := info.Size()
+	if !info.IsDir() {
+		size = plainSize(size)
+	}
+	return cryptFileInfo{FileInfo: info, name: name, size: size}
+}
+
+// validSize checks that an encrypted file of this size holds whole chunks,
+// the last of them possibly empty.
+func validSize(size int64) bool {
+	size -= int64(cryptHeaderSize)
+	rem := size % (cryptChunkSize + 16)
+	return size >= 16 && (rem == 0 || rem >= 16)
+}
+
+// plainSize computes the plain text size from the size of an encrypted file.
+func plainSize(size int64) int64 {
+	size -= int64(cryptHeaderSize)
+	if size <= 0 {
+		return 0
+	}
+
+	full := size / (cryptChunkSize + 16)
+	rem := size % (cryptChunkSize + 16)
+	if rem > 16 {
+		rem -= 16
+	} else {
+		rem = 0
+	}
+	return full*cryptChunkSize + rem
+}
+
+// OpenFile opens a file. Files opened for reading can be read and seeked
+// freely, while files opened for writing must be truncated or new, and are
+// written sequentially.
+func (fs *CryptFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := fs.FileSystem.OpenFile(ctx, fs.encryptPath(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &cryptFile{File: file, fs: fs, name: path.Base(name)}
+
+	if info.IsDir() {
+		return f, nil
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		if err := f.readHeader(info.Size()); err != nil {
+			file.Close()
+			zap.L().Error("cannot open encrypted file", zap.String("path", name), zap.Error(err))
+			return nil, err
+		}
+		return f, nil
+	}
+
+	if flag&os.O_TRUNC == 0 && info.Size() != 0 {
+		file.Close()
+		return nil, ErrRandomWrite
+	}
+
+	if err := f.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+type cryptFileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (i cryptFileInfo) Name() string { return i.name }
+func (i cryptFileInfo) Size() int64  { return i.size }
+
+type cryptFile struct {
+	webdav.File
+	fs   *CryptFS
+	name string
+
+	id     []byte
+	size   int64
+	offset int64
+	write  bool
+
+	// Read side: the last decrypted chunk.
+	chunk int64
+	plain []byte
+
+	// Write side: plain text waiting to fill a chunk.
+	pending []byte
+	counter uint32
+}
+
+func (f *cryptFile) nonce(counter uint32) []byte {
+	nonce := make([]byte, f.fs.content.NonceSize())
+	copy(nonce, f.id)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], counter)
+	return nonce
+}
+
+// chunkData returns the additional data a chunk is sealed with, which tells
+// the final chunk apart.
+func chunkData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// keyCheck returns the tag stored in the header, used to detect a wrong key
+// before reading any data.
+func (f *cryptFile) keyCheck() []byte {
+	return f.fs.content.Seal(nil, f.nonce(^uint32(0)), nil, []byte(cryptMagic))
+}
+
+func (f *cryptFile) readHeader(size int64) error {
+	header := make([]byte, cryptHeaderSize)
+	if _, err := io.ReadFull(f.File, header); err != nil {
+		return ErrNotEncrypted
+	}
+
+	if string(header[:len(cryptMagic)]) != cryptMagic {
+		return ErrNotEncrypted
+	}
+
+	f.id = header[len(cryptMagic) : len(cryptMagic)+cryptIDSize]
+	if !hmac.Equal(header[len(cryptMagic)+cryptIDSize:], f.keyCheck()) {
+		return ErrWrongKey
+	}
+
+	if !validSize(size) {
+		return ErrWrongKey
+	}
+
+	f.size = plainSize(size)
+	f.chunk = -1
+
+	// Empty files are never read, but their final chunk must be there.
+	if f.size == 0 {
+		return f.loadChunk(0)
+	}
+	return nil
+}
+
+func (f *cryptFile) writeHeader() error {
+	f.id = make([]byte, cryptIDSize)
+	if _, err := rand.Read(f.id); err != nil {
+		return err
+	}
+
+	header := bytes.NewBufferString(cryptMagic)
+	header.Write(f.id)
+	header.Write(f.keyCheck())
+
+	if _, err := f.File.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	f.write = true
+	return nil
+}
+
+func (f *cryptFile) Read(p []byte) (int, error) {
+	if f.write {
+		return 0, os.ErrPermission
+	}
+
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	chunk := f.offset / cryptChunkSize
+	if chunk != f.chunk {
+		if err := f.loadChunk(chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, f.plain[f.offset%cryptChunkSize:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *cryptFile) loadChunk(chunk int64) error {
+	offset := int64(cryptHeaderSize) + chunk*(cryptChunkSize+16)
+	if _, err := f.File.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	size := f.size - chunk*cryptChunkSize
+	if size > cryptChunkSize {
+		size = cryptChunkSize
+	}
+
+	sealed := make([]byte, size+16)
+	if _, err := io.ReadFull(f.File, sealed); err != nil {
+		return err
+	}
+
+	final := chunk == (f.size-1)/cryptChunkSize || f.size == 0
+	plain, err := f.fs.content.Open(sealed[:0], f.nonce(uint32(chunk)), sealed, chunkData(final))
+	if err != nil {
+		return ErrWrongKey
+	}
+
+	f.chunk = chunk
+	f.plain = plain
+	return nil
+}
+
+func (f *cryptFile) Seek(offset int64, whence int) (int64, error) {
+	if f.write {
+		if offset != 0 || whence == io.SeekStart && f.offset != 0 {
+			return 0, ErrRandomWrite
+		}
+		return f.offset, nil
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, os.ErrInvalid
+	}
+
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *cryptFile) Write(p []byte) (int, error) {
+	if !f.write {
+		return 0, os.ErrPermission
+	}
+
+	// A full chunk is kept until more data comes, as it could be the
+	// final one.
+	f.pending = append(f.pending, p...)
+	for len(f.pending) > cryptChunkSize {
+		if err := f.flush(cryptChunkSize, false); err != nil {
+			return 0, err
+		}
+	}
+
+	f.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (f *cryptFile) flush(n int, final bool) error {
+	sealed := f.fs.content.Seal(nil, f.nonce(f.counter), f.pending[:n], chunkData(final))
+	if _, err := f.File.Write(sealed); err != nil {
+		return err
+	}
+
+	f.counter++
+	f.pending = f.pending[n:]
+	return nil
+}
+
+func (f *cryptFile) Close() error {
+	if f.write {
+		if err := f.flush(len(f.pending), true); err != nil {
+			f.File.Close()
+			return err
+		}
+	}
+	return f.File.Close()
+}
+
+func (f *cryptFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return cryptFileInfo{FileInfo: info, name: f.name, size: info.Size()}, nil
+	}
+
+	size := f.size
+	if f.write {
+		size = f.offset
+	}
+	return cryptFileInfo{FileInfo: info, name: f.name, size: size}, nil
+}
+
+func (f *cryptFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+
+	plain := make([]os.FileInfo, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if f.fs.EncryptNames {
+			var nameErr error
+			if name, nameErr = f.fs.decryptName(name); nameErr != nil {
+				// Skip entries that were not created through this filesystem.
+				continue
+			}
+		}
+		plain = append(plain, f.fs.plainInfo(info, name))
+	}
+
+	return plain, err
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func newTestCryptFS(t *testing.T, dir string, key byte) *CryptFS {
+	t.Helper()
+
+	fs, err := NewCryptFS(webdav.Dir(dir), bytes.Repeat([]byte{key}, CryptKeySize), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func writeCryptFile(t *testing.T, fs *CryptFS, name string, content []byte) {
+	t.Helper()
+
+	f, err := fs.OpenFile(context.Background(), name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readCryptFile(fs *CryptFS, name string) ([]byte, error) {
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func TestCryptFS(t *testing.T) {
+	fs := newTestCryptFS(t, t.TempDir(), 1)
+
+	for _, size := range []int{0, 1, cryptChunkSize - 1, cryptChunkSize, cryptChunkSize + 1, 3 * cryptChunkSize} {
+		content := mediaContent(size)
+		writeCryptFile(t, fs, "/file", content)
+
+		got, err := readCryptFile(fs, "/file")
+		if err != nil {
+			t.Errorf("%d bytes: %s", size, err)
+		} else if !bytes.Equal(got, content) {
+			t.Errorf("%d bytes: got %d bytes back", size, len(got))
+		}
+
+		if info, err := fs.Stat(context.Background(), "/file"); err != nil {
+			t.Errorf("%d bytes: %s", size, err)
+		} else if info.Size() != int64(size) {
+			t.Errorf("%d bytes: got size %d", size, info.Size())
+		}
+	}
+}
+
+func TestCryptFSKey(t *testing.T) {
+	if _, err := NewCryptFS(webdav.Dir(t.TempDir()), []byte("a password"), false); err == nil {
+		t.Errorf("got no error for a key of the wrong size")
+	}
+
+	dir := t.TempDir()
+	writeCryptFile(t, newTestCryptFS(t, dir, 1), "/file", []byte("content"))
+	if _, err := readCryptFile(newTestCryptFS(t, dir, 2), "/file"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("got %v, want %v", err, ErrWrongKey)
+	}
+}
+
+func TestCryptFSTruncated(t *testing.T) {
+	dir := t.TempDir()
+	fs := newTestCryptFS(t, dir, 1)
+	name := filepath.Join(dir, "file")
+
+	// Cut at the boundaries of the chunks, and of the header.
+	sealed := int64(cryptChunkSize + 16)
+	for _, size := range []int64{0, 16, sealed, 2 * sealed} {
+		writeCryptFile(t, fs, "/file", mediaContent(2*cryptChunkSize+10))
+		if err := os.Truncate(name, int64(cryptHeaderSize)+size); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := readCryptFile(fs, "/file"); !errors.Is(err, ErrWrongKey) {
+			t.Errorf("cut after %d bytes: got %v, want %v", size, err, ErrWrongKey)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DebugRedactedHeaders are the request headers whose value isn't logged by
+// DebugRequests.
+var DebugRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// DebugRequests wraps a handler so that, while the debug level is enabled,
+// the headers of every request are logged when it starts, and up to
+// maxBody bytes of its body once it is served. The body is captured as the
+// handler reads it, and only logged for textual content.
+func DebugRequests(h http.Handler, maxBody int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := RequestLogger(r)
+		if !log.Core().Enabled(zapcore.DebugLevel) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		headers := make(map[string]string, len(r.Header))
+		for name, values := range r.Header {
+			headers[name] = strings.Join(values, ", ")
+		}
+		for _, name := range DebugRedactedHeaders {
+			if _, ok := headers[name]; ok {
+				headers[name] = "[redacted]"
+			}
+		}
+
+		log.Debug("request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.RequestURI()),
+			zap.String("proto", r.Proto),
+			zap.String("remote_address", r.RemoteAddr),
+			zap.Any("headers", headers))
+
+		if r.Body == nil || r.Body == http.NoBody || maxBody <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		body := &teeBody{ReadCloser: r.Body, max: maxBody}
+		r.Body = body
+		h.ServeHTTP(w, r)
+
+		if body.n == 0 {
+			return
+		}
+		if !isTextual(r.Header.Get("Content-Type"), body.buf) {
+			log.Debug("request body", zap.Int64("size", body.n), zap.String("body", "[binary]"))
+			return
+		}
+		log.Debug("request body",
+			zap.Int64("size", body.n),
+			zap.Bool("truncated", body.n > int64(len(body.buf))),
+			zap.String("body", string(body.buf)))
+	})
+}
+
+// teeBody keeps a copy of the first bytes read from a request body.
+type teeBody struct {
+	io.ReadCloser
+	buf []byte
+	max int
+	n   int64
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if left := b.max - len(b.buf); left > 0 {
+		if n < left {
+			left = n
+		}
+		b.buf = append(b.buf, p[:left]...)
+	}
+	b.n += int64(n)
+	return n, err
+}
+
+// isTextual checks if a body of the given content type can be logged as
+// text. Bodies without type are if they are valid UTF-8.
+func isTextual(contentType string, data []byte) bool {
+	if contentType == "" {
+		return utf8.Valid(data)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/xml",
+		mediaType == "application/json",
+		mediaType == "application/x-www-form-urlencoded":
+		return utf8.Valid(data)
+	}
+	return false
+}
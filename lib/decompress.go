@@ -0,0 +1,65 @@
+package lib
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decompressBody replaces the body of a request sent with the gzip or
+// deflate Content-Encoding by its decompressed content, and removes the
+// header. It returns the status to answer with if the encoding isn't
+// supported, or the body isn't valid.
+func decompressBody(r *http.Request) int {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	var reader io.ReadCloser
+	switch encoding {
+	case "", "identity":
+		return 0
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return http.StatusBadRequest
+		}
+		reader = zr
+	case "deflate":
+		// Deflate is meant to be in the zlib format, but some clients send
+		// raw deflate data.
+		br := bufio.NewReader(r.Body)
+		header, _ := br.Peek(2)
+		if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return http.StatusBadRequest
+			}
+			reader = zr
+		} else {
+			reader = flate.NewReader(br)
+		}
+	default:
+		return http.StatusUnsupportedMediaType
+	}
+
+	r.Body = decompressReader{ReadCloser: reader, body: r.Body}
+	r.Header.Del("Content-Encoding")
+	return 0
+}
+
+// decompressReader closes the request body with the decompressor.
+type decompressReader struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (r decompressReader) Close() error {
+	err := r.ReadCloser.Close()
+	if bodyErr := r.body.Close(); err == nil {
+		err = bodyErr
+	}
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"mime"
 	"os"
 	"path"
+	"strings"
 
 	"golang.org/x/net/webdav"
 )
@@ -12,10 +13,13 @@ import (
 // NoSniffFileInfo wraps any generic FileInfo interface and bypasses mime type sniffing.
 type NoSniffFileInfo struct {
 	os.FileInfo
+	// MimeTypes maps extensions, such as ".md", to the types that override
+	// the default ones.
+	MimeTypes map[string]string
 }
 
 func (w NoSniffFileInfo) ContentType(ctx context.Context) (contentType string, err error) {
-	if mimeType := mime.TypeByExtension(path.Ext(w.FileInfo.Name())); mimeType != "" {
+	if mimeType := typeByExtension(w.MimeTypes, w.FileInfo.Name()); mimeType != "" {
 		// We can figure out the mime from the extension.
 		return mimeType, nil
 	} else {
@@ -24,41 +28,106 @@ func (w NoSniffFileInfo) ContentType(ctx context.Context) (contentType string, e
 	}
 }
 
+// mimeFileInfo wraps a FileInfo to use the custom mime types, and leaves
+// the others to the WebDAV handler, which sniffs them.
+type mimeFileInfo struct {
+	os.FileInfo
+	mimeTypes map[string]string
+}
+
+func (w mimeFileInfo) ContentType(ctx context.Context) (string, error) {
+	if mimeType, ok := w.mimeTypes[strings.ToLower(path.Ext(w.FileInfo.Name()))]; ok {
+		return mimeType, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// typeByExtension returns the mime type of a file name from its extension,
+// looking at the custom types first.
+func typeByExtension(mimeTypes map[string]string, name string) string {
+	ext := path.Ext(name)
+	if mimeType, ok := mimeTypes[strings.ToLower(ext)]; ok {
+		return mimeType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// WebDavDir wraps a directory, or FileSystem if set, and optionally bypasses
+// mime type sniffing. MimeTypes, if set, maps extensions such as ".md" to
+// the types that override the default ones.
 type WebDavDir struct {
 	webdav.Dir
-	NoSniff bool
+	NoSniff   bool
+	MimeTypes map[string]string
+
+	// FileSystem, if set, is served instead of Dir, such as an in-memory
+	// scope.
+	FileSystem webdav.FileSystem
+}
+
+// fs returns the file system served.
+func (d WebDavDir) fs() webdav.FileSystem {
+	if d.FileSystem != nil {
+		return d.FileSystem
+	}
+	return d.Dir
+}
+
+// wrap returns the FileInfo answering the mime type of the file.
+func (d WebDavDir) wrap(info os.FileInfo) os.FileInfo {
+	switch {
+	case d.NoSniff:
+		return NoSniffFileInfo{FileInfo: info, MimeTypes: d.MimeTypes}
+	case len(d.MimeTypes) != 0:
+		return mimeFileInfo{FileInfo: info, mimeTypes: d.MimeTypes}
+	default:
+		return info
+	}
+}
+
+func (d WebDavDir) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return d.fs().Mkdir(ctx, name, perm)
+}
+
+func (d WebDavDir) RemoveAll(ctx context.Context, name string) error {
+	return d.fs().RemoveAll(ctx, name)
+}
+
+func (d WebDavDir) Rename(ctx context.Context, oldName, newName string) error {
+	return d.fs().Rename(ctx, oldName, newName)
 }
 
 func (d WebDavDir) Stat(ctx context.Context, name string) (os.FileInfo, error) {
-	// Skip wrapping if NoSniff is off
-	if !d.NoSniff {
-		return d.Dir.Stat(ctx, name)
+	// Skip wrapping if NoSniff is off and there are no custom types
+	if !d.NoSniff && len(d.MimeTypes) == 0 {
+		return d.fs().Stat(ctx, name)
 	}
 
-	info, err := d.Dir.Stat(ctx, name)
+	info, err := d.fs().Stat(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
-	return NoSniffFileInfo{info}, nil
+	return d.wrap(info), nil
 }
 
 func (d WebDavDir) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
-	// Skip wrapping if NoSniff is off
-	if !d.NoSniff {
-		return d.Dir.OpenFile(ctx, name, flag, perm)
+	// Skip wrapping if NoSniff is off and there are no custom types
+	if !d.NoSniff && len(d.MimeTypes) == 0 {
+		return d.fs().OpenFile(ctx, name, flag, perm)
 	}
 
-	file, err := d.Dir.OpenFile(ctx, name, flag, perm)
+	file, err := d.fs().OpenFile(ctx, name, flag, perm)
 	if err != nil {
 		return nil, err
 	}
 
-	return WebDavFile{File: file}, nil
+	return WebDavFile{File: file, dir: d}, nil
 }
 
 type WebDavFile struct {
 	webdav.File
+	dir WebDavDir
 }
 
 func (f WebDavFile) Stat() (os.FileInfo, error) {
@@ -67,7 +136,7 @@ func (f WebDavFile) Stat() (os.FileInfo, error) {
 		return nil, err
 	}
 
-	return NoSniffFileInfo{info}, nil
+	return f.dir.wrap(info), nil
 }
 
 func (f WebDavFile) Readdir(count int) (fis []os.FileInfo, err error) {
@@ -77,7 +146,7 @@ func (f WebDavFile) Readdir(count int) (fis []os.FileInfo, err error) {
 	}
 
 	for i := range fis {
-		fis[i] = NoSniffFileInfo{fis[i]}
+		fis[i] = f.dir.wrap(fis[i])
 	}
 	return fis, nil
 }
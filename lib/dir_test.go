@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDavDirField(t *testing.T) {
+	// The directory can still be set as before FileSystem was added.
+	c, dir := newTestConfig(t)
+	c.User.Handler.FileSystem = WebDavDir{Dir: webdav.Dir(dir), NoSniff: true}
+	writeTestFile(t, dir, "file.txt", "content")
+
+	steps := []struct {
+		method string
+		target string
+		header []string
+		status int
+	}{
+		{"GET", "/file.txt", nil, http.StatusOK},
+		{"MKCOL", "/sub", nil, http.StatusCreated},
+		{"MOVE", "/file.txt", []string{"Destination", "/sub/moved.txt"}, http.StatusCreated},
+		{"DELETE", "/sub", nil, http.StatusNoContent},
+	}
+	for _, step := range steps {
+		if w := serve(c, step.method, step.target, nil, step.header...); w.Code != step.status {
+			t.Errorf("%s %s: got status %d, want %d", step.method, step.target, w.Code, step.status)
+		}
+	}
+	if readTestFile(t, dir, "sub/moved.txt") != "" {
+		t.Errorf("the directory wasn't removed")
+	}
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// wantsDownload returns whether a GET request must be answered as an
+// attachment: with ForceDownload, or with the download query parameter,
+// such as "?download=1".
+func (c *Config) wantsDownload(r *http.Request) bool {
+	if c.ForceDownload {
+		return true
+	}
+
+	download, err := strconv.ParseBool(r.URL.Query().Get("download"))
+	return err == nil && download
+}
+
+// contentDisposition returns the Content-Disposition header of an attachment
+// named name. The filename parameter is an ASCII fallback, and filename*
+// the UTF-8 name encoded as in RFC 5987.
+func contentDisposition(name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if fallback == name {
+		return fmt.Sprintf(`attachment; filename="%s"`, name)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encodeRFC5987(name))
+}
+
+// encodeRFC5987 percent-encodes the bytes of s that are not attr-char.
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9') || strings.IndexByte("!#$&+-.^_`|~", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Drain gracefully shuts down the server: it stops accepting new
+// connections and waits for the active requests to finish, reporting how
+// many are left every second. Once the timeout is reached, the remaining
+// connections are closed. OnStopping is called before draining, and OnStop
+// once it is over.
+func Drain(server *http.Server, c *Config, timeout time.Duration) error {
+	if c.OnStopping != nil {
+		c.OnStopping()
+	}
+	if c.OnStop != nil {
+		defer c.OnStop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Shutdown(ctx)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err == context.DeadlineExceeded {
+				zap.L().Warn("drain timeout reached, closing remaining connections", zap.Int64("active_requests", c.ActiveRequests()))
+				return server.Close()
+			}
+			return err
+		case <-ticker.C:
+			zap.L().Info("draining", zap.Int64("active_requests", c.ActiveRequests()))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or IP
+// addresses.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", item)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", item, err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// isTrustedProxy checks if the address is in one of the trusted networks.
+func (c *Config) isTrustedProxy(ip net.IP) bool {
+	for _, n := range c.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyForwarded replaces the remote address and the scheme of a request
+// coming from a trusted proxy with the ones of the original client, as
+// reported by the X-Forwarded-For and X-Forwarded-Proto headers. The headers
+// of untrusted peers are ignored, so they can't be spoofed.
+func (c *Config) applyForwarded(r *http.Request) {
+	if len(c.TrustedProxies) == 0 {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+	if peer == nil || !c.isTrustedProxy(peer) {
+		return
+	}
+
+	// The rightmost address that isn't a trusted proxy is the client, since
+	// anything on its left was sent by the client itself.
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+
+		r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		if !c.isTrustedProxy(ip) {
+			break
+		}
+	}
+
+	switch proto := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Forwarded-Proto"))); proto {
+	case "http", "https":
+		r.URL.Scheme = proto
+	}
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// GlobFS wraps a webdav.FileSystem and only exposes the entries of its root
+// whose name matches the glob Pattern, with their contents. The others don't
+// exist, and can't be created. If ReadOnly is set, nothing can be modified,
+// as for the scopes of a single file.
+type GlobFS struct {
+	webdav.FileSystem
+	Pattern  string
+	ReadOnly bool
+}
+
+// visible checks if the first element of name, if any, matches the pattern.
+func (fs GlobFS) visible(name string) bool {
+	first := strings.SplitN(strings.TrimPrefix(path.Clean("/"+name), "/"), "/", 2)[0]
+	if first == "" {
+		return true
+	}
+	ok, _ := path.Match(fs.Pattern, first)
+	return ok
+}
+
+// denied returns the error for a write to name, or nil.
+func (fs GlobFS) denied(name string) error {
+	if fs.ReadOnly || !fs.visible(name) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// Mkdir creates a directory.
+func (fs GlobFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.denied(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+// OpenFile opens a file. The root lists the visible entries only.
+func (fs GlobFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if err := fs.denied(name); err != nil {
+			return nil, err
+		}
+	} else if !fs.visible(name) {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if path.Clean("/"+name) == "/" {
+		return globDir{File: file, fs: fs}, nil
+	}
+	return file, nil
+}
+
+// RemoveAll removes a file or directory.
+func (fs GlobFS) RemoveAll(ctx context.Context, name string) error {
+	if !fs.visible(name) {
+		return os.ErrNotExist
+	}
+	if err := fs.denied(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+// Rename renames a file or directory.
+func (fs GlobFS) Rename(ctx context.Context, oldName, newName string) error {
+	if !fs.visible(oldName) {
+		return os.ErrNotExist
+	}
+	if err := fs.denied(oldName); err != nil {
+		return err
+	}
+	if err := fs.denied(newName); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// Stat returns the info of a file.
+func (fs GlobFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fs.visible(name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+type globDir struct {
+	webdav.File
+	fs GlobFS
+}
+
+// Readdir leaves out the entries that don't match the pattern.
+func (f globDir) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if ok, _ := path.Match(f.fs.Pattern, info.Name()); ok {
+			visible = append(visible, info)
+		}
+	}
+
+	return visible, err
+}
@@ -0,0 +1,78 @@
+package lib
+
+import "net/http"
+
+// ServerHeader wraps a handler so that every response carries the given
+// Server header. An empty value removes the header from the responses.
+func ServerHeader(h http.Handler, value string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&serverHeaderWriter{ResponseWriter: w, value: value}, r)
+	})
+}
+
+// serverHeaderWriter sets the Server header right before the headers are
+// written, overriding whatever the handler set.
+type serverHeaderWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *serverHeaderWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.value == "" {
+			w.Header().Del("Server")
+		} else {
+			w.Header().Set("Server", w.value)
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *serverHeaderWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// StaticHeaders wraps a handler so that every response carries the given
+// headers, such as security headers. The headers the handler set, such as
+// Content-Type or DAV, are kept.
+func StaticHeaders(h http.Handler, headers http.Header) http.Handler {
+	if len(headers) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&staticHeadersWriter{ResponseWriter: w, headers: headers}, r)
+	})
+}
+
+// staticHeadersWriter adds the static headers the handler didn't set right
+// before the headers are written.
+type staticHeadersWriter struct {
+	http.ResponseWriter
+	headers     http.Header
+	wroteHeader bool
+}
+
+func (w *staticHeadersWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		for name, values := range w.headers {
+			if _, ok := h[name]; !ok {
+				h[name] = values
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *staticHeadersWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
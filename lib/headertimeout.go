@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// headerTimeoutResponse is answered to the connections whose request
+// headers timed out.
+const headerTimeoutResponse = "HTTP/1.1 408 Request Timeout\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: 25\r\nConnection: close\r\n\r\nRequest headers timed out"
+
+// HeaderTimeoutListener wraps a net.Listener so that the connections whose
+// request headers time out, because of http.Server.ReadHeaderTimeout, are
+// logged and, if Reply is set, answered with 408 Request Timeout before the
+// server closes them. Reply must not be set when serving TLS, since the
+// response is written in clear. Connections that time out while idle
+// between two requests are closed silently, as before.
+type HeaderTimeoutListener struct {
+	net.Listener
+	Reply bool
+}
+
+// Accept waits for and returns the next connection.
+func (l *HeaderTimeoutListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &headerTimeoutConn{Conn: conn, reply: l.Reply}, nil
+}
+
+// headerTimeoutConn tells a timeout reading request headers, when bytes
+// were received since the last response or before the first one, from the
+// timeout of an idle connection.
+type headerTimeoutConn struct {
+	net.Conn
+	reply bool
+
+	mu       sync.Mutex
+	received int64
+	wrote    bool
+	deadline time.Time
+	timedOut bool
+	aborted  bool
+}
+
+// pastDeadline is after the deadlines set to abort a read at once, such as
+// time.Unix(1, 0), which aren't timeouts of the client.
+var pastDeadline = time.Unix(1<<20, 0)
+
+func (c *headerTimeoutConn) SetDeadline(t time.Time) error {
+	c.setDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *headerTimeoutConn) SetReadDeadline(t time.Time) error {
+	c.setDeadline(t)
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *headerTimeoutConn) setDeadline(t time.Time) {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+}
+
+// abortReads fails the pending and next reads of the connection, so that
+// the server closes it instead of waiting for a stalled client. Unlike a
+// past read deadline, it can't be reset by the server.
+func (c *headerTimeoutConn) abortReads() {
+	c.mu.Lock()
+	c.aborted = true
+	c.mu.Unlock()
+	_ = c.Conn.SetReadDeadline(time.Unix(1, 0))
+}
+
+func (c *headerTimeoutConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	aborted := c.aborted
+	c.mu.Unlock()
+	if aborted {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	n, err := c.Conn.Read(p)
+
+	c.mu.Lock()
+	c.received += int64(n)
+	var netErr net.Error
+	timedOut := errors.As(err, &netErr) && netErr.Timeout() && !c.aborted && !c.timedOut &&
+		c.deadline.After(pastDeadline) && (c.received > 0 || !c.wrote)
+	if timedOut {
+		c.timedOut = true
+	}
+	received := c.received
+	c.mu.Unlock()
+
+	if timedOut {
+		zap.L().Warn("request timed out",
+			zap.String("reason", "headers"),
+			zap.String("remote_address", c.RemoteAddr().String()),
+			zap.Int64("received", received))
+		if c.reply {
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+			_, _ = c.Conn.Write([]byte(headerTimeoutResponse))
+		}
+	}
+
+	return n, err
+}
+
+func (c *headerTimeoutConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	replied := c.timedOut && c.reply
+	c.wrote = true
+	c.received = 0
+	c.mu.Unlock()
+
+	// The server may answer the partial request with 400 Bad Request,
+	// after the 408 already written.
+	if replied {
+		return len(p), nil
+	}
+
+	return c.Conn.Write(p)
+}
+
+type connKey struct{}
+
+// ConnContext adds the connection of the requests to their context, so
+// that its reads can be aborted when a request must be. It is meant to be
+// used as http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// requestConn returns the connection of a request, if ConnContext added it.
+func requestConn(ctx context.Context) net.Conn {
+	conn, _ := ctx.Value(connKey{}).(net.Conn)
+	return conn
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// HideFS wraps a webdav.FileSystem and pretends that the files whose name,
+// or the name of one of their parents, matches one of the glob patterns
+// don't exist. If AllowWrite is set, clients can still write them, such as
+// the metadata files written by the macOS Finder.
+type HideFS struct {
+	webdav.FileSystem
+	Patterns   []string
+	AllowWrite bool
+}
+
+// hidden checks if any element of name matches one of the patterns.
+func (fs HideFS) hidden(name string) bool {
+	for _, part := range strings.Split(path.Clean("/"+name), "/") {
+		if part != "" && fs.matches(part) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs HideFS) matches(base string) bool {
+	for _, pattern := range fs.Patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// denied returns the error for a write to a hidden file, or nil.
+func (fs HideFS) denied(name string) error {
+	if !fs.AllowWrite && fs.hidden(name) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// Mkdir creates a directory.
+func (fs HideFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.denied(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+// OpenFile opens a file. Hidden files can only be opened for writing.
+func (fs HideFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if fs.hidden(name) && (!fs.AllowWrite || flag&(os.O_WRONLY|os.O_RDWR) == 0) {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return hideFile{File: file, fs: fs}, nil
+}
+
+// RemoveAll removes a file or directory.
+func (fs HideFS) RemoveAll(ctx context.Context, name string) error {
+	if fs.hidden(name) && !fs.AllowWrite {
+		return os.ErrNotExist
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+// Rename renames a file or directory.
+func (fs HideFS) Rename(ctx context.Context, oldName, newName string) error {
+	if fs.hidden(oldName) && !fs.AllowWrite {
+		return os.ErrNotExist
+	}
+	if err := fs.denied(newName); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// Stat returns the info of a file.
+func (fs HideFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if fs.hidden(name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+type hideFile struct {
+	webdav.File
+	fs HideFS
+}
+
+// Readdir leaves out the hidden entries.
+func (f hideFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+
+	visible := infos[:0]
+	for _, info := range infos {
+		if !f.fs.matches(info.Name()) {
+			visible = append(visible, info)
+		}
+	}
+
+	return visible, err
+}
@@ -0,0 +1,304 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // Registers the hashes used by the HS and RS algorithms.
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is returned when a bearer token can't be verified.
+var ErrInvalidToken = errors.New("invalid token")
+
+// jwksRefresh is how often the keys of the JWKS URL are fetched again.
+const jwksRefresh = time.Hour
+
+// JWTAuth authenticates requests carrying a JWT in the Authorization header.
+// Tokens are signed either with a shared secret (HS256, HS384 or HS512) or
+// with one of the RSA keys published at a JWKS URL (RS256, RS384 or RS512).
+type JWTAuth struct {
+	Secret  []byte
+	JWKSURL string
+	Client  *http.Client
+
+	// UsernameClaim is the claim holding the username. Defaults to "sub".
+	UsernameClaim string
+
+	// ScopeClaim, if set, is the claim holding the scope of the user, as a
+	// path inside the scope of the default user.
+	ScopeClaim string
+
+	// Issuer and Audience, if set, are the iss and aud claims the tokens
+	// must have, so that the tokens the same provider signs for other
+	// services are refused.
+	Issuer   string
+	Audience string
+
+	// Users creates the users of the tokens.
+	Users *UserFactory
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Authenticate verifies the token and returns the user it belongs to.
+// Users that exist in users are returned as is, unless the token carries a
+// scope.
+func (a *JWTAuth) Authenticate(token string, users func(string) (*User, bool)) (*User, error) {
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	usernameClaim := a.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+
+	username, _ := claims[usernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("%w: missing %q claim", ErrInvalidToken, usernameClaim)
+	}
+
+	scope := ""
+	if a.ScopeClaim != "" {
+		scope, _ = claims[a.ScopeClaim].(string)
+	}
+	if scope != "" {
+		// The claim can't point out of the scope of the default user.
+		scope = filepath.Join(a.Users.Default.Scope, filepath.FromSlash(path.Clean("/"+scope)))
+	}
+
+	if scope == "" {
+		if user, ok := users(username); ok {
+			return user, nil
+		}
+	}
+
+	return a.Users.Get(username, scope, nil)
+}
+
+// verify checks the signature, the validity period, which must end, and
+// the issuer and audience of the token, and returns its claims.
+func (a *JWTAuth) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	header := jwtHeader{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256", "HS384", "HS512":
+		if len(a.Secret) == 0 {
+			return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidToken, header.Alg)
+		}
+
+		mac := hmac.New(jwtHash(header.Alg).New, a.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, ErrInvalidToken
+		}
+	case "RS256", "RS384", "RS512":
+		if a.JWKSURL == "" {
+			return nil, fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidToken, header.Alg)
+		}
+
+		key, err := a.key(header.Kid)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := jwtHash(header.Alg)
+		h := hash.New()
+		h.Write(signed)
+		if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), signature); err != nil {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := float64(time.Now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing \"exp\" claim", ErrInvalidToken)
+	}
+	if now >= exp {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+
+	if iss, _ := claims["iss"].(string); a.Issuer != "" && iss != a.Issuer {
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if a.Audience != "" && !jwtAudience(claims["aud"], a.Audience) {
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// jwtAudience checks if the aud claim, a string or a list of strings,
+// contains the audience.
+func jwtAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrInvalidToken
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func jwtHash(alg string) crypto.Hash {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
+
+// key returns the RSA key with the given id, fetching the JWKS again if the
+// keys are old or the id is unknown.
+func (a *JWTAuth) key(kid string) (*rsa.PublicKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, ok := a.keys[kid]
+	if ok && time.Since(a.fetched) < jwksRefresh {
+		return key, nil
+	}
+
+	// Avoid hammering the JWKS URL with tokens of unknown keys.
+	if !ok && time.Since(a.fetched) < time.Minute {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	keys, err := a.fetchKeys()
+	if err != nil {
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	a.keys = keys
+	a.fetched = time.Now()
+
+	key, ok = a.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	return key, nil
+}
+
+func (a *JWTAuth) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	res, err := client.Get(a.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %s", res.Status)
+	}
+
+	set := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signJWT returns an HS256 token with the claims.
+func signJWT(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTestJWTAuth returns a JWTAuth creating the users inside dir.
+func newTestJWTAuth(dir string) *JWTAuth {
+	return &JWTAuth{
+		Secret:     []byte("secret"),
+		ScopeClaim: "scope",
+		Issuer:     "https://idp.example.com",
+		Audience:   "webdav",
+		Users: &UserFactory{
+			Default: &User{Scope: dir},
+			New: func(username, scope string, modify, readOnly bool) (*User, error) {
+				return &User{Username: username, Scope: scope, Modify: modify, ReadOnly: readOnly}, nil
+			},
+		},
+	}
+}
+
+func noUsers(string) (*User, bool) { return nil, false }
+
+func TestJWTClaims(t *testing.T) {
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{"valid", map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "webdav", "exp": exp}, true},
+		{"audience list", map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": []string{"other", "webdav"}, "exp": exp}, true},
+		{"wrong issuer", map[string]interface{}{"sub": "alice", "iss": "https://other.example.com", "aud": "webdav", "exp": exp}, false},
+		{"missing issuer", map[string]interface{}{"sub": "alice", "aud": "webdav", "exp": exp}, false},
+		{"wrong audience", map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "other", "exp": exp}, false},
+		{"wrong audience list", map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": []string{"other"}, "exp": exp}, false},
+		{"missing exp", map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "webdav"}, false},
+		{"expired", map[string]interface{}{"sub": "alice", "iss": "https://idp.example.com", "aud": "webdav", "exp": exp - 7200}, false},
+	}
+
+	for _, tt := range tests {
+		a := newTestJWTAuth(t.TempDir())
+		_, err := a.Authenticate(signJWT(t, "secret", tt.claims), noUsers)
+		if tt.valid && err != nil {
+			t.Errorf("%s: got %v, want a valid token", tt.name, err)
+		} else if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestJWTScopeClaim(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestJWTAuth(dir)
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	for claim, want := range map[string]string{
+		"team":           filepath.Join(dir, "team"),
+		"/team/alice":    filepath.Join(dir, "team", "alice"),
+		"../../etc":      filepath.Join(dir, "etc"),
+		"/etc/../../var": filepath.Join(dir, "var"),
+	} {
+		token := signJWT(t, "secret", map[string]interface{}{
+			"sub": "alice", "iss": "https://idp.example.com", "aud": "webdav", "exp": exp, "scope": claim,
+		})
+		user, err := a.Authenticate(token, noUsers)
+		if err != nil {
+			t.Fatalf("%s: %s", claim, err)
+		}
+		if user.Scope != want {
+			t.Errorf("%s: got scope %s, want %s", claim, user.Scope, want)
+		}
+	}
+}
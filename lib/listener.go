@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxAcceptDelay is the longest delay before accepting connections again
+// after a temporary error.
+const maxAcceptDelay = time.Second
+
+// Listener wraps a net.Listener so that accepting new connections can be
+// paused and resumed without closing the socket. Connections that were
+// already accepted are not affected.
+type Listener struct {
+	net.Listener
+
+	mu     sync.Mutex
+	paused bool
+	resume chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewListener creates a new Listener wrapping l.
+func NewListener(l net.Listener) *Listener {
+	return &Listener{
+		Listener: l,
+		resume:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Accept waits for and returns the next connection. While the listener is
+// paused, Accept blocks until Resume or Close is called. Temporary errors,
+// such as running out of file descriptors, are retried after a delay
+// growing up to a second, and only permanent errors are returned.
+func (l *Listener) Accept() (net.Conn, error) {
+	if err := l.wait(); err != nil {
+		return nil, err
+	}
+
+	var delay time.Duration
+	var conn net.Conn
+	for {
+		var err error
+		conn, err = l.Listener.Accept()
+		if err == nil {
+			break
+		}
+		if !isTemporaryAcceptError(err) {
+			return nil, err
+		}
+
+		if delay == 0 {
+			delay = 5 * time.Millisecond
+		} else if delay *= 2; delay > maxAcceptDelay {
+			delay = maxAcceptDelay
+		}
+		zap.L().Warn("accepting connection failed, retrying", zap.Duration("delay", delay), zap.Error(err))
+
+		select {
+		case <-time.After(delay):
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+
+	// The listener may have been paused while we were blocked on Accept.
+	if err := l.wait(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// isTemporaryAcceptError checks if an error of Accept is worth retrying,
+// rather than a failure of the listener.
+func isTemporaryAcceptError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.ECONNRESET} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait blocks while the listener is paused.
+func (l *Listener) wait() error {
+	for {
+		l.mu.Lock()
+		paused, resume := l.paused, l.resume
+		l.mu.Unlock()
+
+		if !paused {
+			return nil
+		}
+
+		select {
+		case <-resume:
+		case <-l.done:
+			return net.ErrClosed
+		}
+	}
+}
+
+// Close closes the listener, unblocking any paused Accept.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+// Pause stops accepting new connections.
+func (l *Listener) Pause() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.paused {
+		return
+	}
+
+	l.paused = true
+	zap.L().Info("accepting connections paused", zap.String("address", l.Addr().String()))
+}
+
+// Resume resumes accepting new connections.
+func (l *Listener) Resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.paused {
+		return
+	}
+
+	l.paused = false
+	close(l.resume)
+	l.resume = make(chan struct{})
+	zap.L().Info("accepting connections resumed", zap.String("address", l.Addr().String()))
+}
+
+// Paused reports whether accepting new connections is paused.
+func (l *Listener) Paused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.paused
+}
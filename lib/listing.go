@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: .25em 1em; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+{{if .ReadOnly}}<p>Read only</p>{{end}}
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th><th></th></tr>
+{{if .Parent}}<tr><td><a href="{{.Parent}}">../</a></td><td></td><td></td><td></td></tr>{{end}}
+{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td class="size">{{.Size}}</td><td>{{.Modified}}</td><td>{{if .Download}}<a href="{{.Download}}">Download</a>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type listingEntry struct {
+	Name     string
+	URL      string
+	Size     string
+	Modified string
+	Download string
+}
+
+// serveListing writes an HTML index of the directory at r.URL.Path.
+// Entries denied by the user's rules are left out, and so are dotfiles
+// unless ShowHidden is set.
+func (c *Config) serveListing(w http.ResponseWriter, r *http.Request, u *User) {
+	dir := strings.TrimPrefix(r.URL.Path, u.Handler.Prefix)
+
+	f, err := u.Handler.FileSystem.OpenFile(r.Context(), dir, os.O_RDONLY, 0)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(0)
+	if err != nil {
+		RequestLogger(r).Error("listing directory failed", zap.String("path", r.URL.Path), zap.Error(err))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].IsDir() != infos[j].IsDir() {
+			return infos[i].IsDir()
+		}
+		return strings.ToLower(infos[i].Name()) < strings.ToLower(infos[j].Name())
+	})
+
+	base := strings.TrimSuffix(r.URL.Path, "/") + "/"
+	entries := []listingEntry{}
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, ".") && !c.ShowHidden {
+			continue
+		}
+
+		if !u.Allowed(base+name, true) {
+			continue
+		}
+
+		entry := listingEntry{
+			Name:     name,
+			URL:      (&url.URL{Path: base + name}).EscapedPath(),
+			Modified: info.ModTime().Format(time.RFC3339),
+		}
+
+		if info.IsDir() {
+			entry.Name += "/"
+			entry.URL += "/"
+		} else {
+			entry.Size = formatSize(info.Size())
+			entry.Download = entry.URL + "?download=1"
+		}
+
+		entries = append(entries, entry)
+	}
+
+	parent := ""
+	if strings.Trim(dir, "/") != "" {
+		p := path.Dir(strings.TrimSuffix(r.URL.Path, "/"))
+		if p != "/" {
+			p += "/"
+		}
+		parent = (&url.URL{Path: p}).EscapedPath()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = listingTemplate.Execute(w, map[string]interface{}{
+		"Path":     base,
+		"Parent":   parent,
+		"ReadOnly": c.ReadOnly() || !u.Allowed(r.URL.Path, false),
+		"Entries":  entries,
+	})
+	if err != nil {
+		RequestLogger(r).Error("rendering listing failed", zap.String("path", r.URL.Path), zap.Error(err))
+	}
+}
+
+// formatSize formats a size in bytes for humans.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return strconv.FormatFloat(float64(size)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
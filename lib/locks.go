@@ -0,0 +1,335 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// LockFunc is called when a lock is taken, and when it is released,
+// either by an UNLOCK request or because it expired.
+type LockFunc func(path, owner string, locked bool)
+
+// LockSystem wraps a webdav.LockSystem, capping the timeout of the locks
+// and notifying when they are taken and released.
+type LockSystem struct {
+	webdav.LockSystem
+	// MaxTimeout, if positive, caps the timeout requested by the clients,
+	// including infinite ones.
+	MaxTimeout time.Duration
+	OnLock     LockFunc
+
+	mu    sync.Mutex
+	locks map[string]*lockEntry
+}
+
+type lockEntry struct {
+	path    string
+	owner   string
+	depth   string
+	expires time.Time
+	timer   *time.Timer
+}
+
+// NewLockSystem creates an in-memory LockSystem with the lock settings of
+// the configuration.
+func (c *Config) NewLockSystem() *LockSystem {
+	return &LockSystem{
+		LockSystem: webdav.NewMemLS(),
+		MaxTimeout: c.MaxLockTimeout,
+		OnLock: func(path, owner string, locked bool) {
+			if c.OnLock != nil {
+				c.OnLock(path, owner, locked)
+			}
+		},
+		locks: map[string]*lockEntry{},
+	}
+}
+
+// capTimeout returns the duration of a lock, capped to MaxTimeout. Negative
+// durations are infinite.
+func (ls *LockSystem) capTimeout(duration time.Duration) time.Duration {
+	if ls.MaxTimeout > 0 && (duration < 0 || duration > ls.MaxTimeout) {
+		return ls.MaxTimeout
+	}
+	return duration
+}
+
+// expire sets when a lock expires, and reaps it then. Negative durations
+// are infinite. It must be called with the mutex held.
+func (ls *LockSystem) expire(entry *lockEntry, now time.Time, duration time.Duration) {
+	if entry.timer != nil {
+		entry.timer.Stop()
+		entry.timer = nil
+	}
+
+	entry.expires = time.Time{}
+	if duration >= 0 {
+		entry.expires = now.Add(duration)
+		entry.timer = time.AfterFunc(duration, func() {
+			ls.reap(time.Now())
+		})
+	}
+}
+
+// reap releases the locks that expired before now. The wrapped LockSystem
+// forgets them on its own, as the requests come.
+func (ls *LockSystem) reap(now time.Time) {
+	ls.mu.Lock()
+	expired := []*lockEntry{}
+	for token, entry := range ls.locks {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			delete(ls.locks, token)
+			expired = append(expired, entry)
+		}
+	}
+	ls.mu.Unlock()
+
+	for _, entry := range expired {
+		ls.notify(entry, false)
+	}
+}
+
+func (ls *LockSystem) notify(entry *lockEntry, locked bool) {
+	if ls.OnLock != nil {
+		ls.OnLock(entry.path, entry.owner, locked)
+	}
+}
+
+// Confirm confirms that the caller can claim all of the locks specified by
+// the given conditions.
+func (ls *LockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.reap(now)
+	return ls.LockSystem.Confirm(now, name0, name1, conditions...)
+}
+
+// Create creates a lock, with its duration capped.
+func (ls *LockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	ls.reap(now)
+
+	// The WebDAV handler takes a zero depth, infinite lock without owner
+	// for the duration of the requests writing without a lock token. It
+	// isn't a lock of a client, so it isn't reported.
+	if details.ZeroDepth && details.Duration < 0 && details.OwnerXML == "" {
+		return ls.LockSystem.Create(now, details)
+	}
+
+	details.Duration = ls.capTimeout(details.Duration)
+	token, err := ls.LockSystem.Create(now, details)
+	if err != nil {
+		return "", err
+	}
+
+	entry := &lockEntry{path: path.Clean("/" + details.Root), owner: lockOwner(details.OwnerXML), depth: "infinity"}
+	if details.ZeroDepth {
+		entry.depth = "0"
+	}
+
+	ls.mu.Lock()
+	ls.expire(entry, now, details.Duration)
+	ls.locks[token] = entry
+	ls.mu.Unlock()
+
+	ls.notify(entry, true)
+	return token, nil
+}
+
+// Refresh refreshes a lock, with its duration capped.
+func (ls *LockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.reap(now)
+
+	duration = ls.capTimeout(duration)
+	details, err := ls.LockSystem.Refresh(now, token, duration)
+	if err != nil {
+		return details, err
+	}
+
+	ls.mu.Lock()
+	if entry, ok := ls.locks[token]; ok {
+		ls.expire(entry, now, duration)
+	}
+	ls.mu.Unlock()
+
+	return details, nil
+}
+
+// Unlock releases a lock.
+func (ls *LockSystem) Unlock(now time.Time, token string) error {
+	ls.reap(now)
+
+	if err := ls.LockSystem.Unlock(now, token); err != nil {
+		return err
+	}
+
+	ls.mu.Lock()
+	entry, ok := ls.locks[token]
+	if ok {
+		ls.expire(entry, now, -1)
+		delete(ls.locks, token)
+	}
+	ls.mu.Unlock()
+
+	if ok {
+		ls.notify(entry, false)
+	}
+	return nil
+}
+
+// LockInfo describes an active lock.
+type LockInfo struct {
+	Token string `json:"token"`
+	User  string `json:"user"`
+	Path  string `json:"path"`
+	Owner string `json:"owner"`
+	// Depth is "0" or "infinity".
+	Depth string `json:"depth"`
+	// Expires is when the lock expires, zero if it never does.
+	Expires time.Time `json:"expires"`
+}
+
+// Locks returns the active locks.
+func (ls *LockSystem) Locks() []LockInfo {
+	ls.reap(time.Now())
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	locks := make([]LockInfo, 0, len(ls.locks))
+	for token, entry := range ls.locks {
+		locks = append(locks, LockInfo{
+			Token:   token,
+			Path:    entry.path,
+			Owner:   entry.owner,
+			Depth:   entry.depth,
+			Expires: entry.expires,
+		})
+	}
+	return locks
+}
+
+// ForceUnlock releases the locks whose root is the given path, whoever
+// holds them. It returns the number of locks released.
+func (ls *LockSystem) ForceUnlock(name string) int {
+	now := time.Now()
+	ls.reap(now)
+
+	name = path.Clean("/" + name)
+
+	ls.mu.Lock()
+	tokens := []string{}
+	for token, entry := range ls.locks {
+		if entry.path == name {
+			tokens = append(tokens, token)
+		}
+	}
+	ls.mu.Unlock()
+
+	released := 0
+	for _, token := range tokens {
+		if ls.Unlock(now, token) == nil {
+			released++
+		}
+	}
+	return released
+}
+
+// ActiveLocks returns the active locks of every user, including the users
+// authenticated by external services.
+func (c *Config) ActiveLocks() []LockInfo {
+	locks := []LockInfo{}
+	for _, u := range c.lockingUsers() {
+		for _, lock := range u.Handler.LockSystem.(*LockSystem).Locks() {
+			lock.User = u.Username
+			locks = append(locks, lock)
+		}
+	}
+
+	return locks
+}
+
+// ForceUnlock releases the locks of every user whose root is the given
+// path, such as the locks left by a client that crashed. It returns the
+// number of locks released.
+func (c *Config) ForceUnlock(name string) int {
+	released := 0
+	for _, u := range c.lockingUsers() {
+		released += u.Handler.LockSystem.(*LockSystem).ForceUnlock(name)
+	}
+	return released
+}
+
+// lockingUsers returns the users whose handler has a LockSystem, including
+// the users authenticated by external services.
+func (c *Config) lockingUsers() []*User {
+	users := []*User{c.User}
+
+	c.usersMu.RLock()
+	for _, u := range c.Users {
+		users = append(users, u)
+	}
+	c.usersMu.RUnlock()
+
+	if c.AuthWebhook != nil && c.AuthWebhook.Users != nil {
+		users = append(users, c.AuthWebhook.Users.all()...)
+	}
+	if c.JWT != nil && c.JWT.Users != nil && (c.AuthWebhook == nil || c.JWT.Users != c.AuthWebhook.Users) {
+		users = append(users, c.JWT.Users.all()...)
+	}
+
+	locking := []*User{}
+	for _, u := range users {
+		if u.Handler == nil {
+			continue
+		}
+		if _, ok := u.Handler.LockSystem.(*LockSystem); ok {
+			locking = append(locking, u)
+		}
+	}
+
+	return locking
+}
+
+// capLockTimeout caps the Timeout header of a LOCK request, so that the
+// response reports the timeout the lock was actually given.
+func capLockTimeout(header http.Header, max time.Duration) {
+	if max <= 0 {
+		return
+	}
+
+	first := strings.TrimSpace(strings.Split(header.Get("Timeout"), ",")[0])
+	if seconds := strings.TrimPrefix(first, "Second-"); seconds != first {
+		if n, err := strconv.ParseInt(seconds, 10, 64); err == nil && time.Duration(n)*time.Second <= max {
+			return
+		}
+	}
+
+	header.Set("Timeout", fmt.Sprintf("Second-%d", max/time.Second))
+}
+
+// lockOwner returns the text of the owner XML of a lock, such as the user
+// name or the URL clients put in it.
+func lockOwner(ownerXML string) string {
+	text := []string{}
+
+	decoder := xml.NewDecoder(strings.NewReader(ownerXML))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := token.(xml.CharData); ok {
+			if s := strings.TrimSpace(string(data)); s != "" {
+				text = append(text, s)
+			}
+		}
+	}
+
+	return strings.Join(text, " ")
+}
@@ -0,0 +1,33 @@
+package lib
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// SetMaintenance enables or disables the maintenance mode, in which the
+// requests are answered with 503 Service Unavailable. Requests that already
+// started are not affected.
+func (c *Config) SetMaintenance(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+
+	if atomic.SwapInt32(&c.maintenance, value) != value {
+		zap.L().Info("maintenance mode changed", zap.Bool("enabled", enabled))
+	}
+}
+
+// Maintenance reports whether the maintenance mode is enabled.
+func (c *Config) Maintenance() bool {
+	return atomic.LoadInt32(&c.maintenance) == 1
+}
+
+// serveMaintenance answers a request during the maintenance mode.
+func serveMaintenance(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "60")
+	http.Error(w, "Under maintenance", http.StatusServiceUnavailable)
+}
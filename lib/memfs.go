@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// ErrMemFSFull is returned when a write would exceed the memory limit.
+var ErrMemFSFull = errors.New("memory filesystem limit reached")
+
+// MemFS is an in-memory webdav.FileSystem whose total file size can be bounded.
+type MemFS struct {
+	webdav.FileSystem
+
+	mu    sync.Mutex
+	used  int64
+	limit int64
+}
+
+// NewMemFS creates a new in-memory filesystem. A limit of 0 means unlimited.
+func NewMemFS(limit int64) *MemFS {
+	return &MemFS{
+		FileSystem: webdav.NewMemFS(),
+		limit:      limit,
+	}
+}
+
+// OpenFile opens a file, keeping track of the memory released by truncation.
+func (fs *MemFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	var size int64
+	if flag&os.O_TRUNC != 0 {
+		if info, err := fs.FileSystem.Stat(ctx, name); err == nil && !info.IsDir() {
+			size = info.Size()
+		}
+	}
+
+	file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.release(size)
+	return &memFile{File: file, fs: fs}, nil
+}
+
+// RemoveAll removes a file or directory, releasing its memory.
+func (fs *MemFS) RemoveAll(ctx context.Context, name string) error {
+	size := fs.size(ctx, name)
+
+	if err := fs.FileSystem.RemoveAll(ctx, name); err != nil {
+		return err
+	}
+
+	fs.release(size)
+	return nil
+}
+
+// size returns the total size of the files under name.
+func (fs *MemFS) size(ctx context.Context, name string) int64 {
+	info, err := fs.FileSystem.Stat(ctx, name)
+	if err != nil {
+		return 0
+	}
+
+	if !info.IsDir() {
+		return info.Size()
+	}
+
+	f, err := fs.FileSystem.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return 0
+	}
+
+	var total int64
+	for _, info := range infos {
+		total += fs.size(ctx, path.Join(name, info.Name()))
+	}
+	return total
+}
+
+func (fs *MemFS) reserve(n int64) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.limit > 0 && fs.used+n > fs.limit {
+		return false
+	}
+
+	fs.used += n
+	return true
+}
+
+func (fs *MemFS) release(n int64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.used -= n
+	if fs.used < 0 {
+		fs.used = 0
+	}
+}
+
+type memFile struct {
+	webdav.File
+	fs *MemFS
+}
+
+// Write writes to the file, failing if the growth exceeds the memory limit.
+func (f *memFile) Write(p []byte) (int, error) {
+	offset, err := f.File.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := f.File.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	growth := offset + int64(len(p)) - info.Size()
+	if growth > 0 && !f.fs.reserve(growth) {
+		return 0, ErrMemFSFull
+	}
+
+	n, err := f.File.Write(p)
+	if short := int64(len(p) - n); growth > 0 && short > 0 {
+		if short > growth {
+			short = growth
+		}
+		f.fs.release(short)
+	}
+	return n, err
+}
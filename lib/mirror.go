@@ -0,0 +1,411 @@
+package lib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Mirror directions.
+const (
+	// MirrorPull copies the remote files to the local directory.
+	MirrorPull = "pull"
+	// MirrorPush copies the local files to the remote server.
+	MirrorPush = "push"
+)
+
+// RemoteConfig is a remote WebDAV server to mirror.
+type RemoteConfig struct {
+	// URL is the URL of the remote directory, such as
+	// "https://example.com/dav/backups/".
+	URL      string
+	Username string
+	Password string
+
+	// Client, if set, makes the requests instead of http.DefaultClient.
+	Client *http.Client
+
+	// OnProgress, if set, is notified of the progress of the transfers.
+	OnProgress ProgressFunc
+}
+
+// remoteEntry is a file or directory of the remote server.
+type remoteEntry struct {
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// davMultistatus is the body of the responses to PROPFIND requests.
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ETag          string `xml:"DAV: getetag"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// Mirror synchronizes the tree of a remote WebDAV directory and of the
+// local directory localScope, in the given direction. Files are copied
+// when they are missing or their size or modification time differ, and
+// directories are created as needed. Files are never deleted.
+//
+// Pulled files get the modification time of the remote ones, which tells
+// which are unchanged the next time. Pushed files replace the remote ones
+// only if they still have the ETag that was listed, so that files changed
+// on the remote server in the meantime aren't overwritten.
+func Mirror(remote RemoteConfig, localScope string, direction string) error {
+	base, err := url.Parse(remote.URL)
+	if err != nil {
+		return err
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+
+	m := &mirror{remote: remote, base: base, local: localScope, client: remote.Client}
+	if m.client == nil {
+		m.client = http.DefaultClient
+	}
+
+	switch direction {
+	case MirrorPull:
+		return m.pull("/")
+	case MirrorPush:
+		if err := m.mkcol(""); err != nil {
+			return fmt.Errorf("creating the remote directory: %w", err)
+		}
+		return m.push("/")
+	default:
+		return fmt.Errorf("invalid mirror direction %q", direction)
+	}
+}
+
+type mirror struct {
+	remote RemoteConfig
+	base   *url.URL
+	local  string
+	client *http.Client
+}
+
+// pull copies the remote directory dir, and its subdirectories.
+func (m *mirror) pull(dir string) error {
+	if err := os.MkdirAll(m.localPath(dir), 0755); err != nil {
+		return err
+	}
+
+	entries, err := m.list(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.dir {
+			if err := m.pull(e.name + "/"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		info, err := os.Stat(m.localPath(e.name))
+		if err == nil && info.Size() == e.size && info.ModTime().Unix() == e.modTime.Unix() {
+			continue
+		}
+
+		if err := m.download(e); err != nil {
+			return fmt.Errorf("pulling %s: %w", e.name, err)
+		}
+	}
+
+	return nil
+}
+
+// push copies the local directory dir, and its subdirectories.
+func (m *mirror) push(dir string) error {
+	entries, err := m.list(dir)
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]remoteEntry{}
+	for _, e := range entries {
+		existing[e.name] = e
+	}
+
+	infos, err := os.ReadDir(m.localPath(dir))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range infos {
+		name := dir + entry.Name()
+
+		if entry.IsDir() {
+			if _, ok := existing[name]; !ok {
+				if err := m.mkcol(name); err != nil {
+					return fmt.Errorf("pushing %s: %w", name, err)
+				}
+			}
+			if err := m.push(name + "/"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		e, ok := existing[name]
+		if ok && !e.dir && e.size == info.Size() && !e.modTime.Before(info.ModTime().Truncate(time.Second)) {
+			continue
+		}
+
+		if err := m.upload(name, info, e.etag); err != nil {
+			return fmt.Errorf("pushing %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// list returns the entries of the remote directory dir.
+func (m *mirror) list(dir string) ([]remoteEntry, error) {
+	req, err := m.request("PROPFIND", dir, strings.NewReader(`<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getetag/></D:prop></D:propfind>`))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// A missing directory isn't an empty one: a mistyped URL mustn't look
+	// like a remote without files.
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("listing %s: the remote directory doesn't exist", dir)
+	}
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("listing %s: unexpected status %s", dir, res.Status)
+	}
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("listing %s: %w", dir, err)
+	}
+
+	entries := []remoteEntry{}
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil || !strings.HasPrefix(href.Path, m.base.Path) {
+			continue
+		}
+
+		// Names are cleaned, so that hrefs can't point out of the local
+		// directory.
+		name := path.Clean("/" + strings.TrimPrefix(href.Path, m.base.Path))
+		if name == path.Clean(dir) || path.Dir(name) != path.Clean(dir) {
+			continue
+		}
+
+		e := remoteEntry{name: name}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			e.dir = e.dir || ps.Prop.ResourceType.Collection != nil
+			if ps.Prop.ContentLength != "" {
+				e.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			}
+			if ps.Prop.LastModified != "" {
+				e.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+			if ps.Prop.ETag != "" {
+				e.etag = ps.Prop.ETag
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// download copies a remote file to a temporary file, renamed over the local
+// one once complete.
+func (m *mirror) download(e remoteEntry) error {
+	req, err := m.request("GET", e.name, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	dst := m.localPath(e.name)
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".mirror-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, m.progress(res.Body, e.name, res.ContentLength))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if !e.modTime.IsZero() {
+		if err := os.Chtimes(tmp.Name(), e.modTime, e.modTime); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+
+	zap.L().Debug("mirror pulled file", zap.String("path", e.name), zap.Int64("size", e.size))
+	return nil
+}
+
+// upload copies a local file to the remote server. If etag is set, the
+// remote file is only replaced if it still has it.
+func (m *mirror) upload(name string, info os.FileInfo, etag string) error {
+	f, err := os.Open(m.localPath(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := m.request("PUT", name, m.progress(f, name, info.Size()))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	if info.Size() == 0 {
+		req.Body = http.NoBody
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("the remote file changed while mirroring")
+	default:
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	zap.L().Debug("mirror pushed file", zap.String("path", name), zap.Int64("size", info.Size()))
+	return nil
+}
+
+// mkcol creates a remote directory.
+func (m *mirror) mkcol(name string) error {
+	req, err := m.request("MKCOL", name+"/", nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	// 405 Method Not Allowed is the answer when it exists already.
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusMethodNotAllowed {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// request creates an authenticated request for the remote path name.
+func (m *mirror) request(method, name string, body io.Reader) (*http.Request, error) {
+	u := *m.base
+	u.Path = m.base.Path + strings.TrimPrefix(name, "/")
+	u.RawPath = ""
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if m.remote.Username != "" {
+		req.SetBasicAuth(m.remote.Username, m.remote.Password)
+	}
+	return req, nil
+}
+
+// localPath returns the local path of a remote path.
+func (m *mirror) localPath(name string) string {
+	return filepath.Join(m.local, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// progress reports the bytes read from r to OnProgress, if set.
+func (m *mirror) progress(r io.Reader, name string, total int64) io.Reader {
+	if m.remote.OnProgress == nil {
+		return r
+	}
+	return &progressReader{Reader: r, p: newProgress(m.remote.OnProgress, name, total)}
+}
+
+// progressReader reports the progress of the bytes read, and the end of the
+// transfer at EOF.
+type progressReader struct {
+	io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.p.add(n)
+	if err == io.EOF {
+		r.p.done()
+	}
+	return n, err
+}
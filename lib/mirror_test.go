@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorPull(t *testing.T) {
+	c, remoteDir := newTestConfig(t)
+	if err := os.Mkdir(filepath.Join(remoteDir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, remoteDir, "sub/file.txt", "content")
+
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	local := t.TempDir()
+	if err := Mirror(RemoteConfig{URL: server.URL + "/"}, local, MirrorPull); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, local, "sub/file.txt"); got != "content" {
+		t.Errorf("got %q, want the remote file", got)
+	}
+}
+
+func TestMirrorMissingRemote(t *testing.T) {
+	c, _ := newTestConfig(t)
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	if err := Mirror(RemoteConfig{URL: server.URL + "/missing/"}, t.TempDir(), MirrorPull); err == nil {
+		t.Errorf("pulling a missing remote directory succeeded")
+	}
+}
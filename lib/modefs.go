@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// ModeFS wraps a webdav.FileSystem rooted at a directory on disk and sets
+// the permissions, and optionally the group, of the files and directories
+// it creates, regardless of the umask.
+type ModeFS struct {
+	webdav.FileSystem
+	Root string
+	// FileMode and DirMode, if set, are the permissions of the created
+	// files and directories.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	// Group, if positive, is the group ID given to the created entries.
+	Group int
+}
+
+// path returns the path on disk for a WebDAV path.
+func (fs ModeFS) path(name string) string {
+	return filepath.Join(fs.Root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// apply sets the mode and the group of a created entry. Failures are
+// logged, the entry being created anyway.
+func (fs ModeFS) apply(name string, mode os.FileMode) {
+	p := fs.path(name)
+
+	if mode != 0 {
+		if err := os.Chmod(p, mode); err != nil {
+			zap.L().Warn("setting the mode failed", zap.String("path", name), zap.Error(err))
+		}
+	}
+
+	if fs.Group > 0 {
+		if err := os.Chown(p, -1, fs.Group); err != nil {
+			zap.L().Warn("setting the group failed", zap.String("path", name), zap.Error(err))
+		}
+	}
+}
+
+func (fs ModeFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.FileSystem.Mkdir(ctx, name, perm); err != nil {
+		return err
+	}
+
+	fs.apply(name, fs.DirMode)
+	return nil
+}
+
+func (fs ModeFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&os.O_CREATE == 0 {
+		return fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	}
+
+	created := flag&os.O_EXCL != 0
+	if !created {
+		_, err := fs.FileSystem.Stat(ctx, name)
+		created = os.IsNotExist(err)
+	}
+
+	file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		fs.apply(name, fs.FileMode)
+	}
+	return file, nil
+}
@@ -0,0 +1,304 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// ErrCrossMount is returned when renaming a file across mount points.
+var ErrCrossMount = errors.New("cannot move across mount points")
+
+// Mount maps a virtual path to a filesystem.
+type Mount struct {
+	Path       string
+	FileSystem webdav.FileSystem
+}
+
+// MountFS composes several filesystems into a single tree. Paths that are
+// not under any mount are served by the embedded root filesystem, and the
+// parents of the mount points are shown as collections.
+type MountFS struct {
+	webdav.FileSystem
+	Mounts []Mount
+}
+
+// resolve returns the filesystem serving name, the path relative to it
+// and the mount point.
+func (fs *MountFS) resolve(name string) (webdav.FileSystem, string, string) {
+	name = path.Clean("/" + name)
+
+	var best *Mount
+	for i := range fs.Mounts {
+		m := &fs.Mounts[i]
+		if name == m.Path || strings.HasPrefix(name, m.Path+"/") {
+			if best == nil || len(m.Path) > len(best.Path) {
+				best = m
+			}
+		}
+	}
+
+	if best == nil {
+		return fs.FileSystem, name, "/"
+	}
+
+	return best.FileSystem, path.Clean("/" + strings.TrimPrefix(name, best.Path)), best.Path
+}
+
+// isMountParent checks if name is a mount point or one of its parents.
+func (fs *MountFS) isMountParent(name string) bool {
+	name = path.Clean("/" + name)
+	for _, m := range fs.Mounts {
+		if name == "/" || m.Path == name || strings.HasPrefix(m.Path, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// children returns the names of the mount points, or their parents, that
+// are direct children of the directory name.
+func (fs *MountFS) children(name string) []string {
+	prefix := strings.TrimSuffix(path.Clean("/"+name), "/") + "/"
+
+	seen := map[string]bool{}
+	children := []string{}
+	for _, m := range fs.Mounts {
+		if !strings.HasPrefix(m.Path, prefix) {
+			continue
+		}
+
+		child := strings.SplitN(strings.TrimPrefix(m.Path, prefix), "/", 2)[0]
+		if !seen[child] {
+			seen[child] = true
+			children = append(children, child)
+		}
+	}
+
+	sort.Strings(children)
+	return children
+}
+
+// Stat returns the info of a file.
+func (fs *MountFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fsys, rel, _ := fs.resolve(name)
+
+	info, err := fsys.Stat(ctx, rel)
+	if err == nil {
+		if rel == "/" {
+			return mountInfo{FileInfo: info, name: path.Base(name)}, nil
+		}
+		return info, nil
+	}
+
+	if os.IsNotExist(err) && fs.isMountParent(name) {
+		return mountInfo{name: path.Base(name)}, nil
+	}
+
+	return nil, err
+}
+
+// OpenFile opens a file. Directories list the mount points under them.
+func (fs *MountFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fsys, rel, _ := fs.resolve(name)
+
+	file, err := fsys.OpenFile(ctx, rel, flag, perm)
+	if err != nil {
+		if os.IsNotExist(err) && flag&(os.O_WRONLY|os.O_RDWR) == 0 && fs.isMountParent(name) {
+			file = nil
+		} else {
+			return nil, err
+		}
+	}
+
+	children := fs.children(name)
+	if len(children) == 0 {
+		return file, nil
+	}
+
+	return &mountDir{File: file, ctx: ctx, fs: fs, name: name, children: children}, nil
+}
+
+// Mkdir creates a directory.
+func (fs *MountFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if fs.isMountParent(name) {
+		return os.ErrExist
+	}
+
+	fsys, rel, _ := fs.resolve(name)
+	return fsys.Mkdir(ctx, rel, perm)
+}
+
+// RemoveAll removes a file or directory. Mount points can't be removed.
+func (fs *MountFS) RemoveAll(ctx context.Context, name string) error {
+	if fs.isMountParent(name) {
+		return os.ErrPermission
+	}
+
+	fsys, rel, _ := fs.resolve(name)
+	return fsys.RemoveAll(ctx, rel)
+}
+
+// Rename renames a file or directory within a single mount.
+func (fs *MountFS) Rename(ctx context.Context, oldName, newName string) error {
+	if fs.isMountParent(oldName) || fs.isMountParent(newName) {
+		return os.ErrPermission
+	}
+
+	oldFS, oldRel, oldMount := fs.resolve(oldName)
+	_, newRel, newMount := fs.resolve(newName)
+	if oldMount != newMount {
+		return ErrCrossMount
+	}
+
+	return oldFS.Rename(ctx, oldRel, newRel)
+}
+
+type mountInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i mountInfo) Name() string { return i.name }
+
+func (i mountInfo) Size() int64 {
+	if i.FileInfo == nil {
+		return 0
+	}
+	return i.FileInfo.Size()
+}
+
+func (i mountInfo) Mode() os.FileMode {
+	if i.FileInfo == nil {
+		return os.ModeDir | 0555
+	}
+	return i.FileInfo.Mode()
+}
+
+func (i mountInfo) ModTime() time.Time {
+	if i.FileInfo == nil {
+		return time.Time{}
+	}
+	return i.FileInfo.ModTime()
+}
+
+func (i mountInfo) IsDir() bool {
+	return i.FileInfo == nil || i.FileInfo.IsDir()
+}
+
+func (i mountInfo) Sys() interface{} {
+	if i.FileInfo == nil {
+		return nil
+	}
+	return i.FileInfo.Sys()
+}
+
+// mountDir is a directory containing mount points. The directory itself
+// may only exist virtually, in which case File is nil.
+type mountDir struct {
+	webdav.File
+	ctx      context.Context
+	fs       *MountFS
+	name     string
+	children []string
+
+	entries []os.FileInfo
+	read    bool
+}
+
+func (d *mountDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		if err := d.readAll(); err != nil {
+			return nil, err
+		}
+	}
+
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *mountDir) readAll() error {
+	mounted := map[string]bool{}
+	for _, child := range d.children {
+		mounted[child] = true
+
+		info, err := d.fs.Stat(d.ctx, path.Join(d.name, child))
+		if err != nil {
+			return err
+		}
+		d.entries = append(d.entries, info)
+	}
+
+	if d.File != nil {
+		infos, err := d.File.Readdir(0)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			if !mounted[info.Name()] {
+				d.entries = append(d.entries, info)
+			}
+		}
+	}
+
+	d.read = true
+	return nil
+}
+
+func (d *mountDir) Stat() (os.FileInfo, error) {
+	if d.File == nil {
+		return mountInfo{name: path.Base(d.name)}, nil
+	}
+	return d.File.Stat()
+}
+
+func (d *mountDir) Read(p []byte) (int, error) {
+	if d.File == nil {
+		return 0, os.ErrInvalid
+	}
+	return d.File.Read(p)
+}
+
+func (d *mountDir) Seek(offset int64, whence int) (int64, error) {
+	if d.File == nil {
+		return 0, nil
+	}
+	return d.File.Seek(offset, whence)
+}
+
+func (d *mountDir) Write(p []byte) (int, error) {
+	if d.File == nil {
+		return 0, os.ErrPermission
+	}
+	return d.File.Write(p)
+}
+
+func (d *mountDir) Close() error {
+	if d.File == nil {
+		return nil
+	}
+	return d.File.Close()
+}
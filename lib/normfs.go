@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Filename normalization forms.
+const (
+	// NormalizeNone leaves the file names untouched.
+	NormalizeNone = "none"
+	// NormalizeNFC uses the composed form, as most platforms do.
+	NormalizeNFC = "nfc"
+	// NormalizeNFD uses the decomposed form, as macOS does.
+	NormalizeNFD = "nfd"
+)
+
+// normalizeForms maps the filename normalizations to their Unicode forms.
+var normalizeForms = map[string]norm.Form{
+	NormalizeNFC: norm.NFC,
+	NormalizeNFD: norm.NFD,
+}
+
+// NewNormalizeFS wraps fs so that the file names are normalized with the
+// given normalization, one of the Normalize constants. fs is returned as is
+// for NormalizeNone.
+func NewNormalizeFS(fs webdav.FileSystem, normalization string) webdav.FileSystem {
+	form, ok := normalizeForms[normalization]
+	if !ok {
+		return fs
+	}
+	return NormalizeFS{FileSystem: fs, Form: form}
+}
+
+// normalizePath normalizes the path of a request, so that the rules are
+// matched against the same form as the files.
+func (c *Config) normalizePath(p string) string {
+	if form, ok := normalizeForms[c.NormalizeFilenames]; ok {
+		return form.String(p)
+	}
+	return p
+}
+
+// NormalizeFS wraps a webdav.FileSystem and normalizes the Unicode form of
+// the file names, so that clients sending decomposed (NFD) names, such as
+// macOS, and clients sending composed (NFC) names find the same files.
+// Files that already exist in the other form are still found, and new
+// files are created in Form.
+type NormalizeFS struct {
+	webdav.FileSystem
+	Form norm.Form
+}
+
+// resolve returns the existing name matching name once normalized, or the
+// normalized name if there is none.
+func (fs NormalizeFS) resolve(ctx context.Context, name string) string {
+	name = fs.Form.String(path.Clean("/" + name))
+	if _, err := fs.FileSystem.Stat(ctx, name); err == nil || !os.IsNotExist(err) {
+		return name
+	}
+
+	// Looks for each element of the path in its parent, comparing the
+	// normalized names.
+	resolved := "/"
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, part := range parts {
+		candidate := path.Join(resolved, part)
+		if _, err := fs.FileSystem.Stat(ctx, candidate); err == nil {
+			resolved = candidate
+			continue
+		}
+
+		match, ok := fs.lookup(ctx, resolved, part)
+		if !ok {
+			return path.Join(append([]string{resolved}, parts[i:]...)...)
+		}
+		resolved = path.Join(resolved, match)
+	}
+
+	return resolved
+}
+
+// lookup finds the entry of dir whose normalized name is name.
+func (fs NormalizeFS) lookup(ctx context.Context, dir, name string) (string, bool) {
+	f, err := fs.FileSystem.OpenFile(ctx, dir, os.O_RDONLY, 0)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	infos, err := f.Readdir(0)
+	if err != nil {
+		return "", false
+	}
+
+	for _, info := range infos {
+		if fs.Form.String(info.Name()) == name {
+			return info.Name(), true
+		}
+	}
+
+	return "", false
+}
+
+// Mkdir creates a directory.
+func (fs NormalizeFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.FileSystem.Mkdir(ctx, fs.resolve(ctx, name), perm)
+}
+
+// OpenFile opens a file.
+func (fs NormalizeFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := fs.FileSystem.OpenFile(ctx, fs.resolve(ctx, name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeFile{File: file, form: fs.Form}, nil
+}
+
+// RemoveAll removes a file or directory.
+func (fs NormalizeFS) RemoveAll(ctx context.Context, name string) error {
+	return fs.FileSystem.RemoveAll(ctx, fs.resolve(ctx, name))
+}
+
+// Rename renames a file or directory.
+func (fs NormalizeFS) Rename(ctx context.Context, oldName, newName string) error {
+	return fs.FileSystem.Rename(ctx, fs.resolve(ctx, oldName), fs.resolve(ctx, newName))
+}
+
+// Stat returns the info of a file.
+func (fs NormalizeFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.FileSystem.Stat(ctx, fs.resolve(ctx, name))
+	if err != nil {
+		return nil, err
+	}
+	return normalizeInfo{FileInfo: info, form: fs.Form}, nil
+}
+
+type normalizeFile struct {
+	webdav.File
+	form norm.Form
+}
+
+// Readdir returns the entries with normalized names.
+func (f normalizeFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	for i, info := range infos {
+		infos[i] = normalizeInfo{FileInfo: info, form: f.form}
+	}
+	return infos, err
+}
+
+// Stat returns the info of the file with its normalized name.
+func (f normalizeFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return normalizeInfo{FileInfo: info, form: f.form}, nil
+}
+
+type normalizeInfo struct {
+	os.FileInfo
+	form norm.Form
+}
+
+func (i normalizeInfo) Name() string {
+	return i.form.String(i.FileInfo.Name())
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// isNoSpace checks if err means that the storage is full, or that the
+// quota of the user is exceeded.
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) || errors.Is(err, ErrMemFSFull)
+}
+
+type noSpaceKey struct{}
+
+// withNoSpace tracks whether the storage was full while serving the request.
+func withNoSpace(r *http.Request) (*http.Request, *int32) {
+	full := new(int32)
+	return r.WithContext(context.WithValue(r.Context(), noSpaceKey{}, full)), full
+}
+
+// markNoSpace records that the storage was full, if err says so.
+func markNoSpace(ctx context.Context, err error) {
+	if !isNoSpace(err) {
+		return
+	}
+	if full, ok := ctx.Value(noSpaceKey{}).(*int32); ok {
+		atomic.StoreInt32(full, 1)
+	}
+}
+
+// NoSpaceFS wraps a webdav.FileSystem to record the errors of a full
+// storage in the context of the requests, which are then answered with 507
+// Insufficient Storage instead of a generic error.
+type NoSpaceFS struct {
+	webdav.FileSystem
+}
+
+func (fs NoSpaceFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	err := fs.FileSystem.Mkdir(ctx, name, perm)
+	markNoSpace(ctx, err)
+	return err
+}
+
+func (fs NoSpaceFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		markNoSpace(ctx, err)
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return file, nil
+	}
+	return noSpaceFile{File: file, ctx: ctx}, nil
+}
+
+func (fs NoSpaceFS) Rename(ctx context.Context, oldName, newName string) error {
+	err := fs.FileSystem.Rename(ctx, oldName, newName)
+	markNoSpace(ctx, err)
+	return err
+}
+
+type noSpaceFile struct {
+	webdav.File
+	ctx context.Context
+}
+
+func (f noSpaceFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	markNoSpace(f.ctx, err)
+	return n, err
+}
+
+func (f noSpaceFile) Close() error {
+	err := f.File.Close()
+	markNoSpace(f.ctx, err)
+	return err
+}
+
+// noSpaceWriter answers 507 Insufficient Storage instead of the error the
+// WebDAV handler answers with when the storage was full.
+type noSpaceWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	full    *int32
+	replied bool
+}
+
+func (w *noSpaceWriter) WriteHeader(statusCode int) {
+	if statusCode < 400 || atomic.LoadInt32(w.full) == 0 {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.replied = true
+	RequestLogger(w.r).Warn("insufficient storage", zap.String("method", w.r.Method), zap.String("path", w.r.URL.Path))
+	http.Error(w.ResponseWriter, "Insufficient storage: the disk is full or the quota is exceeded", http.StatusInsufficientStorage)
+}
+
+func (w *noSpaceWriter) Write(data []byte) (int, error) {
+	if w.replied {
+		// The body of the error the handler answered with.
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
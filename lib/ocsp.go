@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspRetry is the time to wait before fetching an OCSP response again
+// after a failure.
+const ocspRetry = 10 * time.Minute
+
+// errOCSPNotGood is returned when the OCSP server reports the certificate
+// as revoked, or doesn't know it.
+var errOCSPNotGood = errors.New("the certificate isn't reported as good")
+
+// OCSPStapler serves a TLS certificate with a stapled OCSP response, which
+// it fetches from the OCSP server of the certificate and refreshes halfway
+// through its validity. When no valid response could be fetched, the
+// certificate is served without staple.
+type OCSPStapler struct {
+	// Client, if set, fetches the responses instead of http.DefaultClient.
+	Client *http.Client
+
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	nextUpdate time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewOCSPStapler loads the certificate and key files. The certificate file
+// must contain the chain, the certificate being followed by its issuer.
+func NewOCSPStapler(certFile, keyFile string) (*OCSPStapler, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &OCSPStapler{cert: &cert, stop: make(chan struct{})}
+
+	s.leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(cert.Certificate) > 1 {
+		s.issuer, err = x509.ParseCertificate(cert.Certificate[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// GetCertificate returns the certificate, with its current OCSP staple.
+// It is meant to be used as tls.Config.GetCertificate.
+func (s *OCSPStapler) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert, nil
+}
+
+// Start fetches the OCSP response and keeps it up to date, until Stop is
+// called. Certificates without issuer or OCSP server are never stapled.
+func (s *OCSPStapler) Start() {
+	if s.issuer == nil {
+		zap.L().Warn("OCSP stapling disabled: the certificate file has no issuer certificate")
+		return
+	}
+	if len(s.leaf.OCSPServer) == 0 {
+		zap.L().Warn("OCSP stapling disabled: the certificate has no OCSP server")
+		return
+	}
+
+	go func() {
+		for {
+			next := s.refresh()
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-s.stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing the OCSP response.
+func (s *OCSPStapler) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+// refresh fetches a new OCSP response and staples it. It returns the time
+// of the next refresh.
+func (s *OCSPStapler) refresh() time.Time {
+	raw, res, err := s.fetch()
+	if err != nil {
+		zap.L().Warn("fetching the OCSP response failed", zap.Error(err))
+
+		// A staple past its next update would make clients reject the
+		// certificate, and one that is no longer good is wrong, so they
+		// are removed.
+		s.mu.RLock()
+		expired := !s.nextUpdate.IsZero() && time.Now().After(s.nextUpdate)
+		s.mu.RUnlock()
+		if expired || errors.Is(err, errOCSPNotGood) {
+			s.staple(nil, time.Time{})
+		}
+		return time.Now().Add(ocspRetry)
+	}
+
+	s.staple(raw, res.NextUpdate)
+	zap.L().Info("OCSP response stapled", zap.Time("next_update", res.NextUpdate))
+
+	if res.NextUpdate.IsZero() {
+		return time.Now().Add(24 * time.Hour)
+	}
+	next := res.ThisUpdate.Add(res.NextUpdate.Sub(res.ThisUpdate) / 2)
+	if min := time.Now().Add(time.Minute); next.Before(min) {
+		next = min
+	}
+	return next
+}
+
+// staple replaces the certificate by one with the given staple.
+func (s *OCSPStapler) staple(raw []byte, nextUpdate time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cert := *s.cert
+	cert.OCSPStaple = raw
+	s.cert = &cert
+	s.nextUpdate = nextUpdate
+}
+
+// fetch requests the status of the certificate to its OCSP server. Only
+// responses telling it is good are returned.
+func (s *OCSPStapler) fetch() ([]byte, *ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(s.leaf, s.issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	httpRes, err := client.Post(s.leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status %s", httpRes.Status)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(httpRes.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := ocsp.ParseResponseForCert(raw, s.leaf, s.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.Status != ocsp.Good {
+		return nil, nil, errOCSPNotGood
+	}
+	if !res.NextUpdate.IsZero() && time.Now().After(res.NextUpdate) {
+		return nil, nil, errors.New("the response is expired")
+	}
+
+	return raw, res, nil
+}
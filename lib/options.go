@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+// writeMethods are the methods that modify the files.
+var writeMethods = map[string]bool{
+	"PUT":       true,
+	"PATCH":     true,
+	"POST":      true,
+	"MKCOL":     true,
+	"DELETE":    true,
+	"PROPPATCH": true,
+	"COPY":      true,
+	"MOVE":      true,
+}
+
+// handleOptions answers an OPTIONS request advertising only the features
+// that are actually available to the user at that path: the locking
+// methods and the DAV class 2 are only advertised if there is a lock
+// system, and the write methods only if the user can modify the path. In
+// the read only mode, or for a read only user, neither is advertised, as
+// the locks can't be taken either.
+func (c *Config) handleOptions(w http.ResponseWriter, r *http.Request, u *User) {
+	name := strings.TrimPrefix(r.URL.Path, u.Handler.Prefix)
+	readOnly := c.ReadOnly() || u.ReadOnly
+	locking := u.Handler.LockSystem != nil && !readOnly
+	writable := u.Allowed(r.URL.Path, false) && !readOnly
+
+	methods := []string{"OPTIONS", "LOCK", "PUT", "MKCOL"}
+	if info, err := u.Handler.FileSystem.Stat(r.Context(), name); err == nil {
+		if info.IsDir() {
+			methods = []string{"OPTIONS", "LOCK", "DELETE", "PROPPATCH", "COPY", "MOVE", "UNLOCK", "PROPFIND"}
+		} else {
+			methods = []string{"OPTIONS", "LOCK", "GET", "HEAD", "POST", "DELETE", "PROPPATCH", "COPY", "MOVE", "UNLOCK", "PROPFIND", "PUT"}
+			if c.PartialUpdates {
+				methods = append(methods, "PATCH")
+			}
+		}
+	}
+
+	allow := []string{}
+	for _, m := range methods {
+		if (m == "LOCK" || m == "UNLOCK") && !locking {
+			continue
+		}
+		if writeMethods[m] && !writable {
+			continue
+		}
+		allow = append(allow, m)
+	}
+
+	dav := c.DavCompliance
+	if dav == "" {
+		dav = "1"
+		if locking {
+			dav = "1, 2"
+		}
+	}
+	if c.PartialUpdates {
+		dav += ", sabredav-partialupdate"
+	}
+
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	// http://www.webdav.org/specs/rfc4918.html#dav.compliance.classes
+	w.Header().Set("DAV", dav)
+	// http://msdn.microsoft.com/en-au/library/cc250217.aspx
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+}
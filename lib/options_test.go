@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *Config)
+		path  string
+		dav   string
+		allow string
+	}{
+		{
+			name:  "file",
+			path:  "/file.txt",
+			dav:   "1, 2",
+			allow: "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT",
+		},
+		{
+			name:  "directory",
+			path:  "/dir",
+			dav:   "1, 2",
+			allow: "OPTIONS, LOCK, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND",
+		},
+		{
+			name:  "missing",
+			path:  "/missing.txt",
+			dav:   "1, 2",
+			allow: "OPTIONS, LOCK, PUT, MKCOL",
+		},
+		{
+			name:  "without locking",
+			setup: func(c *Config) { c.User.Handler.LockSystem = nil },
+			path:  "/file.txt",
+			dav:   "1",
+			allow: "OPTIONS, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, PROPFIND, PUT",
+		},
+		{
+			name:  "read only mode",
+			setup: func(c *Config) { c.SetReadOnly(true) },
+			path:  "/file.txt",
+			dav:   "1",
+			allow: "OPTIONS, GET, HEAD, PROPFIND",
+		},
+		{
+			name:  "read only user",
+			setup: func(c *Config) { c.User.ReadOnly = true },
+			path:  "/file.txt",
+			dav:   "1",
+			allow: "OPTIONS, GET, HEAD, PROPFIND",
+		},
+		{
+			name:  "without modify",
+			setup: func(c *Config) { c.User.Modify = false },
+			path:  "/missing.txt",
+			dav:   "1, 2",
+			allow: "OPTIONS, LOCK",
+		},
+		{
+			name: "rule",
+			setup: func(c *Config) {
+				c.User.Rules = []*Rule{{Regex: true, Regexp: regexp.MustCompile(`\.txt$`), Allow: true}}
+			},
+			path:  "/file.txt",
+			dav:   "1, 2",
+			allow: "OPTIONS, LOCK, GET, HEAD, UNLOCK, PROPFIND",
+		},
+		{
+			name:  "partial updates",
+			setup: func(c *Config) { c.PartialUpdates = true },
+			path:  "/file.txt",
+			dav:   "1, 2, sabredav-partialupdate",
+			allow: "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT, PATCH",
+		},
+		{
+			name:  "compliance override",
+			setup: func(c *Config) { c.DavCompliance = "1, 2, 3" },
+			path:  "/file.txt",
+			dav:   "1, 2, 3",
+			allow: "OPTIONS, LOCK, GET, HEAD, POST, DELETE, PROPPATCH, COPY, MOVE, UNLOCK, PROPFIND, PUT",
+		},
+	}
+
+	for _, tt := range tests {
+		c, dir := newTestConfig(t)
+		writeTestFile(t, dir, "file.txt", "content")
+		if err := os.Mkdir(filepath.Join(dir, "dir"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if tt.setup != nil {
+			tt.setup(c)
+		}
+
+		w := serve(c, "OPTIONS", tt.path, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("DAV"); got != tt.dav {
+			t.Errorf("%s: got DAV %q, want %q", tt.name, got, tt.dav)
+		}
+		if got := w.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s: got Allow %q, want %q", tt.name, got, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package lib
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PartialUpdateType is the Content-Type of the PATCH requests updating a
+// part of a file, as defined by SabreDAV.
+const PartialUpdateType = "application/x-sabredav-partialupdate"
+
+// servePartialUpdate answers a PATCH request writing its body into an
+// existing file, at the range of the X-Update-Range header:
+//
+//	bytes=<start>-<end>  the bytes from start to end, inclusive
+//	bytes=<start>-       the bytes from start
+//	bytes=-<count>       the last count bytes
+//	append               the bytes after the end of the file
+//
+// Ranges starting past the end of the file are refused with 416 Range Not
+// Satisfiable. The preconditions of If-Match and If-None-Match are checked
+// as for PUT requests, and the new ETag is returned.
+func (c *Config) servePartialUpdate(w http.ResponseWriter, r *http.Request, u *User) {
+	if mediaType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]); mediaType != PartialUpdateType {
+		http.Error(w, "Content-Type must be "+PartialUpdateType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, u.Handler.Prefix)
+	defer c.putLocks.lock(u.Scope + "\x00" + name)()
+
+	existed, status := putPreconditions(r.Context(), u.Handler.FileSystem, name, r.Header)
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if !existed {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	info, err := u.Handler.FileSystem.Stat(r.Context(), name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, "Can't update a collection", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, length, ok := parseUpdateRange(r.Header.Get("X-Update-Range"), info.Size())
+	if !ok {
+		http.Error(w, "Invalid X-Update-Range", http.StatusBadRequest)
+		return
+	}
+	if start > info.Size() {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(info.Size(), 10))
+		http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if length >= 0 && r.ContentLength >= 0 && r.ContentLength != length {
+		http.Error(w, "Content-Length doesn't match X-Update-Range", http.StatusBadRequest)
+		return
+	}
+
+	f, err := u.Handler.FileSystem.OpenFile(r.Context(), name, os.O_RDWR, 0)
+	if err != nil {
+		w.WriteHeader(partialUpdateStatus(err))
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if length >= 0 {
+		body = io.LimitReader(r.Body, length)
+	}
+
+	var n int64
+	_, err = f.Seek(start, io.SeekStart)
+	if err == nil {
+		n, err = io.Copy(f, body)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		RequestLogger(r).Error("partial update failed", zap.String("path", r.URL.Path), zap.Error(err))
+		w.WriteHeader(partialUpdateStatus(err))
+		return
+	}
+	if length >= 0 && n != length {
+		http.Error(w, "Body shorter than X-Update-Range", http.StatusBadRequest)
+		return
+	}
+
+	if info, err := u.Handler.FileSystem.Stat(r.Context(), name); err == nil {
+		if etag, err := fileETag(r.Context(), info); err == nil {
+			w.Header().Set("ETag", etag)
+		}
+		c.emit(Event{Type: EventModified, Path: r.URL.Path, Size: info.Size(), User: u.Username, Time: time.Now()})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseUpdateRange parses an X-Update-Range header for a file of the given
+// size. It returns the offset to write at, and the length of the data, or
+// -1 if it isn't bounded.
+func parseUpdateRange(header string, size int64) (int64, int64, bool) {
+	header = strings.TrimSpace(header)
+	if header == "append" {
+		return size, -1, true
+	}
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, false
+	}
+
+	bounds := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+
+	if bounds[0] == "" {
+		count, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil || count < 1 || count > size {
+			return 0, 0, false
+		}
+		return size - count, count, true
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if bounds[1] == "" {
+		return start, -1, true
+	}
+
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end - start + 1, true
+}
+
+// partialUpdateStatus returns the status of a failed partial update. The
+// storages that can't write into files, such as S3 or the encrypted ones,
+// refuse it.
+func partialUpdateStatus(err error) int {
+	switch {
+	case isNoSpace(err):
+		return http.StatusInsufficientStorage
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Progress notifications are throttled to one every progressBytes bytes or
+// progressInterval, whichever comes first.
+const (
+	progressBytes    = 256 << 10
+	progressInterval = 200 * time.Millisecond
+)
+
+// ProgressFunc is called as the bytes of a transfer flow. The id is the
+// same for every call of a transfer, and total is -1 when unknown. It is
+// called with 0 bytes when the transfer starts, and the last call always
+// carries the final count.
+type ProgressFunc func(id, path string, transferred, total int64)
+
+// progress tracks the bytes of a single transfer.
+type progress struct {
+	fn    ProgressFunc
+	id    string
+	path  string
+	total int64
+
+	mu          sync.Mutex
+	transferred int64
+	reported    int64
+	last        time.Time
+	started     bool
+}
+
+func newProgress(fn ProgressFunc, path string, total int64) *progress {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return &progress{
+		fn:    fn,
+		id:    hex.EncodeToString(id),
+		path:  path,
+		total: total,
+	}
+}
+
+// start reports the beginning of the transfer, if not yet reported.
+func (p *progress) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.started {
+		p.started = true
+		p.last = time.Now()
+		p.fn(p.id, p.path, 0, p.total)
+	}
+}
+
+// add records n transferred bytes.
+func (p *progress) add(n int) {
+	if n <= 0 {
+		return
+	}
+
+	p.start()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.transferred += int64(n)
+	if p.transferred-p.reported >= progressBytes || time.Since(p.last) >= progressInterval {
+		p.report()
+	}
+}
+
+// done reports the end of the transfer.
+func (p *progress) done() {
+	p.start()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.transferred != p.reported {
+		p.report()
+	}
+}
+
+func (p *progress) report() {
+	p.reported = p.transferred
+	p.last = time.Now()
+	p.fn(p.id, p.path, p.transferred, p.total)
+}
+
+// requestTotal returns the expected size of a request body, or -1.
+func requestTotal(r *http.Request) int64 {
+	if r.ContentLength >= 0 {
+		return r.ContentLength
+	}
+
+	if n, err := strconv.ParseInt(r.Header.Get("X-Expected-Entity-Length"), 10, 64); err == nil {
+		return n
+	}
+
+	return -1
+}
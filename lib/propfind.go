@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// PROPFIND depths, as in the Depth header.
+const (
+	DepthZero     = "0"
+	DepthOne      = "1"
+	DepthInfinity = "infinity"
+)
+
+// depthLevel returns the level of a PROPFIND depth, with infinity being
+// the highest, and an error if it is invalid. An empty depth is infinity,
+// as RFC 4918 section 9.1 says.
+func depthLevel(depth string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(depth)) {
+	case DepthZero:
+		return 0, nil
+	case DepthOne:
+		return 1, nil
+	case DepthInfinity, "":
+		return 2, nil
+	default:
+		return 0, errors.New("invalid depth " + depth)
+	}
+}
+
+// ValidateDepth checks a maximum PROPFIND depth setting.
+func ValidateDepth(depth string) error {
+	_, err := depthLevel(depth)
+	return err
+}
+
+// propfindDepthAllowed checks the Depth header of a PROPFIND request
+// against MaxPropfindDepth. If it is too deep, it answers with 403
+// Forbidden and the propfind-finite-depth precondition of RFC 4918
+// section 9.1, before the handler walks the tree.
+func (c *Config) propfindDepthAllowed(w http.ResponseWriter, r *http.Request) bool {
+	max, err := depthLevel(c.MaxPropfindDepth)
+	if err != nil || max == 2 {
+		return true
+	}
+
+	depth, err := depthLevel(r.Header.Get("Depth"))
+	if err != nil || depth <= max {
+		// Invalid depths are left to the handler to refuse.
+		return true
+	}
+
+	RequestLogger(r).Info("propfind too deep", zap.String("path", r.URL.Path), zap.String("depth", r.Header.Get("Depth")))
+	writeDavError(w, http.StatusForbidden, "propfind-finite-depth")
+	return false
+}
+
+// propfindEntriesAllowed counts the entries a PROPFIND request would list,
+// up to MaxPropfindEntries. If there are more, it answers with 403
+// Forbidden, before the handler walks the tree, and the precondition
+// number-of-matches-within-limits of RFC 5323 section 3.3.
+func (c *Config) propfindEntriesAllowed(w http.ResponseWriter, r *http.Request, u *User) bool {
+	if c.MaxPropfindEntries <= 0 || !strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
+		return true
+	}
+
+	depth, err := depthLevel(r.Header.Get("Depth"))
+	if err != nil {
+		return true
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, u.Handler.Prefix)
+	count := countEntries(r.Context(), u.Handler.FileSystem, name, depth, c.MaxPropfindEntries+1)
+	if count <= c.MaxPropfindEntries {
+		return true
+	}
+
+	RequestLogger(r).Info("propfind too large", zap.String("path", r.URL.Path), zap.String("depth", r.Header.Get("Depth")), zap.Int("max_entries", c.MaxPropfindEntries))
+	writeDavError(w, http.StatusForbidden, "number-of-matches-within-limits")
+	return false
+}
+
+// countEntries counts the entries of name down to depth, with 2 being
+// infinity, stopping at limit.
+func countEntries(ctx context.Context, fs webdav.FileSystem, name string, depth, limit int) int {
+	info, err := fs.Stat(ctx, name)
+	if err != nil {
+		return 0
+	}
+
+	count := 1
+	if !info.IsDir() || depth == 0 {
+		return count
+	}
+
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return count
+	}
+	infos, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return count
+	}
+
+	for _, info := range infos {
+		if count >= limit {
+			break
+		}
+
+		if depth == 1 || !info.IsDir() {
+			count++
+			continue
+		}
+		count += countEntries(ctx, fs, path.Join(name, info.Name()), depth, limit-count)
+	}
+
+	return count
+}
+
+// propfindWriter keeps the streamed multistatus response of a PROPFIND
+// request well-formed: when the walk fails once the response started, the
+// error status the handler answers with is logged instead of being
+// appended to it.
+type propfindWriter struct {
+	http.ResponseWriter
+	path   string
+	log    *zap.Logger
+	status int
+	failed bool
+}
+
+func (w *propfindWriter) WriteHeader(statusCode int) {
+	if w.status != 0 {
+		w.failed = true
+		w.log.Error("propfind failed after the response started", zap.String("path", w.path), zap.Int("status", statusCode))
+		return
+	}
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *propfindWriter) Write(data []byte) (int, error) {
+	if w.failed {
+		return len(data), nil
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// writeDavError answers with a DAV error body carrying the given
+// precondition.
+func writeDavError(w http.ResponseWriter, status int, condition string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+		`<D:error xmlns:D="DAV:"><D:` + condition + `/></D:error>`))
+}
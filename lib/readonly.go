@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// SetReadOnly enables or disables the read only mode, in which the requests
+// modifying the files are refused with 403 Forbidden, whatever the
+// permissions of the users. Requests that already started are not affected.
+func (c *Config) SetReadOnly(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+
+	if atomic.SwapInt32(&c.readOnly, value) != value {
+		zap.L().Info("read only mode changed", zap.Bool("enabled", enabled))
+	}
+}
+
+// ReadOnly reports whether the read only mode is enabled.
+func (c *Config) ReadOnly() bool {
+	return atomic.LoadInt32(&c.readOnly) == 1
+}
+
+// isWriteMethod checks if a method modifies the files or their locks. The
+// POST and PATCH requests are the ones of the resumable uploads.
+func isWriteMethod(method string) bool {
+	switch method {
+	case "PUT", "DELETE", "MKCOL", "MOVE", "COPY", "PROPPATCH", "LOCK", "POST", "PATCH":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader is the header carrying the ID of the requests.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length of the longest request ID honored.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// requestInfo is what the logs of a request are tagged with: its ID, and
+// the log tag of its user once authenticated.
+type requestInfo struct {
+	id  string
+	tag atomic.Value
+}
+
+// RequestID wraps a handler so that every request has an ID, logged with
+// the events of the request and echoed in the X-Request-ID response header.
+// The ID sent by the client, or a reverse proxy, is honored if it is safe to
+// log. Otherwise a random one is generated.
+func RequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = randomHex(8)
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, &requestInfo{id: id})))
+	})
+}
+
+// GetRequestID returns the ID of the request of ctx, or an empty string.
+func GetRequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestIDKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// setLogTag records the log tag of the user of a request, which the events
+// logged afterwards carry, including by the handlers wrapping it.
+func setLogTag(r *http.Request, tag string) {
+	if info, ok := r.Context().Value(requestIDKey{}).(*requestInfo); ok && tag != "" {
+		info.tag.Store(tag)
+	}
+}
+
+// RequestLogger returns the logger of the events of a request, which
+// carries its ID and the log tag of its user.
+func RequestLogger(r *http.Request) *zap.Logger {
+	info, ok := r.Context().Value(requestIDKey{}).(*requestInfo)
+	if !ok {
+		return zap.L()
+	}
+
+	fields := []zap.Field{zap.String("request_id", info.id)}
+	if tag, ok := info.tag.Load().(string); ok {
+		fields = append(fields, zap.String("log_tag", tag))
+	}
+	return zap.L().With(fields...)
+}
+
+// validRequestID checks if id is a non-empty and short string of letters,
+// digits and the punctuation found in the usual formats, such as UUIDs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, ch := range id {
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '-' || ch == '_' || ch == '.' || ch == ':' || ch == '+' || ch == '/' || ch == '=') {
+			return false
+		}
+	}
+	return true
+}
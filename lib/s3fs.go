@@ -0,0 +1,695 @@
+package lib
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// S3Config is the configuration used to connect to an S3 compatible service.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	// TempDir is the directory the written objects are spooled to before
+	// being uploaded. Empty uses the default temporary directory.
+	TempDir string
+}
+
+// s3TempPrefix is the prefix of the files the objects are spooled to.
+const s3TempPrefix = "webdav-s3-"
+
+// S3FS is a webdav.FileSystem backed by an S3 bucket. Since S3 has no real
+// directories, collections are synthesized from the key prefixes, and empty
+// directories are stored as zero sized objects whose key ends with a slash.
+type S3FS struct {
+	Bucket string
+	Prefix string
+	Config S3Config
+	Client *http.Client
+}
+
+// NewS3FS creates a new S3FS serving the objects of bucket under prefix.
+func NewS3FS(bucket, prefix string, cfg S3Config) *S3FS {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+
+	return &S3FS{
+		Bucket: bucket,
+		Prefix: strings.Trim(prefix, "/"),
+		Config: cfg,
+		Client: http.DefaultClient,
+	}
+}
+
+// key returns the object key for a WebDAV path.
+func (fs *S3FS) key(name string) string {
+	return strings.TrimPrefix(path.Join("/", fs.Prefix, name), "/")
+}
+
+// dirKey returns the prefix of the objects inside a directory.
+func dirKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+// Stat returns the info of an object, or of a synthesized directory.
+func (fs *S3FS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	key := fs.key(name)
+	if key == fs.Prefix {
+		return &s3FileInfo{name: path.Base(name), dir: true}, nil
+	}
+
+	res, err := fs.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err == nil {
+		res.Body.Close()
+		return newS3FileInfo(path.Base(name), res), nil
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	list, err := fs.list(ctx, dirKey(key), "/", "", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list.Contents) == 0 && len(list.CommonPrefixes) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	info := &s3FileInfo{name: path.Base(name), dir: true}
+	if len(list.Contents) != 0 {
+		info.modTime = list.Contents[0].LastModified
+	}
+	return info, nil
+}
+
+// OpenFile opens an object. Objects opened for writing are spooled to a
+// temporary file and uploaded when closed.
+func (fs *S3FS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	key := fs.key(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		info, err := fs.Stat(ctx, name)
+		switch {
+		case err == nil && info.IsDir():
+			return nil, os.ErrInvalid
+		case err == nil && flag&os.O_EXCL != 0:
+			return nil, os.ErrExist
+		case err == nil && flag&os.O_TRUNC == 0:
+			return nil, errors.New("s3: objects can only be overwritten entirely")
+		case os.IsNotExist(err) && flag&os.O_CREATE == 0:
+			return nil, os.ErrNotExist
+		case err != nil && !os.IsNotExist(err):
+			return nil, err
+		}
+
+		tmp, err := os.CreateTemp(fs.Config.TempDir, s3TempPrefix)
+		if err != nil {
+			return nil, err
+		}
+
+		return &s3Writer{File: tmp, ctx: ctx, fs: fs, key: key, name: path.Base(name)}, nil
+	}
+
+	info, err := fs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3File{ctx: ctx, fs: fs, key: key, info: info.(*s3FileInfo)}, nil
+}
+
+// Mkdir creates a directory marker object.
+func (fs *S3FS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if _, err := fs.Stat(ctx, name); err == nil {
+		return os.ErrExist
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if parent := path.Dir(path.Clean("/" + name)); parent != "/" {
+		if info, err := fs.Stat(ctx, parent); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return os.ErrInvalid
+		}
+	}
+
+	return fs.put(ctx, dirKey(fs.key(name)), strings.NewReader(""), 0, nil)
+}
+
+// RemoveAll removes an object or every object under a directory.
+func (fs *S3FS) RemoveAll(ctx context.Context, name string) error {
+	key := fs.key(name)
+	if key == fs.Prefix {
+		return os.ErrInvalid
+	}
+
+	keys, err := fs.listAll(ctx, dirKey(key))
+	if err != nil {
+		return err
+	}
+
+	for _, k := range append(keys, key) {
+		if err := fs.delete(ctx, k); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rename copies the objects to their new keys and deletes the old ones.
+func (fs *S3FS) Rename(ctx context.Context, oldName, newName string) error {
+	oldKey, newKey := fs.key(oldName), fs.key(newName)
+	if oldKey == fs.Prefix || newKey == fs.Prefix {
+		return os.ErrInvalid
+	}
+
+	info, err := fs.Stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		if err := fs.copy(ctx, oldKey, newKey); err != nil {
+			return err
+		}
+		return fs.delete(ctx, oldKey)
+	}
+
+	keys, err := fs.listAll(ctx, dirKey(oldKey))
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		dst := dirKey(newKey) + strings.TrimPrefix(k, dirKey(oldKey))
+		if err := fs.copy(ctx, k, dst); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range keys {
+		if err := fs.delete(ctx, k); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *S3FS) put(ctx context.Context, key string, body io.Reader, size int64, header http.Header) error {
+	if size == 0 {
+		// Send an empty body with a zero Content-Length rather than chunked.
+		body = nil
+	}
+
+	res, err := fs.doBody(ctx, http.MethodPut, key, nil, header, body, size)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (fs *S3FS) copy(ctx context.Context, src, dst string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+fs.Bucket+"/"+s3EscapePath(src))
+	return fs.put(ctx, dst, strings.NewReader(""), 0, header)
+}
+
+func (fs *S3FS) delete(ctx context.Context, key string) error {
+	res, err := fs.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		Size         int64
+		ETag         string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// list lists the objects with the given prefix using ListObjectsV2.
+func (fs *S3FS) list(ctx context.Context, prefix, delimiter, token string, max int) (*s3ListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if max > 0 {
+		query.Set("max-keys", strconv.Itoa(max))
+	}
+
+	res, err := fs.do(ctx, http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	result := &s3ListResult{}
+	if err := xml.NewDecoder(res.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// listAll returns the keys of all the objects with the given prefix.
+func (fs *S3FS) listAll(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+
+	for {
+		list, err := fs.list(ctx, prefix, "", token, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range list.Contents {
+			keys = append(keys, obj.Key)
+		}
+
+		if !list.IsTruncated {
+			return keys, nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+func (fs *S3FS) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader) (*http.Response, error) {
+	return fs.doBody(ctx, method, key, query, header, body, 0)
+}
+
+// doBody sends a signed request to the bucket, translating error responses.
+func (fs *S3FS) doBody(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	endpoint, err := url.Parse(fs.Config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	escaped := "/" + s3EscapePath(fs.Bucket)
+	if key != "" {
+		escaped += "/" + s3EscapePath(key)
+	}
+
+	endpoint.Path, _ = url.PathUnescape(escaped)
+	endpoint.RawPath = escaped
+	endpoint.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	fs.sign(req, escaped, time.Now().UTC())
+
+	res, err := fs.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, os.ErrNotExist
+	}
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		res.Body.Close()
+		return nil, fmt.Errorf("s3: %s %s: %s: %s", method, key, res.Status, msg)
+	}
+
+	return res, nil
+}
+
+// sign signs the request using AWS Signature Version 4. The payload is not
+// signed so that bodies can be streamed.
+func (fs *S3FS) sign(req *http.Request, escapedPath string, now time.Time) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if fs.Config.AccessKey == "" {
+		return
+	}
+
+	names := []string{"host"}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + fs.Config.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + fs.Config.SecretKey)
+	for _, part := range []string{date, fs.Config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+fs.Config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes a string as required by AWS Signature Version 4.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = s3Escape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	etag    string
+}
+
+func newS3FileInfo(name string, res *http.Response) *s3FileInfo {
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return &s3FileInfo{
+		name:    name,
+		size:    res.ContentLength,
+		modTime: modTime,
+		etag:    res.Header.Get("ETag"),
+	}
+}
+
+func (i *s3FileInfo) Name() string       { return i.name }
+func (i *s3FileInfo) Size() int64        { return i.size }
+func (i *s3FileInfo) ModTime() time.Time { return i.modTime }
+func (i *s3FileInfo) IsDir() bool        { return i.dir }
+func (i *s3FileInfo) Sys() interface{}   { return nil }
+
+func (i *s3FileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ETag returns the ETag of the object as reported by S3.
+func (i *s3FileInfo) ETag(ctx context.Context) (string, error) {
+	if i.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.etag, nil
+}
+
+// s3File is an object or directory opened for reading. Object contents are
+// streamed with ranged GET requests starting at the current offset.
+type s3File struct {
+	ctx    context.Context
+	fs     *S3FS
+	key    string
+	info   *s3FileInfo
+	offset int64
+	body   io.ReadCloser
+
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.info.dir {
+		return 0, os.ErrInvalid
+	}
+
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		header := http.Header{}
+		header.Set("Range", "bytes="+strconv.FormatInt(f.offset, 10)+"-")
+
+		res, err := f.fs.do(f.ctx, http.MethodGet, f.key, nil, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		f.body = res.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, os.ErrInvalid
+	}
+
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, os.ErrInvalid
+	}
+
+	if !f.listed {
+		if err := f.list(); err != nil {
+			return nil, err
+		}
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *s3File) list() error {
+	prefix := dirKey(f.key)
+	token := ""
+
+	for {
+		list, err := f.fs.list(f.ctx, prefix, "/", token, 0)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range list.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			f.entries = append(f.entries, &s3FileInfo{name: name, dir: true})
+		}
+
+		for _, obj := range list.Contents {
+			if obj.Key == prefix {
+				continue
+			}
+
+			f.entries = append(f.entries, &s3FileInfo{
+				name:    strings.TrimPrefix(obj.Key, prefix),
+				size:    obj.Size,
+				modTime: obj.LastModified,
+				etag:    obj.ETag,
+			})
+		}
+
+		if !list.IsTruncated {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+
+	f.listed = true
+	return nil
+}
+
+func (f *s3File) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *s3File) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// s3Writer spools the written data to a temporary file and uploads it on Close.
+type s3Writer struct {
+	*os.File
+	ctx  context.Context
+	fs   *S3FS
+	key  string
+	name string
+}
+
+func (w *s3Writer) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (w *s3Writer) Stat() (os.FileInfo, error) {
+	info, err := w.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &s3FileInfo{name: w.name, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (w *s3Writer) Close() error {
+	defer os.Remove(w.File.Name())
+	defer w.File.Close()
+
+	info, err := w.File.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return w.fs.put(w.ctx, w.key, w.File, info.Size(), nil)
+}
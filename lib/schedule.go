@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a range of hours of some days of the week. A window whose
+// end is before its start spans midnight, and belongs to the day it starts.
+type TimeWindow struct {
+	// Days are the days of the window, every day if empty.
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseTimeWindow parses a time window such as "mon-fri 08:00-20:00",
+// "sat,sun 10:00-12:00" or "22:00-06:00", every day.
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	w := TimeWindow{}
+
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+	case 2:
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return w, err
+		}
+		w.Days = days
+	default:
+		return w, fmt.Errorf("invalid time window %q", s)
+	}
+
+	hours := strings.SplitN(fields[len(fields)-1], "-", 2)
+	if len(hours) != 2 {
+		return w, fmt.Errorf("invalid time window %q", s)
+	}
+
+	var err error
+	if w.Start, err = parseClock(hours[0]); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(hours[1]); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// parseWeekdays parses a comma separated list of days and ranges of days,
+// such as "mon-wed,sat".
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	days := []time.Weekday{}
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return nil, fmt.Errorf("invalid day %q", bounds[1])
+			}
+		}
+
+		// Ranges such as "fri-mon" wrap around the end of the week.
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a time of the day such as "08:30", up to "24:00".
+func parseClock(s string) (time.Duration, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// hasDay checks if the window is on day.
+func (w TimeWindow) hasDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains checks if t is in the window, in the time zone of t.
+func (w TimeWindow) Contains(t time.Time) bool {
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if w.Start <= w.End {
+		return w.hasDay(t.Weekday()) && clock >= w.Start && clock < w.End
+	}
+
+	// The window spans midnight.
+	if clock >= w.Start {
+		return w.hasDay(t.Weekday())
+	}
+	return clock < w.End && w.hasDay((t.Weekday()+6)%7)
+}
+
+// now returns the current time in the time zone of the write windows.
+func (c *Config) now() time.Time {
+	if c.Location != nil {
+		return time.Now().In(c.Location)
+	}
+	return time.Now()
+}
+
+// CanWriteAt checks if the user can write at t: always if it has no write
+// windows, and otherwise only in one of them.
+func (u User) CanWriteAt(t time.Time) bool {
+	if len(u.WriteWindows) == 0 {
+		return true
+	}
+	for _, w := range u.WriteWindows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
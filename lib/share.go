@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Share link errors.
+var (
+	ErrInvalidShare = errors.New("invalid share link")
+	ErrExpiredShare = errors.New("share link expired")
+	ErrRevokedShare = errors.New("share link revoked")
+)
+
+// Shares mints and verifies share links, which give access to a single
+// path without authentication. The tokens are signed with the secret, so
+// nothing is stored on the server besides the revoked tokens.
+type Shares struct {
+	Secret []byte
+
+	// BaseURL is prepended to the path of the links, such as
+	// "https://example.com". If empty, the links are relative.
+	BaseURL string
+
+	// RevokedFile, if set, is the JSON file the revoked tokens are kept
+	// in, so that they stay revoked after a restart. It is read again when
+	// it changes, so that the tokens revoked by another process, such as
+	// the share revoke command, are refused too.
+	RevokedFile string
+
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	loaded  os.FileInfo
+}
+
+type shareClaims struct {
+	Path     string `json:"p"`
+	Expires  int64  `json:"e"`
+	ReadOnly bool   `json:"r,omitempty"`
+}
+
+// Create mints a token for the path, valid until expiresAt, and returns it
+// along with the URL of the link.
+func (s *Shares) Create(name string, expiresAt time.Time, readOnly bool) (string, string, error) {
+	if len(s.Secret) == 0 {
+		return "", "", errors.New("share links need a secret")
+	}
+
+	name = path.Clean("/" + name)
+	payload, err := json.Marshal(shareClaims{
+		Path:     name,
+		Expires:  expiresAt.Unix(),
+		ReadOnly: readOnly,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	token := encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded))
+
+	link := strings.TrimSuffix(s.BaseURL, "/") + (&url.URL{Path: name}).EscapedPath() + "?token=" + token
+	return token, link, nil
+}
+
+// Revoke invalidates a token before it expires. With RevokedFile, the
+// revoked tokens are saved to it.
+func (s *Shares) Revoke(token string) error {
+	claims, err := s.decode(token)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The tokens revoked by other processes since the last read are kept.
+	if err := s.loadRevoked(); err != nil {
+		return err
+	}
+	if s.revoked == nil {
+		s.revoked = map[string]time.Time{}
+	}
+
+	now := time.Now()
+	for t, expires := range s.revoked {
+		if now.After(expires) {
+			delete(s.revoked, t)
+		}
+	}
+
+	s.revoked[token] = time.Unix(claims.Expires, 0)
+	return s.saveRevoked()
+}
+
+// Verify checks that the token is valid for the path, and returns whether
+// the link is read only.
+func (s *Shares) Verify(token, name string) (bool, error) {
+	claims, err := s.decode(token)
+	if err != nil {
+		return false, err
+	}
+
+	if claims.Path != path.Clean("/"+name) {
+		return false, ErrInvalidShare
+	}
+
+	if time.Now().Unix() >= claims.Expires {
+		return false, ErrExpiredShare
+	}
+
+	s.mu.Lock()
+	err = s.loadRevoked()
+	_, revoked := s.revoked[token]
+	s.mu.Unlock()
+	if err != nil {
+		// The link may have been revoked, so it is refused.
+		return false, fmt.Errorf("reading the revoked share links: %w", err)
+	}
+	if revoked {
+		return false, ErrRevokedShare
+	}
+
+	return claims.ReadOnly, nil
+}
+
+// loadRevoked reads the revoked tokens from RevokedFile, if set and
+// changed since it was last read. A missing file is not an error. s.mu must
+// be held.
+func (s *Shares) loadRevoked() error {
+	if s.RevokedFile == "" {
+		return nil
+	}
+
+	info, err := os.Stat(s.RevokedFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// The file is replaced on each save, and the modification times may be
+	// too coarse to tell two saves apart.
+	if s.loaded != nil && os.SameFile(info, s.loaded) && info.ModTime().Equal(s.loaded.ModTime()) && info.Size() == s.loaded.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.RevokedFile)
+	if err != nil {
+		return err
+	}
+
+	revoked := map[string]time.Time{}
+	if err := json.Unmarshal(data, &revoked); err != nil {
+		return err
+	}
+
+	s.revoked = revoked
+	s.loaded = info
+	return nil
+}
+
+// saveRevoked writes the revoked tokens to RevokedFile, if set. As with
+// the statistics, a temporary file replaces it, so that it is never left
+// partially written. s.mu must be held.
+func (s *Shares) saveRevoked() error {
+	if s.RevokedFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.revoked, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.RevokedFile), "."+filepath.Base(s.RevokedFile)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.RevokedFile)
+}
+
+func (s *Shares) decode(token string) (*shareClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || len(s.Secret) == 0 {
+		return nil, ErrInvalidShare
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.sign(parts[0])) {
+		return nil, ErrInvalidShare
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidShare
+	}
+
+	claims := &shareClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalidShare
+	}
+
+	return claims, nil
+}
+
+func (s *Shares) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// shareAllowed checks if a method can be used with a share link. Methods
+// that affect other paths, such as MOVE and COPY, are never allowed.
+func shareAllowed(method string, readOnly bool) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND":
+		return true
+	case "PUT", "DELETE", "LOCK", "UNLOCK", "PROPPATCH":
+		return !readOnly
+	default:
+		return false
+	}
+}
+
+// CreateShareLink mints a share link for a path of the default user.
+func (c *Config) CreateShareLink(name string, expiresAt time.Time, readOnly bool) (string, string, error) {
+	if c.Shares == nil {
+		return "", "", errors.New("share links are disabled")
+	}
+	return c.Shares.Create(name, expiresAt, readOnly)
+}
+
+// RevokeShareLink invalidates a share link before it expires.
+func (c *Config) RevokeShareLink(token string) error {
+	if c.Shares == nil {
+		return errors.New("share links are disabled")
+	}
+	return c.Shares.Revoke(token)
+}
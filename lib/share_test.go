@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShareRevokedFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "revoked.json")
+	server := &Shares{Secret: []byte("secret"), RevokedFile: file}
+
+	token, _, err := server.Create("/file.txt", time.Now().Add(time.Hour), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Verify(token, "/file.txt"); err != nil {
+		t.Fatalf("got %v, want a valid link", err)
+	}
+
+	// Another process, such as the share revoke command, revokes the link.
+	command := &Shares{Secret: []byte("secret"), RevokedFile: file}
+	if err := command.Revoke(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Verify(token, "/file.txt"); err != ErrRevokedShare {
+		t.Errorf("running server: got %v, want %v", err, ErrRevokedShare)
+	}
+
+	// It stays revoked after a restart.
+	restarted := &Shares{Secret: []byte("secret"), RevokedFile: file}
+	if _, err := restarted.Verify(token, "/file.txt"); err != ErrRevokedShare {
+		t.Errorf("restarted server: got %v, want %v", err, ErrRevokedShare)
+	}
+
+	// The tokens revoked by each process are kept.
+	other, _, err := server.Create("/other.txt", time.Now().Add(time.Hour), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Revoke(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Verify(token, "/file.txt"); err != ErrRevokedShare {
+		t.Errorf("first link: got %v, want %v", err, ErrRevokedShare)
+	}
+	if _, err := restarted.Verify(other, "/other.txt"); err != ErrRevokedShare {
+		t.Errorf("second link: got %v, want %v", err, ErrRevokedShare)
+	}
+}
+
+func TestShareRevokeInvalid(t *testing.T) {
+	s := &Shares{Secret: []byte("secret"), RevokedFile: filepath.Join(t.TempDir(), "revoked.json")}
+	if err := s.Revoke("not a token"); err != ErrInvalidShare {
+		t.Errorf("got %v, want %v", err, ErrInvalidShare)
+	}
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+)
+
+// SNICertificates selects the TLS certificate of a connection by the
+// server name its client asked for, falling back to Default when none
+// matches or the client sent no name.
+type SNICertificates struct {
+	// Default returns the certificate of the connections matching no other.
+	Default func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	names map[string]*tls.Certificate
+}
+
+// Add loads a certificate and its key, served for the given host names. A
+// name such as "*.example.com" matches the subdomains of example.com. If
+// no names are given, the ones of the certificate are used.
+func (s *SNICertificates) Add(certFile, keyFile string, hostnames []string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	if len(hostnames) == 0 {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		hostnames = leaf.DNSNames
+	}
+
+	if s.names == nil {
+		s.names = map[string]*tls.Certificate{}
+	}
+	for _, name := range hostnames {
+		s.names[strings.ToLower(name)] = &cert
+	}
+	return nil
+}
+
+// GetCertificate returns the certificate for a connection. It is meant to
+// be used as tls.Config.GetCertificate.
+func (s *SNICertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if cert, ok := s.names[name]; ok {
+			return cert, nil
+		}
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if cert, ok := s.names["*"+name[i:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+
+	return s.Default(hello)
+}
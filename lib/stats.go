@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// UserStat holds the transfer statistics of a user.
+type UserStat struct {
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	Requests int64     `json:"requests"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// recordStats adds a request to the statistics of a user.
+func (c *Config) recordStats(username string, in, out int64) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	if c.stats == nil {
+		c.stats = map[string]*UserStat{}
+	}
+
+	stat, ok := c.stats[username]
+	if !ok {
+		stat = &UserStat{}
+		c.stats[username] = stat
+	}
+
+	stat.BytesIn += in
+	stat.BytesOut += out
+	stat.Requests++
+	stat.LastSeen = time.Now()
+}
+
+// Stats returns the transfer statistics of each user since the server
+// started, or since they were reset, plus the ones loaded by LoadStats.
+// Requests without an authenticated user are kept under an empty username.
+// The byte counts reflect the bytes actually transferred in the request and
+// response bodies.
+func (c *Config) Stats() map[string]UserStat {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	stats := make(map[string]UserStat, len(c.stats))
+	for username, stat := range c.stats {
+		stats[username] = *stat
+	}
+	return stats
+}
+
+// ResetStats zeroes the transfer statistics of every user.
+func (c *Config) ResetStats() {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	c.stats = nil
+}
+
+// SaveStats writes the transfer statistics to a JSON file. It is written to
+// a temporary file in the same directory first, which replaces it, so that
+// the file is never left partially written.
+func (c *Config) SaveStats(name string) error {
+	data, err := json.MarshalIndent(c.Stats(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// LoadStats adds the transfer statistics of a file written by SaveStats to
+// the current ones, such as to keep counting after a restart. A missing
+// file is not an error.
+func (c *Config) LoadStats(name string) error {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	loaded := map[string]UserStat{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	if c.stats == nil {
+		c.stats = map[string]*UserStat{}
+	}
+	for username, l := range loaded {
+		stat, ok := c.stats[username]
+		if !ok {
+			stat = &UserStat{}
+			c.stats[username] = stat
+		}
+
+		stat.BytesIn += l.BytesIn
+		stat.BytesOut += l.BytesOut
+		stat.Requests += l.Requests
+		if l.LastSeen.After(stat.LastSeen) {
+			stat.LastSeen = l.LastSeen
+		}
+	}
+	return nil
+}
+
+// TotalStats returns the transfer statistics aggregated over all users.
+func (c *Config) TotalStats() UserStat {
+	total := UserStat{}
+	for _, stat := range c.Stats() {
+		total.BytesIn += stat.BytesIn
+		total.BytesOut += stat.BytesOut
+		total.Requests += stat.Requests
+		if stat.LastSeen.After(total.LastSeen) {
+			total.LastSeen = stat.LastSeen
+		}
+	}
+	return total
+}
+
+// ActiveRequests returns the number of requests being served.
+func (c *Config) ActiveRequests() int64 {
+	return atomic.LoadInt64(&c.active)
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/webdav"
+)
+
+// Symlink policies.
+const (
+	// SymlinksFollow follows every symbolic link.
+	SymlinksFollow = "follow"
+	// SymlinksDeny denies access to any path going through a symbolic link.
+	SymlinksDeny = "deny"
+	// SymlinksScope only follows symbolic links that resolve inside the root.
+	SymlinksScope = "scope"
+)
+
+// SymlinkFS wraps a webdav.FileSystem rooted at a directory on disk and
+// enforces a symbolic link policy. Blocked paths return os.ErrPermission and
+// are hidden from directory listings.
+type SymlinkFS struct {
+	webdav.FileSystem
+	Root   string
+	Policy string
+}
+
+// path returns the path on disk for a WebDAV path.
+func (fs SymlinkFS) path(name string) string {
+	return filepath.Join(fs.Root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// allowed checks if the policy allows accessing name.
+func (fs SymlinkFS) allowed(name string) bool {
+	switch fs.Policy {
+	case SymlinksDeny:
+		return !hasSymlink(filepath.Clean(fs.Root), fs.path(name))
+	case SymlinksScope:
+		root, err := filepath.EvalSymlinks(fs.Root)
+		if err != nil {
+			return false
+		}
+
+		real, ok := evalSymlinks(fs.path(name))
+		return ok && (real == root || strings.HasPrefix(real, root+string(filepath.Separator)))
+	default:
+		return true
+	}
+}
+
+// hasSymlink checks if any element of p below root is a symbolic link.
+func hasSymlink(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return true
+	}
+
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			// Nothing below this point exists yet.
+			return false
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evalSymlinks resolves the symbolic links of p. If p does not exist, the
+// deepest existing parent is resolved instead. Dangling links can't be
+// resolved and are reported as not ok.
+func evalSymlinks(p string) (string, bool) {
+	real, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return real, true
+	}
+
+	if _, lerr := os.Lstat(p); lerr == nil || !os.IsNotExist(err) {
+		return "", false
+	}
+
+	parent := filepath.Dir(p)
+	if parent == p {
+		return "", false
+	}
+
+	real, ok := evalSymlinks(parent)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(real, filepath.Base(p)), true
+}
+
+func (fs SymlinkFS) check(name string) error {
+	if fs.allowed(name) {
+		return nil
+	}
+
+	zap.L().Warn("symlink traversal blocked", zap.String("path", name), zap.String("policy", fs.Policy))
+	return os.ErrPermission
+}
+
+// Mkdir creates a directory.
+func (fs SymlinkFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := fs.check(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+// OpenFile opens a file.
+func (fs SymlinkFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if err := fs.check(name); err != nil {
+		return nil, err
+	}
+
+	file, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return symlinkFile{File: file, fs: fs, name: name}, nil
+}
+
+// RemoveAll removes a file or directory.
+func (fs SymlinkFS) RemoveAll(ctx context.Context, name string) error {
+	if err := fs.check(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.RemoveAll(ctx, name)
+}
+
+// Rename renames a file or directory.
+func (fs SymlinkFS) Rename(ctx context.Context, oldName, newName string) error {
+	if err := fs.check(oldName); err != nil {
+		return err
+	}
+	if err := fs.check(newName); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// Stat returns the info of a file.
+func (fs SymlinkFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if err := fs.check(name); err != nil {
+		return nil, err
+	}
+	return fs.FileSystem.Stat(ctx, name)
+}
+
+type symlinkFile struct {
+	webdav.File
+	fs   SymlinkFS
+	name string
+}
+
+// Readdir hides the entries that are blocked by the policy.
+func (f symlinkFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+
+	allowed := infos[:0]
+	for _, info := range infos {
+		if f.fs.allowed(path.Join(f.name, info.Name())) {
+			allowed = append(allowed, info)
+		}
+	}
+
+	return allowed, err
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// tempPrefixes are the prefixes of the temporary files written by the
+// server: the objects spooled before their upload to S3, and the
+// thumbnails being generated.
+var tempPrefixes = []string{s3TempPrefix, thumbTempPrefix}
+
+// CleanTempFiles removes the temporary files left in dir by a server that
+// stopped while writing them, that is the ones that weren't modified for
+// maxAge. It returns the number of removed files.
+func CleanTempFiles(dir string, maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !isTempFile(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			zap.L().Warn("removing a stale temporary file failed", zap.String("path", entry.Name()), zap.Error(err))
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+func isTempFile(name string) bool {
+	for _, prefix := range tempPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
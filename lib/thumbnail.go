@@ -0,0 +1,290 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Decoders of the images thumbnails are made of.
+	_ "image/gif"
+	_ "image/png"
+
+	"go.uber.org/zap"
+)
+
+// maxThumbnailPixels is the largest image, in pixels, thumbnails are made
+// of, so that small files decoding to huge images can't exhaust the memory.
+const maxThumbnailPixels = 50 << 20
+
+// thumbTempPrefix is the prefix of the thumbnails being generated.
+const thumbTempPrefix = ".thumb-"
+
+// errNotImage is returned when a file can't be decoded as an image, or is
+// too large to be.
+var errNotImage = errors.New("not an image")
+
+// thumbnailTypes are the mime types of the images thumbnails are made of.
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Thumbnails makes JPEG thumbnails of the images requested with the thumb
+// query parameter, such as "?thumb=256", and caches them on disk.
+type Thumbnails struct {
+	// CacheDir is the directory of the cached thumbnails.
+	CacheDir string
+	// Sizes are the allowed sizes, the longest side of the thumbnails in
+	// pixels.
+	Sizes []int
+	// MaxCacheSize, if positive, bounds the size of the cache in bytes. The
+	// least recently used thumbnails are removed past it.
+	MaxCacheSize int64
+
+	slots chan struct{}
+	mu    sync.Mutex
+}
+
+// NewThumbnails creates the cache directory, and Thumbnails making at most
+// concurrency thumbnails at once.
+func NewThumbnails(cacheDir string, sizes []int, maxCacheSize int64, concurrency int) (*Thumbnails, error) {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &Thumbnails{
+		CacheDir:     cacheDir,
+		Sizes:        sizes,
+		MaxCacheSize: maxCacheSize,
+		slots:        make(chan struct{}, concurrency),
+	}, nil
+}
+
+// allowed checks if size is one of the allowed sizes.
+func (t *Thumbnails) allowed(size int) bool {
+	for _, s := range t.Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// serve answers a GET request for the thumbnail of the file at r.URL.Path.
+// Sizes that aren't allowed are refused with 400 Bad Request, and files that
+// aren't images with 406 Not Acceptable.
+func (t *Thumbnails) serve(w http.ResponseWriter, r *http.Request, u *User, mimeTypes map[string]string) {
+	size, err := strconv.Atoi(r.URL.Query().Get("thumb"))
+	if err != nil || !t.allowed(size) {
+		http.Error(w, "Invalid thumbnail size", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, u.Handler.Prefix)
+	info, err := u.Handler.FileSystem.Stat(r.Context(), name)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if info.IsDir() || !thumbnailTypes[typeByExtension(mimeTypes, info.Name())] {
+		http.Error(w, "Not an image", http.StatusNotAcceptable)
+		return
+	}
+
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d", u.Scope, name, info.ModTime().UnixNano(), info.Size(), size)))
+	cached := filepath.Join(t.CacheDir, hex.EncodeToString(key[:])+".jpg")
+
+	if _, err := os.Stat(cached); err != nil {
+		select {
+		case t.slots <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+
+		// Another request may have made it while this one waited.
+		if _, err = os.Stat(cached); err != nil {
+			err = t.generate(r, u, name, cached, size)
+		}
+		<-t.slots
+
+		if err == errNotImage {
+			http.Error(w, "Not an image", http.StatusNotAcceptable)
+			return
+		}
+		if err != nil {
+			RequestLogger(r).Error("making thumbnail failed", zap.String("path", r.URL.Path), zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	f, err := os.Open(cached)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// The modification time tells which thumbnails were used last.
+	now := time.Now()
+	_ = os.Chtimes(cached, now, now)
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// generate makes the thumbnail of name and writes it to cached.
+func (t *Thumbnails) generate(r *http.Request, u *User, name, cached string, size int) error {
+	f, err := u.Handler.FileSystem.OpenFile(r.Context(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil || config.Width*config.Height > maxThumbnailPixels {
+		return errNotImage
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return errNotImage
+	}
+
+	tmp, err := os.CreateTemp(t.CacheDir, thumbTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = jpeg.Encode(tmp, resize(src, size), &jpeg.Options{Quality: 80})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return err
+	}
+
+	t.prune()
+	return nil
+}
+
+// prune removes the least recently used thumbnails until the cache fits in
+// MaxCacheSize.
+func (t *Thumbnails) prune() {
+	if t.MaxCacheSize <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries, err := os.ReadDir(t.CacheDir)
+	if err != nil {
+		return
+	}
+
+	infos := []os.FileInfo{}
+	total := int64(0)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".jpg") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+			total += info.Size()
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	for _, info := range infos {
+		if total <= t.MaxCacheSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(t.CacheDir, info.Name())); err == nil {
+			total -= info.Size()
+		}
+	}
+}
+
+// resize scales an image down so that its longest side is size pixels,
+// averaging the pixels each one covers. Smaller images are kept as they are.
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	// JPEG has no transparency: transparent images are put on white.
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Over)
+	if sw <= size && sh <= size {
+		return rgba
+	}
+
+	dw, dh := size, sh*size/sw
+	if sh > sw {
+		dw, dh = sw*size/sh, size
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(rgba.Pix[i])
+					g += int(rgba.Pix[i+1])
+					b += int(rgba.Pix[i+2])
+					a += int(rgba.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
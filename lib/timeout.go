@@ -0,0 +1,312 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Timeout wraps a handler so that the requests taking longer than timeout
+// are canceled with 503 Service Unavailable. GET and PUT requests, which
+// transfer whole files, are limited by transferTimeout instead, and by
+// idleTimeout, the longest time they can go without transferring a byte of
+// their body. A zero timeout doesn't limit the requests.
+func Timeout(h http.Handler, timeout, transferTimeout, idleTimeout time.Duration) http.Handler {
+	if timeout <= 0 && transferTimeout <= 0 && idleTimeout <= 0 {
+		return h
+	}
+
+	limited := h
+	if timeout > 0 {
+		limited = http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r)
+			logTimeout(r, timeout)
+		}), timeout, "Request timed out\n")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodPut:
+			serveWithDeadline(h, w, r, transferTimeout, idleTimeout)
+		case "PROPFIND":
+			// The multistatus responses can list whole trees: they are
+			// streamed too.
+			serveWithDeadline(h, w, r, timeout, 0)
+		default:
+			limited.ServeHTTP(w, r)
+		}
+	})
+}
+
+// serveWithDeadline serves a request that is canceled once the timeout
+// passes, or once it went idle for idleTimeout. Its response isn't buffered
+// as with http.TimeoutHandler, it is cut when the deadline passes instead.
+func serveWithDeadline(h http.Handler, w http.ResponseWriter, r *http.Request, timeout, idleTimeout time.Duration) {
+	if timeout <= 0 && idleTimeout <= 0 {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	d := newDeadline(r.Context(), timeout, idleTimeout, nil)
+	defer d.stop()
+
+	r = r.WithContext(d.ctx)
+	r.Body = &timeoutReader{ReadCloser: r.Body, d: d}
+	h.ServeHTTP(&timeoutWriter{ResponseWriter: w, d: d}, r)
+
+	if atomic.LoadInt32(&d.idled) == 1 {
+		logTimedOut(r, idleTimeout)
+	} else {
+		logTimeout(r, timeout)
+	}
+}
+
+// deadline cancels a request once its timeout passes, or once no byte of
+// its body was transferred for idleTimeout. onIdle, if set, is called when
+// it goes idle, before the request is canceled.
+type deadline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	idle   time.Duration
+	timer  *time.Timer
+	idled  int32
+	onIdle func()
+}
+
+func newDeadline(parent context.Context, timeout, idleTimeout time.Duration, onIdle func()) *deadline {
+	d := &deadline{idle: idleTimeout, onIdle: onIdle}
+	if timeout > 0 {
+		d.ctx, d.cancel = context.WithTimeout(parent, timeout)
+	} else {
+		d.ctx, d.cancel = context.WithCancel(parent)
+	}
+
+	if idleTimeout > 0 {
+		d.timer = time.AfterFunc(idleTimeout, func() {
+			atomic.StoreInt32(&d.idled, 1)
+			if d.onIdle != nil {
+				d.onIdle()
+			}
+			d.cancel()
+		})
+	}
+	return d
+}
+
+// touch records that bytes were transferred, postponing the idle timeout.
+func (d *deadline) touch() {
+	if d.timer != nil && d.ctx.Err() == nil {
+		d.timer.Reset(d.idle)
+	}
+}
+
+// pause stops the idle timeout until the next touch.
+func (d *deadline) pause() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+func (d *deadline) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.cancel()
+}
+
+// logTimeout logs the request if it was canceled because of its timeout.
+func logTimeout(r *http.Request, timeout time.Duration) {
+	if r.Context().Err() == context.DeadlineExceeded {
+		logTimedOut(r, timeout)
+	}
+}
+
+func logTimedOut(r *http.Request, timeout time.Duration) {
+	RequestLogger(r).Warn("request timed out",
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Duration("timeout", timeout))
+}
+
+// timeoutReader fails the reads of a request body once the deadline passed.
+type timeoutReader struct {
+	io.ReadCloser
+	d *deadline
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if r.d.ctx.Err() != nil {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.d.touch()
+	}
+	return n, err
+}
+
+// timeoutWriter answers with 503 Service Unavailable if the deadline passed
+// before the headers were written, and fails the writes after it.
+type timeoutWriter struct {
+	http.ResponseWriter
+	d           *deadline
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *timeoutWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if w.d.ctx.Err() != nil {
+		w.timedOut = true
+		// Closing the connection spares reading what is left of the body.
+		w.Header().Set("Connection", "close")
+		http.Error(w.ResponseWriter, "Request timed out", http.StatusServiceUnavailable)
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.timedOut || w.d.ctx.Err() != nil {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	if n > 0 {
+		w.d.touch()
+	}
+	return n, err
+}
+
+// errBodyIdle is returned by the reads of a request body that went idle.
+var errBodyIdle = errors.New("request body timed out")
+
+// BodyIdleTimeout wraps a handler so that the requests whose body doesn't
+// receive a byte for timeout, while the handler waits for it, are answered
+// with 408 Request Timeout and their connection is closed. ConnContext must
+// be set for the pending read of an HTTP/1 body to be interrupted, and the
+// listener wrapped by HeaderTimeoutListener for the server not to wait for
+// the client afterwards. A zero timeout doesn't limit the requests.
+func BodyIdleTimeout(h http.Handler, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// The pending read of the body is interrupted by closing it for
+		// HTTP/2, whose streams are independent, and by failing the reads
+		// of the connection for HTTP/1.
+		body := r.Body
+		conn := requestConn(r.Context())
+		d := newDeadline(r.Context(), 0, timeout, func() {
+			if r.ProtoMajor >= 2 {
+				_ = body.Close()
+			} else if hc, ok := conn.(*headerTimeoutConn); ok {
+				hc.abortReads()
+			} else if conn != nil {
+				_ = conn.SetReadDeadline(time.Unix(1, 0))
+			}
+		})
+		defer d.stop()
+
+		// The timeout only runs while the handler waits for the body.
+		d.pause()
+
+		ib := &idleBody{ReadCloser: body, d: d}
+		r = r.WithContext(d.ctx)
+		r.Body = ib
+		iw := &idleBodyWriter{ResponseWriter: w, d: d}
+		h.ServeHTTP(iw, r)
+
+		if atomic.LoadInt32(&d.idled) == 0 {
+			return
+		}
+
+		RequestLogger(r).Warn("request timed out",
+			zap.String("reason", "body idle"),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int64("received", ib.n),
+			zap.Duration("timeout", timeout))
+
+		if !iw.wroteHeader {
+			iw.WriteHeader(http.StatusRequestTimeout)
+		}
+	})
+}
+
+// idleBody fails the reads of a request body that don't receive a byte
+// for the idle timeout of its deadline, which only runs during the reads.
+type idleBody struct {
+	io.ReadCloser
+	d *deadline
+	n int64
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&b.d.idled) == 1 {
+		return 0, errBodyIdle
+	}
+
+	b.d.touch()
+	n, err := b.ReadCloser.Read(p)
+	b.d.pause()
+
+	b.n += int64(n)
+	if err != nil && atomic.LoadInt32(&b.d.idled) == 1 {
+		return n, errBodyIdle
+	}
+	return n, err
+}
+
+// idleBodyWriter answers with 408 Request Timeout once the body timed out,
+// instead of the response of the handler.
+type idleBodyWriter struct {
+	http.ResponseWriter
+	d           *deadline
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *idleBodyWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if atomic.LoadInt32(&w.d.idled) == 1 {
+		w.timedOut = true
+		w.Header().Set("Connection", "close")
+		http.Error(w.ResponseWriter, "Request body timed out", http.StatusRequestTimeout)
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *idleBodyWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.timedOut {
+		return 0, errBodyIdle
+	}
+	return w.ResponseWriter.Write(data)
+}
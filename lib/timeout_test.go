@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const bodyIdleTimeout = 50 * time.Millisecond
+
+// newBodyIdleServer serves the handler with the body idle timeout, set up
+// as the server command does.
+func newBodyIdleServer(t *testing.T, h http.HandlerFunc, tls bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(BodyIdleTimeout(h, bodyIdleTimeout))
+	server.Config.ConnContext = ConnContext
+	server.Listener = &HeaderTimeoutListener{Listener: server.Listener, Reply: !tls}
+	if tls {
+		server.EnableHTTP2 = true
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+// readAll is a handler that reads the whole body before answering.
+func readAll(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestBodyIdleTimeout(t *testing.T) {
+	server := newBodyIdleServer(t, readAll, false)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Part of the body is sent, then nothing.
+	_, err = fmt.Fprintf(conn, "PUT /file.txt HTTP/1.1\r\nHost: example.com\r\nContent-Length: 100\r\n\r\n0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusRequestTimeout)
+	}
+
+	// The response ends with the connection, which the server closes
+	// without waiting for the rest of the body.
+	if _, err := io.ReadAll(res.Body); err != nil {
+		t.Errorf("the connection wasn't closed: %s", err)
+	}
+}
+
+func TestBodyIdleTimeoutSlowHandler(t *testing.T) {
+	// The time the handler takes before reading the body doesn't count.
+	server := newBodyIdleServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(3 * bodyIdleTimeout)
+		readAll(w, r)
+	}, false)
+
+	res, err := http.Post(server.URL, "text/plain", io.LimitReader(neverEnding('x'), 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+}
+
+func TestBodyIdleTimeoutHTTP2(t *testing.T) {
+	server := newBodyIdleServer(t, readAll, true)
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		_, _ = pw.Write([]byte("0123456789"))
+	}()
+
+	r, err := http.NewRequest("PUT", server.URL+"/file.txt", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ContentLength = 100
+
+	res, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("got HTTP/%d, want HTTP/2", res.ProtoMajor)
+	}
+	if res.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusRequestTimeout)
+	}
+}
+
+// neverEnding is an endless reader of the same byte.
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Tracer batches the spans of the requests and exports them to an
+// OpenTelemetry collector with OTLP over HTTP, encoded as JSON.
+type Tracer struct {
+	// Endpoint is the URL of the collector, such as "http://localhost:4318".
+	Endpoint    string
+	ServiceName string
+	Client      *http.Client
+
+	queue chan *span
+}
+
+// span is a request being traced.
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	start    time.Time
+	end      time.Time
+	method   string
+	path     string
+	user     string
+	status   int
+	in       int64
+	out      int64
+}
+
+type spanKey struct{}
+
+// NewTracer creates a new Tracer and starts exporting spans.
+func NewTracer(endpoint, serviceName string) *Tracer {
+	t := &Tracer{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *span, 1000),
+	}
+
+	go t.run()
+	return t
+}
+
+// Trace wraps a handler so that every request is traced. The trace context
+// of the W3C traceparent header is continued, if any.
+func Trace(h http.Handler, t *Tracer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &span{
+			spanID: randomHex(8),
+			start:  time.Now(),
+			method: r.Method,
+			path:   r.URL.Path,
+		}
+
+		s.traceID, s.parentID = parseTraceparent(r.Header.Get("Traceparent"))
+		if s.traceID == "" {
+			s.traceID = randomHex(16)
+		}
+
+		rec := newResponseWriterRecorder(w)
+		body := &readCounter{ReadCloser: r.Body}
+		r.Body = body
+
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), spanKey{}, s)))
+
+		s.end = time.Now()
+		s.status = rec.status
+		if s.status == 0 {
+			s.status = http.StatusOK
+		}
+		s.in, s.out = body.n, rec.written
+
+		select {
+		case t.queue <- s:
+		default:
+			zap.L().Warn("tracing queue is full, dropping span")
+		}
+	})
+}
+
+// setSpanUser records the user of the request being traced, if any.
+func setSpanUser(r *http.Request, username string) {
+	if s, ok := r.Context().Value(spanKey{}).(*span); ok {
+		s.user = username
+	}
+}
+
+// parseTraceparent returns the trace and parent span IDs of a traceparent
+// header such as "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceparent(header string) (string, string) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+
+	for _, id := range parts[1:3] {
+		if _, err := hex.DecodeString(id); err != nil || strings.Trim(id, "0") == "" {
+			return "", ""
+		}
+	}
+
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// run exports the spans in batches, at least every 5 seconds.
+func (t *Tracer) run() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	batch := []*span{}
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) < 100 {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if err := t.export(batch); err != nil {
+			zap.L().Warn("exporting spans failed", zap.Int("spans", len(batch)), zap.Error(err))
+		}
+		batch = []*span{}
+	}
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"stringValue": value}}
+}
+
+func intAttribute(key string, value int64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}}
+}
+
+func (t *Tracer) export(batch []*span) error {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, s := range batch {
+		attributes := []otlpAttribute{
+			stringAttribute("http.request.method", s.method),
+			stringAttribute("url.path", s.path),
+			intAttribute("http.response.status_code", int64(s.status)),
+			intAttribute("http.request.body.size", s.in),
+			intAttribute("http.response.body.size", s.out),
+		}
+		if s.user != "" {
+			attributes = append(attributes, stringAttribute("enduser.id", s.user))
+		}
+
+		// Status codes: 0 is unset, 2 is error.
+		status := 0
+		if s.status >= 500 {
+			status = 2
+		}
+
+		spans = append(spans, map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"parentSpanId":      s.parentID,
+			"name":              s.method,
+			"kind":              2, // Server.
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attributes,
+			"status":            map[string]interface{}{"code": status},
+		})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{stringAttribute("service.name", t.ServiceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/hacdias/webdav"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := t.Client.Post(t.Endpoint+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// TransferInfo describes a request in progress.
+type TransferInfo struct {
+	ID     string `json:"id"`
+	User   string `json:"user"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// BytesTransferred is the number of bytes received for the uploads,
+	// and sent for the other requests.
+	BytesTransferred int64 `json:"bytes_transferred"`
+	// Total is the expected number of bytes, or -1 if unknown.
+	Total      int64     `json:"total"`
+	StartedAt  time.Time `json:"started_at"`
+	RemoteAddr string    `json:"remote_addr"`
+}
+
+// transfer is a request in progress.
+type transfer struct {
+	info   TransferInfo
+	upload bool
+	body   *readCounter
+	rec    *responseWriterRecorder
+	cancel context.CancelFunc
+}
+
+// snapshot returns the current state of the transfer.
+func (t *transfer) snapshot() TransferInfo {
+	info := t.info
+	if t.upload {
+		info.BytesTransferred = atomic.LoadInt64(&t.body.n)
+	} else {
+		info.BytesTransferred = atomic.LoadInt64(&t.rec.written)
+		info.Total = atomic.LoadInt64(&t.rec.total)
+	}
+	return info
+}
+
+// trackTransfer records a request in progress, until the returned function
+// is called. Its ID is the one of the request, unless another request
+// already has it.
+func (c *Config) trackTransfer(r *http.Request, u *User, body *readCounter, rec *responseWriterRecorder, cancel context.CancelFunc) func() {
+	t := &transfer{
+		info: TransferInfo{
+			User:       u.Username,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Total:      -1,
+			StartedAt:  time.Now(),
+			RemoteAddr: r.RemoteAddr,
+		},
+		upload: r.Method == "PUT" || r.Method == "PATCH" || r.Method == "POST",
+		body:   body,
+		rec:    rec,
+		cancel: cancel,
+	}
+	if t.upload {
+		t.info.Total = requestTotal(r)
+	}
+
+	c.transfersMu.Lock()
+	if c.transfers == nil {
+		c.transfers = map[string]*transfer{}
+	}
+	id := GetRequestID(r.Context())
+	for _, taken := c.transfers[id]; id == "" || taken; _, taken = c.transfers[id] {
+		id = randomHex(8)
+	}
+	t.info.ID = id
+	c.transfers[id] = t
+	c.transfersMu.Unlock()
+
+	return func() {
+		c.transfersMu.Lock()
+		delete(c.transfers, id)
+		c.transfersMu.Unlock()
+	}
+}
+
+// ActiveTransfers returns the requests in progress, from the oldest.
+func (c *Config) ActiveTransfers() []TransferInfo {
+	c.transfersMu.Lock()
+	transfers := make([]TransferInfo, 0, len(c.transfers))
+	for _, t := range c.transfers {
+		transfers = append(transfers, t.snapshot())
+	}
+	c.transfersMu.Unlock()
+
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].StartedAt.Before(transfers[j].StartedAt)
+	})
+	return transfers
+}
+
+// CancelTransfer cancels the context of the request in progress with the
+// given ID, which makes its body reads and writes fail. It returns false
+// if there is no such request.
+func (c *Config) CancelTransfer(id string) bool {
+	c.transfersMu.Lock()
+	t, ok := c.transfers[id]
+	c.transfersMu.Unlock()
+
+	if ok {
+		t.cancel()
+	}
+	return ok
+}
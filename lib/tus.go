@@ -0,0 +1,412 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TusVersion is the version of the tus protocol supported.
+const TusVersion = "1.0.0"
+
+// Tus implements the tus resumable upload protocol (https://tus.io) on
+// Path. Clients create an upload, send its content in as many PATCH
+// requests as needed, resuming from the offset the server reports after an
+// interruption, and the file is written to the scope of the user once
+// complete.
+type Tus struct {
+	// Path is the URL path of the endpoint, such as "/.tus/".
+	Path string
+	// Dir is the directory of the incomplete uploads.
+	Dir string
+	// Expiration is how long an incomplete upload is kept after the last
+	// bytes it received.
+	Expiration time.Duration
+	// MaxSize, if positive, is the length of the largest upload.
+	MaxSize int64
+
+	locks pathLocks
+}
+
+// tusUpload describes an upload. Its offset is the size of its data file.
+type tusUpload struct {
+	User   string `json:"user"`
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// NewTus creates the directory of the incomplete uploads, and the Tus
+// endpoint at urlPath.
+func NewTus(urlPath, dir string, expiration time.Duration, maxSize int64) (*Tus, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Tus{
+		Path:       strings.TrimSuffix(path.Clean("/"+urlPath), "/") + "/",
+		Dir:        dir,
+		Expiration: expiration,
+		MaxSize:    maxSize,
+	}, nil
+}
+
+// maxSize returns the length of the largest upload, the smallest of MaxSize
+// and the MaxUploadSize of the configuration, or 0 if there is no limit.
+func (t *Tus) maxSize(c *Config) int64 {
+	max := t.MaxSize
+	if c.MaxUploadSize > 0 && (max <= 0 || c.MaxUploadSize < max) {
+		max = c.MaxUploadSize
+	}
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
+// match checks if an URL path is handled by the endpoint.
+func (t *Tus) match(p string) bool {
+	return p == strings.TrimSuffix(t.Path, "/") || strings.HasPrefix(p, t.Path)
+}
+
+func (t *Tus) serve(c *Config, w http.ResponseWriter, r *http.Request, u *User) {
+	h := w.Header()
+	h.Set("Tus-Resumable", TusVersion)
+
+	if r.Method == "OPTIONS" {
+		h.Set("Tus-Version", TusVersion)
+		h.Set("Tus-Extension", "creation,expiration,termination")
+		if max := t.maxSize(c); max > 0 {
+			h.Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		h.Set("Tus-Version", TusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(t.Path, "/")), "/")
+	if id == "" {
+		if r.Method != "POST" {
+			h.Set("Allow", "OPTIONS, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		t.create(c, w, r, u)
+		return
+	}
+
+	if !isHex(id) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	defer t.locks.lock(id)()
+
+	upload, offset, ok := t.load(id, u)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "HEAD":
+		h.Set("Cache-Control", "no-store")
+		h.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		h.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		h.Set("Upload-Expires", t.expires(id).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		t.patch(c, w, r, u, id, upload, offset)
+	case "DELETE":
+		t.remove(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		h.Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// create creates an upload, whose destination is the "path" of the
+// Upload-Metadata header, or its "filename" at the root.
+func (t *Tus) create(c *Config, w http.ResponseWriter, r *http.Request, u *User) {
+	t.reap()
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if max := t.maxSize(c); max > 0 && length > max {
+		RequestLogger(r).Info("upload too large", zap.String("path", r.URL.Path), zap.Int64("size", length))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	name := metadata["path"]
+	if name == "" && metadata["filename"] != "" {
+		name = path.Base(metadata["filename"])
+	}
+	if name == "" {
+		http.Error(w, "Missing path or filename metadata", http.StatusBadRequest)
+		return
+	}
+
+	upload := tusUpload{User: u.Username, Path: path.Clean("/" + name), Length: length}
+	if !u.Allowed(tusURL(u, upload.Path), false) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	id := randomHex(16)
+	info, err := json.Marshal(upload)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(t.Dir, id+".json"), info, 0600)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(t.Dir, id), nil, 0600)
+	}
+	if err != nil {
+		RequestLogger(r).Error("creating upload failed", zap.String("path", upload.Path), zap.Error(err))
+		t.remove(id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", t.Path+id)
+	w.Header().Set("Upload-Expires", t.expires(id).UTC().Format(http.TimeFormat))
+
+	if length == 0 {
+		if status := t.complete(c, r, u, id, upload); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// patch appends the body to the upload, at the offset it must give, and
+// writes the file once the upload is complete. The bytes received before
+// an interruption are kept.
+func (t *Tus) patch(c *Config, w http.ResponseWriter, r *http.Request, u *User, id string, upload tusUpload, offset int64) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	requested, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if requested != offset {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// The limit may have been lowered since the upload was created, and the
+	// body must not go past it either.
+	if max := t.maxSize(c); max > 0 && (upload.Length > max || offset+requestTotal(r) > max) {
+		RequestLogger(r).Info("upload too large", zap.String("path", upload.Path), zap.Int64("size", upload.Length))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	f, err := os.OpenFile(filepath.Join(t.Dir, id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-offset))
+	closeErr := f.Close()
+	offset += n
+
+	if copyErr != nil || closeErr != nil {
+		RequestLogger(r).Info("upload interrupted", zap.String("path", upload.Path), zap.Int64("offset", offset), zap.Error(copyErr))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Expires", t.expires(id).UTC().Format(http.TimeFormat))
+
+	if offset == upload.Length {
+		if status := t.complete(c, r, u, id, upload); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete writes a complete upload to the scope of the user, and removes
+// it. It returns the status to answer with if that failed, in which case
+// the upload is kept, to be retried with an empty PATCH request.
+func (t *Tus) complete(c *Config, r *http.Request, u *User, id string, upload tusUpload) int {
+	if !u.Allowed(tusURL(u, upload.Path), false) {
+		return http.StatusForbidden
+	}
+
+	src, err := os.Open(filepath.Join(t.Dir, id))
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	defer src.Close()
+
+	_, statErr := u.Handler.FileSystem.Stat(r.Context(), upload.Path)
+	existed := statErr == nil
+
+	dst, err := u.Handler.FileSystem.OpenFile(r.Context(), upload.Path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err == nil {
+		_, err = io.Copy(dst, src)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		RequestLogger(r).Error("writing upload failed", zap.String("path", upload.Path), zap.Error(err))
+		switch {
+		case isNoSpace(err):
+			return http.StatusInsufficientStorage
+		case os.IsPermission(err):
+			return http.StatusForbidden
+		case os.IsNotExist(err):
+			return http.StatusConflict
+		default:
+			return http.StatusInternalServerError
+		}
+	}
+
+	t.remove(id)
+
+	e := Event{Type: EventCreated, Path: tusURL(u, upload.Path), Size: upload.Length, User: u.Username, Time: time.Now()}
+	if existed {
+		e.Type = EventModified
+	}
+	c.emit(e)
+	e.Type = EventUploadComplete
+	c.emit(e)
+
+	return 0
+}
+
+// load returns the upload with the given ID and its offset, if it exists,
+// hasn't expired and belongs to the user.
+func (t *Tus) load(id string, u *User) (tusUpload, int64, bool) {
+	var upload tusUpload
+
+	data, err := os.ReadFile(filepath.Join(t.Dir, id+".json"))
+	if err != nil || json.Unmarshal(data, &upload) != nil || upload.User != u.Username {
+		return upload, 0, false
+	}
+
+	info, err := os.Stat(filepath.Join(t.Dir, id))
+	if err != nil {
+		return upload, 0, false
+	}
+
+	if t.Expiration > 0 && time.Now().After(info.ModTime().Add(t.Expiration)) {
+		t.remove(id)
+		return upload, 0, false
+	}
+
+	return upload, info.Size(), true
+}
+
+// expires returns when an upload expires.
+func (t *Tus) expires(id string) time.Time {
+	info, err := os.Stat(filepath.Join(t.Dir, id))
+	if err != nil || t.Expiration <= 0 {
+		return time.Time{}
+	}
+	return info.ModTime().Add(t.Expiration)
+}
+
+func (t *Tus) remove(id string) {
+	_ = os.Remove(filepath.Join(t.Dir, id))
+	_ = os.Remove(filepath.Join(t.Dir, id+".json"))
+}
+
+// reap removes the expired uploads.
+func (t *Tus) reap() {
+	if t.Expiration <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(t.Dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".json")
+		if id == entry.Name() || !isHex(id) {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(t.Dir, id))
+		if err != nil || time.Now().After(info.ModTime().Add(t.Expiration)) {
+			unlock := t.locks.lock(id)
+			t.remove(id)
+			unlock()
+		}
+	}
+}
+
+// tusURL returns the URL path of a path in the scope of the user.
+func tusURL(u *User, name string) string {
+	return strings.TrimSuffix(u.Handler.Prefix, "/") + name
+}
+
+// parseTusMetadata parses the Upload-Metadata header, comma separated keys
+// followed by their value in base64.
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+
+		value := ""
+		if len(fields) > 1 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
+}
+
+// isHex checks if s is a non-empty string of hexadecimal digits.
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, ch := range s {
+		if !('0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'f') {
+			return false
+		}
+	}
+	return true
+}
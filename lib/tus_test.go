@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTusConfig returns a test configuration accepting the tus uploads.
+func newTusConfig(t *testing.T, maxUploadSize, tusMaxSize int64) (*Config, string) {
+	t.Helper()
+
+	c, dir := newTestConfig(t)
+	c.MaxUploadSize = maxUploadSize
+
+	var err error
+	c.Tus, err = NewTus("/.tus/", t.TempDir(), 0, tusMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, dir
+}
+
+// createTusUpload creates a tus upload to /file.txt and returns the
+// response.
+func createTusUpload(c *Config, length int) (int, string) {
+	w := serve(c, "POST", "/.tus/", nil,
+		"Tus-Resumable", TusVersion,
+		"Upload-Length", strconv.Itoa(length),
+		"Upload-Metadata", "path L2ZpbGUudHh0")
+	return w.Code, w.Header().Get("Location")
+}
+
+func patchTusUpload(c *Config, location string, offset int, content string) int {
+	return serve(c, "PATCH", location, strings.NewReader(content),
+		"Tus-Resumable", TusVersion,
+		"Content-Type", "application/offset+octet-stream",
+		"Upload-Offset", strconv.Itoa(offset)).Code
+}
+
+func TestTusMaxUploadSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxUploadSize int64
+		tusMaxSize    int64
+		length        int
+		status        int
+	}{
+		{"no limit", 0, 0, 20, http.StatusCreated},
+		{"below max_upload_size", 20, 0, 20, http.StatusCreated},
+		{"past max_upload_size", 20, 0, 21, http.StatusRequestEntityTooLarge},
+		{"past max_upload_size below tus_max_size", 20, 100, 21, http.StatusRequestEntityTooLarge},
+		{"past tus_max_size below max_upload_size", 100, 20, 21, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		c, dir := newTusConfig(t, tt.maxUploadSize, tt.tusMaxSize)
+
+		status, location := createTusUpload(c, tt.length)
+		if status != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, status, tt.status)
+			continue
+		}
+		if status != http.StatusCreated {
+			continue
+		}
+
+		content := strings.Repeat("x", tt.length)
+		if status := patchTusUpload(c, location, 0, content); status != http.StatusNoContent {
+			t.Errorf("%s: PATCH: got status %d, want %d", tt.name, status, http.StatusNoContent)
+		}
+		if readTestFile(t, dir, "file.txt") != content {
+			t.Errorf("%s: the file wasn't written", tt.name)
+		}
+	}
+}
+
+func TestTusMaxSizeAdvertised(t *testing.T) {
+	c, _ := newTusConfig(t, 20, 100)
+
+	w := serve(c, "OPTIONS", "/.tus/", nil)
+	if got := w.Header().Get("Tus-Max-Size"); got != "20" {
+		t.Errorf("got Tus-Max-Size %q, want 20", got)
+	}
+}
+
+func TestTusMaxUploadSizeLowered(t *testing.T) {
+	c, dir := newTusConfig(t, 0, 0)
+
+	status, location := createTusUpload(c, 30)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", status, http.StatusCreated)
+	}
+	if status := patchTusUpload(c, location, 0, strings.Repeat("x", 10)); status != http.StatusNoContent {
+		t.Fatalf("PATCH: got status %d, want %d", status, http.StatusNoContent)
+	}
+
+	// An upload created before the limit was lowered can't be completed.
+	c.MaxUploadSize = 20
+	if status := patchTusUpload(c, location, 10, strings.Repeat("x", 20)); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("PATCH: got status %d, want %d", status, http.StatusRequestEntityTooLarge)
+	}
+	if readTestFile(t, dir, "file.txt") != "" {
+		t.Errorf("the file was written")
+	}
+}
@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// unauthorized answers a request that wasn't authenticated. Browsers, that
+// is GET requests accepting HTML, are redirected to UnauthorizedRedirect or
+// get the UnauthorizedPage, without the WWW-Authenticate challenge that
+// would make them prompt for credentials, unless the URL has the login
+// query parameter. The other clients get the challenge.
+func (c *Config) unauthorized(w http.ResponseWriter, r *http.Request) {
+	_, login := r.URL.Query()["login"]
+	if !isBrowser(r) || login || (c.UnauthorizedRedirect == "" && c.UnauthorizedPage == nil) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Del("WWW-Authenticate")
+
+	if c.UnauthorizedRedirect != "" {
+		target := c.UnauthorizedRedirect
+		if strings.Contains(target, "?") {
+			target += "&"
+		} else {
+			target += "?"
+		}
+		http.Redirect(w, r, target+"next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write(c.UnauthorizedPage)
+}
+
+// isBrowser checks if a request comes from a browser navigating to a page.
+func isBrowser(r *http.Request) bool {
+	return (r.Method == "GET" || r.Method == "HEAD") && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
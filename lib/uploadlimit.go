@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// errUploadTooLarge is returned by the reads of an upload body past the
+// largest upload size.
+var errUploadTooLarge = errors.New("upload too large")
+
+// limitUpload checks the size of a PUT request against MaxUploadSize. The
+// requests announcing a larger size, with Content-Length or, for chunked
+// bodies, X-Expected-Entity-Length, are refused at once and it returns
+// 413 Request Entity Too Large. Otherwise, the body fails once it passes
+// the limit, and the response is replaced by a 413, since bodies without
+// announced size can't be checked beforehand.
+func (c *Config) limitUpload(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, int) {
+	if c.MaxUploadSize <= 0 {
+		return w, 0
+	}
+
+	if requestTotal(r) > c.MaxUploadSize {
+		RequestLogger(r).Info("upload too large", zap.String("path", r.URL.Path), zap.Int64("size", requestTotal(r)))
+		return w, http.StatusRequestEntityTooLarge
+	}
+
+	body := &limitedBody{ReadCloser: r.Body, remaining: c.MaxUploadSize}
+	r.Body = body
+	return &tooLargeWriter{ResponseWriter: w, r: r, body: body}, 0
+}
+
+// limitedBody fails the reads past its remaining bytes. Its error, rather
+// than io.EOF, keeps the atomic writes from replacing the file.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  int32
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&b.exceeded) == 1 {
+		return 0, errUploadTooLarge
+	}
+
+	// One byte more than remaining tells a body of exactly the limit from
+	// a larger one.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		atomic.StoreInt32(&b.exceeded, 1)
+		n = int(b.remaining)
+		err = errUploadTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// tooLargeWriter answers 413 Request Entity Too Large instead of the error
+// the WebDAV handler answers with when the body passed the limit.
+type tooLargeWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	body    *limitedBody
+	replied bool
+}
+
+func (w *tooLargeWriter) WriteHeader(statusCode int) {
+	if statusCode < 400 || atomic.LoadInt32(&w.body.exceeded) == 0 {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.replied = true
+	RequestLogger(w.r).Info("upload too large", zap.String("path", w.r.URL.Path))
+	// The rest of the body isn't read.
+	w.Header().Set("Connection", "close")
+	http.Error(w.ResponseWriter, "Request entity too large", http.StatusRequestEntityTooLarge)
+}
+
+func (w *tooLargeWriter) Write(data []byte) (int, error) {
+	if w.replied {
+		// The body of the error the handler answered with.
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// chunkedPut sends a PUT request whose body is encoded with the chunked
+// Transfer-Encoding, in chunks of chunkSize bytes, with the given extra
+// header lines, and returns the response.
+func chunkedPut(t *testing.T, server *httptest.Server, target string, content []byte, chunkSize int, header string) *http.Response {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n%s\r\n", target, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server may answer before the whole body is sent, so it is sent
+	// concurrently, ignoring the errors of the writes once it closed the
+	// connection.
+	go func() {
+		cw := httputil.NewChunkedWriter(conn)
+		for len(content) != 0 {
+			n := chunkSize
+			if n > len(content) {
+				n = len(content)
+			}
+			if _, err := cw.Write(content[:n]); err != nil {
+				return
+			}
+			content = content[n:]
+		}
+		_ = cw.Close()
+		_, _ = io.WriteString(conn, "\r\n")
+	}()
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return res
+}
+
+// newUploadServer serves a test configuration with the atomic writes and
+// the given largest upload size.
+func newUploadServer(t *testing.T, maxUploadSize int64) (*httptest.Server, string) {
+	t.Helper()
+
+	c, dir := newTestConfig(t)
+	c.MaxUploadSize = maxUploadSize
+	c.User.Handler.FileSystem = WebDavDir{FileSystem: AtomicFS{FileSystem: webdav.Dir(dir)}}
+
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+	return server, dir
+}
+
+// checkNoTempFiles checks that no temporary file of the atomic writes was
+// left in the directory.
+func checkNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), atomicPrefix) {
+			t.Errorf("temporary file %s was left", entry.Name())
+		}
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	server, dir := newUploadServer(t, 0)
+	content := mediaContent(1 << 20)
+
+	// Neither Content-Length nor X-Expected-Entity-Length: the body is read
+	// to its end.
+	res := chunkedPut(t, server, "/file.bin", content, 4000, "")
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusCreated)
+	}
+	if !bytes.Equal([]byte(readTestFile(t, dir, "file.bin")), content) {
+		t.Errorf("the file differs from the upload")
+	}
+	checkNoTempFiles(t, dir)
+}
+
+func TestChunkedUploadLimit(t *testing.T) {
+	const limit = 100000
+
+	tests := []struct {
+		name   string
+		size   int
+		header string
+		status int
+	}{
+		{"below the limit", limit - 1, "", http.StatusNoContent},
+		{"at the limit", limit, "", http.StatusNoContent},
+		{"one byte past the limit", limit + 1, "", http.StatusRequestEntityTooLarge},
+		{"far past the limit", 10 * limit, "", http.StatusRequestEntityTooLarge},
+		{"announced past the limit", limit, fmt.Sprintf("X-Expected-Entity-Length: %d\r\n", limit+1), http.StatusRequestEntityTooLarge},
+		{"announced below the limit", limit + 1, fmt.Sprintf("X-Expected-Entity-Length: %d\r\n", limit), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		server, dir := newUploadServer(t, limit)
+		writeTestFile(t, dir, "file.bin", "previous version")
+
+		content := mediaContent(tt.size)
+		res := chunkedPut(t, server, "/file.bin", content, 3000, tt.header)
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, res.StatusCode, tt.status)
+			continue
+		}
+
+		want := string(content)
+		if tt.status == http.StatusRequestEntityTooLarge {
+			// Once cut, the rest of the body isn't read, so the connection
+			// is closed. Either way, the previous version is left intact.
+			if tt.header == "" && !res.Close {
+				t.Errorf("%s: the connection is kept open", tt.name)
+			}
+			want = "previous version"
+		}
+		if readTestFile(t, dir, "file.bin") != want {
+			t.Errorf("%s: the file isn't the expected version", tt.name)
+		}
+		checkNoTempFiles(t, dir)
+	}
+}
+
+func TestUploadLimitContentLength(t *testing.T) {
+	server, dir := newUploadServer(t, 10)
+
+	r, err := http.NewRequest("PUT", server.URL+"/file.txt", strings.NewReader("more than ten bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d", res.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	if readTestFile(t, dir, "file.txt") != "" {
+		t.Errorf("the file was created")
+	}
+}
@@ -3,6 +3,7 @@ package lib
 import (
 	"regexp"
 	"strings"
+	"sync"
 
 	"golang.org/x/net/webdav"
 )
@@ -23,7 +24,28 @@ type User struct {
 	Scope    string
 	Modify   bool
 	Rules    []*Rule
+	Mounts   []Mount
 	Handler  *webdav.Handler
+
+	// ReadOnly refuses the requests modifying the files of the user, such
+	// as for the scopes of a single file.
+	ReadOnly bool
+
+	// WriteWindows, if set, are the only times the user can modify the
+	// files.
+	WriteWindows []TimeWindow
+
+	// LogTag, if set, tags the log entries of the requests of the user
+	// instead of the username, such as to route them.
+	LogTag string
+}
+
+// logTag returns the tag of the log entries of the requests of the user.
+func (u User) logTag() string {
+	if u.LogTag != "" {
+		return u.LogTag
+	}
+	return u.Username
 }
 
 // Allowed checks if the user has permission to access a directory/file
@@ -48,3 +70,81 @@ func (u User) Allowed(url string, noModification bool) bool {
 
 	return noModification || u.Modify
 }
+
+// UserFactory creates the users authenticated by external services, which
+// aren't in the users list. Users are kept while their settings don't
+// change, so that their handler, and its locks, survive across requests.
+type UserFactory struct {
+	// New creates a user with the given scope and permissions. A read only
+	// user must have ReadOnly set, which refuses every method modifying the
+	// files, unlike Modify.
+	New func(username, scope string, modify, readOnly bool) (*User, error)
+
+	// Default is the user whose settings are used when they aren't given.
+	Default *User
+
+	// DefaultScope, if set, returns the scope of the users for which none
+	// is given, instead of the one of the default user.
+	DefaultScope func(username string) (string, error)
+
+	mu    sync.Mutex
+	users map[string]factoryUser
+}
+
+// factoryUser is a user created by a UserFactory, with the settings it was
+// created with.
+type factoryUser struct {
+	*User
+	readOnly bool
+}
+
+// Get returns the user with the given settings. An empty scope uses the one
+// of the default user. A nil readOnly uses the permissions of the default
+// user, while false allows the user to modify the files.
+func (f *UserFactory) Get(username, scope string, readOnly *bool) (*User, error) {
+	if scope == "" && f.DefaultScope != nil {
+		var err error
+		scope, err = f.DefaultScope(username)
+		if err != nil {
+			return nil, err
+		}
+	} else if scope == "" {
+		scope = f.Default.Scope
+	}
+
+	modify, ro := f.Default.Modify, false
+	if readOnly != nil {
+		modify, ro = !*readOnly, *readOnly
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.users == nil {
+		f.users = map[string]factoryUser{}
+	}
+
+	user, ok := f.users[username]
+	if !ok || user.Scope != scope || user.Modify != modify || user.readOnly != ro {
+		u, err := f.New(username, scope, modify, ro)
+		if err != nil {
+			return nil, err
+		}
+		user = factoryUser{User: u, readOnly: ro}
+		f.users[username] = user
+	}
+
+	return user.User, nil
+}
+
+// all returns the users created so far.
+func (f *UserFactory) all() []*User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users := make([]*User, 0, len(f.users))
+	for _, u := range f.users {
+		users = append(users, u.User)
+	}
+	return users
+}
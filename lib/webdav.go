@@ -2,8 +2,16 @@ package lib
 
 import (
 	"context"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -21,15 +29,173 @@ type CorsCfg struct {
 // Config is the configuration of a WebDAV instance.
 type Config struct {
 	*User
-	Auth      bool
-	NoSniff   bool
-	Cors      CorsCfg
+	Auth    bool
+	NoSniff bool
+	Cors    CorsCfg
+	// MimeTypes maps extensions, such as ".md", to the types that override
+	// the default ones.
+	MimeTypes map[string]string
 	Users     map[string]*User
 	LogFormat string
+	Symlinks  string
+
+	// EncryptionKey, if set, enables encryption at rest of the files.
+	EncryptionKey []byte
+	EncryptNames  bool
+
+	// AtomicWrites makes the uploads to directory scopes replace the files
+	// only once they are complete.
+	AtomicWrites bool
+
+	// FileMode and DirMode, if set, are the permissions of the files and
+	// directories created in directory scopes, and FileGroup, if positive,
+	// their group ID.
+	FileMode  os.FileMode
+	DirMode   os.FileMode
+	FileGroup int
+
+	// CaseInsensitive resolves the paths against the existing files case
+	// insensitively.
+	CaseInsensitive bool
+
+	// HidePatterns are the glob patterns of the files that are hidden from
+	// the clients. If AllowWriteHidden is set, they can still be written.
+	HidePatterns     []string
+	AllowWriteHidden bool
+
+	// NormalizeFilenames is the Unicode normalization of the file names,
+	// one of the Normalize constants. Empty means NormalizeNone.
+	NormalizeFilenames string
+
+	// S3 is used to connect to the buckets of "s3://" scopes.
+	S3 S3Config
+
+	// Webhook, if set, is notified of the changes made to the files.
+	Webhook *Webhook
+
+	// OnEvent, if set, is called with the changes made to the files, and
+	// when uploads and downloads complete.
+	OnEvent func(Event)
+
+	// TrustedProxies are the networks of the reverse proxies whose
+	// X-Forwarded-For and X-Forwarded-Proto headers are honored.
+	TrustedProxies []*net.IPNet
+
+	// OnProgress, if set, is notified of the progress of uploads and
+	// downloads.
+	OnProgress ProgressFunc
+
+	// MaxPropfindDepth is the deepest Depth allowed for PROPFIND requests,
+	// one of the Depth constants. Empty means DepthInfinity.
+	MaxPropfindDepth string
+	// MaxPropfindEntries, if positive, is the largest number of entries a
+	// PROPFIND request can list.
+	MaxPropfindEntries int
+
+	// MaxLockTimeout, if positive, caps the timeout of the locks, and
+	// OnLock, if set, is notified when locks are taken and released.
+	MaxLockTimeout time.Duration
+	OnLock         LockFunc
+
+	// OnStopping, if set, is called when Drain starts, before the active
+	// requests finish, and OnStop once they did and the listener is closed.
+	OnStopping func()
+	OnStop     func()
+
+	// DavCompliance, if set, overrides the DAV compliance classes advertised
+	// in the responses to OPTIONS requests.
+	DavCompliance string
+
+	// AuthWebhook, if set, authenticates the users instead of Users.
+	AuthWebhook *AuthWebhook
+
+	// JWT, if set, authenticates the requests with a bearer token, in
+	// addition to the Basic authentication.
+	JWT *JWTAuth
+
+	// Shares, if set, enables the share links.
+	Shares *Shares
+
+	// DirListing enables the HTML listing of the directories on GET
+	// requests, instead of answering them as PROPFIND. Dotfiles are only
+	// listed if ShowHidden is set.
+	DirListing bool
+	ShowHidden bool
+
+	// ForceDownload answers the GET requests on files as attachments, which
+	// browsers save instead of displaying. Otherwise, only the requests with
+	// the "download" query parameter are.
+	ForceDownload bool
+
+	// UnauthorizedPage, if set, is the HTML page answered to the browsers
+	// that aren't authenticated, instead of the credentials prompt.
+	UnauthorizedPage []byte
+
+	// UnauthorizedRedirect, if set, is where the browsers that aren't
+	// authenticated are redirected to, with the URL they requested in the
+	// next query parameter. It takes precedence over UnauthorizedPage.
+	UnauthorizedRedirect string
+
+	// BrowserFiles answers the GET requests browsers make on their own
+	// before the authentication, out of the WebDAV namespace: /favicon.ico
+	// with 204 No Content, and /robots.txt with Robots, or DefaultRobots
+	// if it is empty.
+	BrowserFiles bool
+	Robots       []byte
+
+	// MaxUploadSize, if positive, is the size of the largest PUT request
+	// body, including the chunked bodies of unknown size.
+	MaxUploadSize int64
+
+	// PartialUpdates enables the PATCH requests updating a part of a file,
+	// in the format of SabreDAV.
+	PartialUpdates bool
+
+	// DecompressUploads decompresses the bodies of the PUT requests sent
+	// with the gzip or deflate Content-Encoding, which are otherwise stored
+	// as they are. Other encodings are refused.
+	DecompressUploads bool
+
+	// Thumbnails, if set, answers the GET requests on images with the thumb
+	// query parameter with their thumbnail.
+	Thumbnails *Thumbnails
+
+	// Tus, if set, serves the tus resumable uploads on its path.
+	Tus *Tus
+
+	// ScopeTemplate, if set, is the scope of the users that have none,
+	// where {user} is replaced by the username.
+	ScopeTemplate string
+
+	// Location is the time zone of the write windows of the users. Nil
+	// means the local time zone.
+	Location *time.Location
+
+	active      int64
+	maintenance int32
+	readOnly    int32
+	usersMu     sync.RWMutex
+	statsMu     sync.Mutex
+	stats       map[string]*UserStat
+	putLocks    pathLocks
+	caseCache   caseCache
+	transfersMu sync.Mutex
+	transfers   map[string]*transfer
 }
 
 // ServeHTTP determines if the request is for this plugin, and if all prerequisites are met.
 func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&c.active, 1)
+	defer atomic.AddInt64(&c.active, -1)
+
+	if c.Maintenance() {
+		serveMaintenance(w)
+		return
+	}
+
+	c.applyForwarded(r)
+	r.URL.Path = c.normalizePath(r.URL.Path)
+
 	u := c.User
 	requestOrigin := r.Header.Get("Origin")
 
@@ -68,44 +234,135 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == CapabilitiesPath {
+		c.serveCapabilities(w, r)
+		return
+	}
+
+	if c.serveBrowserFile(w, r) {
+		return
+	}
+
 	// Authentication
-	if c.Auth {
-		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+	if token := r.URL.Query().Get("token"); c.Shares != nil && token != "" {
+		// Share links skip the authentication and are served as the default
+		// user, only for the shared path.
+		readOnly, err := c.Shares.Verify(token, r.URL.Path)
+		if err == nil && !shareAllowed(r.Method, readOnly) {
+			err = os.ErrPermission
+		}
 
-		// Gets the correct user for this request.
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			zap.L().Info("user not provided", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
-			http.Error(w, "Not authorized", 401)
+		if err != nil {
+			RequestLogger(r).Info("share link rejected", zap.String("path", r.URL.Path), zap.String("remote_address", r.RemoteAddr), zap.Error(err))
+			w.WriteHeader(http.StatusForbidden)
 			return
 		}
-
-		user, ok := c.Users[username]
-		if !ok {
-			zap.L().Info("user not exist", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+	} else if c.Auth && c.JWT != nil && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		user, err := c.JWT.Authenticate(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), c.user)
+		if err != nil {
+			RequestLogger(r).Info("invalid token", zap.String("remote_address", r.RemoteAddr), zap.Error(err))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="Restricted", error="invalid_token"`)
 			http.Error(w, "Not authorized", 401)
 			return
 		}
 
-		if !checkPassword(user.Password, password) {
-			zap.L().Info("invalid password", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
-			http.Error(w, "Not authorized", 401)
+		u = user
+	} else if c.Auth {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		if c.JWT != nil {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="Restricted"`)
+		}
+
+		// Gets the correct user for this request.
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			RequestLogger(r).Info("user not provided", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+			c.unauthorized(w, r)
 			return
 		}
 
-		u = user
+		if c.AuthWebhook != nil {
+			user, err := c.AuthWebhook.Authenticate(username, password)
+			if err != nil {
+				RequestLogger(r).Info("authentication rejected", zap.String("username", username), zap.String("remote_address", r.RemoteAddr), zap.Error(err))
+				c.unauthorized(w, r)
+				return
+			}
+
+			u = user
+		} else {
+			user, ok := c.user(username)
+			if !ok {
+				RequestLogger(r).Info("user not exist", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+				c.unauthorized(w, r)
+				return
+			}
+
+			if !checkPassword(user.Password, password) {
+				RequestLogger(r).Info("invalid password", zap.String("username", username), zap.String("remote_address", r.RemoteAddr))
+				c.unauthorized(w, r)
+				return
+			}
+
+			u = user
+		}
 	} else {
 		// Even if Auth is disabled, we might want to get
 		// the user from the Basic Auth header. Useful for Caddy
 		// plugin implementation.
 		username, _, ok := r.BasicAuth()
 		if ok {
-			if user, ok := c.Users[username]; ok {
+			if user, ok := c.user(username); ok {
 				u = user
 			}
 		}
 	}
 
+	setSpanUser(r, u.Username)
+	setLogTag(r, u.logTag())
+
+	if isWriteMethod(r.Method) {
+		if c.ReadOnly() || u.ReadOnly {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if !u.CanWriteAt(c.now()) {
+			RequestLogger(r).Info("write outside of the write windows", zap.String("username", u.Username), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	// The tus uploads are counted in the statistics of the user too.
+	if c.Tus != nil && c.Tus.match(r.URL.Path) {
+		rec := newResponseWriterRecorder(w)
+		body := &readCounter{ReadCloser: r.Body}
+		r.Body = body
+		c.Tus.serve(c, rec, r, u)
+		c.recordStats(u.Username, body.n, rec.written)
+		return
+	}
+
+	// Paths are resolved to the case of the existing files before checking
+	// the rules, which could be bypassed otherwise.
+	if c.CaseInsensitive {
+		p, err := c.resolveURLCase(r.Context(), u, r.URL.Path)
+		if err == nil && r.Header.Get("Destination") != "" {
+			var dst string
+			if dst, err = c.resolveDestinationCase(r.Context(), u, r.Header.Get("Destination"), p); err == nil {
+				r.Header.Set("Destination", dst)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		r.URL.Path = p
+	}
+
+	r.URL.Path = c.trimResourceSlash(r.Context(), u, r.Method, r.URL.Path)
+
 	// Checks for user permissions relatively to this PATH.
 	noModification := r.Method == "GET" ||
 		r.Method == "HEAD" ||
@@ -122,10 +379,58 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Paths the filesystem refuses to access, such as symbolic links that
+	// escape the scope, are reported as forbidden rather than missing.
+	if strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
+		_, err := u.Handler.FileSystem.Stat(context.TODO(), strings.TrimPrefix(r.URL.Path, u.Handler.Prefix))
+		if os.IsPermission(err) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	if r.Method == "LOCK" {
+		capLockTimeout(r.Header, c.MaxLockTimeout)
+	}
+
+	if r.Method == "COPY" || r.Method == "MOVE" {
+		if status := c.prepareCopyMove(r, u); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	rec := newResponseWriterRecorder(w)
+	rec.ctx = ctx
+	body := &readCounter{ReadCloser: r.Body, ctx: ctx}
+	r.Body = body
+	w = rec
+	defer func() {
+		c.recordStats(u.Username, body.n, rec.written)
+	}()
+	defer c.trackTransfer(r, u, body, rec, cancel)()
+
+	// The bytes counted are the ones received, before the decompression.
+	if r.Method == "PUT" && c.DecompressUploads {
+		if status := decompressBody(r); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
 	if r.Method == "HEAD" {
 		w = newResponseWriterNoBody(w)
 	}
 
+	if r.Method == "OPTIONS" && strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
+		c.handleOptions(w, r, u)
+		return
+	}
+
 	// Excerpt from RFC4918, section 9.4:
 	//
 	// 		GET, when applied to a collection, may return the contents of an
@@ -136,6 +441,11 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
 		info, err := u.Handler.FileSystem.Stat(context.TODO(), strings.TrimPrefix(r.URL.Path, u.Handler.Prefix))
 		if err == nil && info.IsDir() {
+			if c.DirListing {
+				c.serveListing(w, r, u)
+				return
+			}
+
 			r.Method = "PROPFIND"
 
 			if r.Header.Get("Depth") == "" {
@@ -144,11 +454,128 @@ func (c *Config) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if r.Method == "GET" && c.Thumbnails != nil && r.URL.Query().Get("thumb") != "" && strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
+		c.Thumbnails.serve(w, r, u, c.MimeTypes)
+		return
+	}
+
+	// The WebDAV handler lets http.ServeContent find the type of the files
+	// it serves, which keeps the one already set.
+	if r.Method == "GET" || r.Method == "HEAD" {
+		if mimeType, ok := c.MimeTypes[strings.ToLower(path.Ext(r.URL.Path))]; ok {
+			w.Header().Set("Content-Type", mimeType)
+		}
+		if c.wantsDownload(r) && !strings.HasSuffix(r.URL.Path, "/") {
+			w.Header().Set("Content-Disposition", contentDisposition(path.Base(r.URL.Path)))
+		}
+	}
+
+	if r.Method == "PROPFIND" {
+		if !c.propfindDepthAllowed(w, r) || !c.propfindEntriesAllowed(w, r, u) {
+			return
+		}
+		w = &propfindWriter{ResponseWriter: w, path: r.URL.Path, log: RequestLogger(r)}
+	}
+
+	if r.Method == "PATCH" && c.PartialUpdates && strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
+		c.servePartialUpdate(w, r, u)
+		return
+	}
+
+	// Uploads to the same file are serialized, so that the preconditions are
+	// checked against the file being replaced.
+	if r.Method == "PUT" && strings.HasPrefix(r.URL.Path, u.Handler.Prefix) {
+		var status int
+		if w, status = c.limitUpload(w, r); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, u.Handler.Prefix)
+		defer c.putLocks.lock(u.Scope + "\x00" + name)()
+
+		existed, status := putPreconditions(r.Context(), u.Handler.FileSystem, name, r.Header)
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+
+		if existed {
+			w = overwriteWriter{ResponseWriter: w}
+		}
+
+		r = withUpload(r)
+	}
+
+	switch r.Method {
+	case "PUT", "MKCOL", "COPY", "MOVE":
+		var full *int32
+		r, full = withNoSpace(r)
+		w = &noSpaceWriter{ResponseWriter: w, r: r, full: full}
+	}
+
+	if c.OnProgress != nil {
+		switch r.Method {
+		case "PUT":
+			body.progress = newProgress(c.OnProgress, r.URL.Path, requestTotal(r))
+			defer body.progress.done()
+		case "GET":
+			rec.progress = newProgress(c.OnProgress, r.URL.Path, -1)
+			defer rec.progress.done()
+		}
+	}
+
+	if c.OnEvent != nil && (r.Method == "PUT" || r.Method == "GET") {
+		start := time.Now()
+		defer func() {
+			c.sendTransferEvent(u, r, rec, body, start)
+		}()
+	}
+
 	// Runs the WebDAV.
 	//u.Handler.LockSystem = webdav.NewMemLS()
+	if c.Webhook != nil || c.OnEvent != nil {
+		existed := false
+		if r.Method == "PUT" {
+			_, err := u.Handler.FileSystem.Stat(r.Context(), strings.TrimPrefix(r.URL.Path, u.Handler.Prefix))
+			existed = err == nil
+		}
+
+		u.Handler.ServeHTTP(w, r)
+		c.sendEvent(u, r, rec.status, existed)
+		return
+	}
+
 	u.Handler.ServeHTTP(w, r)
 }
 
+// user returns the user with the given username.
+func (c *Config) user(username string) (*User, bool) {
+	c.usersMu.RLock()
+	defer c.usersMu.RUnlock()
+
+	user, ok := c.Users[username]
+	return user, ok
+}
+
+// SetUsers replaces the users. Requests that already started keep being
+// served as the user they were authenticated as. The users whose scope
+// didn't change keep the lock system of their previous version, so that
+// the locks their clients hold still protect the files.
+func (c *Config) SetUsers(users map[string]*User) {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	for username, user := range users {
+		old, ok := c.Users[username]
+		if ok && old.Scope == user.Scope && old.Handler != nil && user.Handler != nil {
+			user.Handler.LockSystem = old.Handler.LockSystem
+		}
+	}
+
+	c.Users = users
+}
+
 // responseWriterNoBody is a wrapper used to suprress the body of the response
 // to a request. Mainly used for HEAD requests.
 type responseWriterNoBody struct {
@@ -174,3 +601,75 @@ func (w responseWriterNoBody) Write(data []byte) (int, error) {
 func (w responseWriterNoBody) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 }
+
+// responseWriterRecorder is a wrapper used to record the status code and
+// the number of bytes of the response. The counts are updated atomically,
+// so that they can be read while the response is written.
+type responseWriterRecorder struct {
+	http.ResponseWriter
+	status   int
+	written  int64
+	total    int64
+	progress *progress
+	// ctx, if set, makes the writes fail once it is canceled.
+	ctx context.Context
+}
+
+// newResponseWriterRecorder creates a new responseWriterRecorder.
+func newResponseWriterRecorder(w http.ResponseWriter) *responseWriterRecorder {
+	return &responseWriterRecorder{ResponseWriter: w, total: -1}
+}
+
+// WriteHeader records the status code and writes it to the http.ResponseWriter.
+func (w *responseWriterRecorder) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+
+		if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+			atomic.StoreInt64(&w.total, n)
+			if w.progress != nil {
+				w.progress.total = n
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the data to the http.ResponseWriter, recording its size and
+// an implicit 200 status code.
+func (w *responseWriterRecorder) Write(data []byte) (int, error) {
+	if w.ctx != nil && w.ctx.Err() != nil {
+		return 0, w.ctx.Err()
+	}
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(data)
+	atomic.AddInt64(&w.written, int64(n))
+	if w.progress != nil {
+		w.progress.add(n)
+	}
+	return n, err
+}
+
+// readCounter is a wrapper used to count the bytes read from a request body.
+type readCounter struct {
+	io.ReadCloser
+	n        int64
+	progress *progress
+	// ctx, if set, makes the reads fail once it is canceled.
+	ctx context.Context
+}
+
+// Read reads from the body, counting the bytes.
+func (r *readCounter) Read(p []byte) (int, error) {
+	if r.ctx != nil && r.ctx.Err() != nil {
+		return 0, r.ctx.Err()
+	}
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	if r.progress != nil {
+		r.progress.add(n)
+	}
+	return n, err
+}
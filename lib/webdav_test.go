@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+// newTestConfig returns a configuration serving a temporary directory,
+// without authentication, to a user allowed to modify the files.
+func newTestConfig(t *testing.T) (*Config, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	c := &Config{Users: map[string]*User{}}
+	c.User = &User{
+		Scope:  dir,
+		Modify: true,
+		Rules:  []*Rule{},
+		Handler: &webdav.Handler{
+			FileSystem: WebDavDir{FileSystem: webdav.Dir(dir)},
+			LockSystem: c.NewLockSystem(),
+		},
+	}
+
+	return c, dir
+}
+
+// writeTestFile writes a file of the directory served by a test
+// configuration.
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve serves a request with the given headers, which come in pairs of
+// names and values, and returns the response.
+func serve(c *Config, method, target string, body io.Reader, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, body)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	return w
+}
+
+const rangeContent = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func TestRange(t *testing.T) {
+	c, dir := newTestConfig(t)
+	writeTestFile(t, dir, "video.mp4", rangeContent)
+
+	tests := []struct {
+		rng          string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"bytes=0-3", http.StatusPartialContent, "bytes 0-3/36", "0123"},
+		{"bytes=10-15", http.StatusPartialContent, "bytes 10-15/36", "abcdef"},
+		{"bytes=35-35", http.StatusPartialContent, "bytes 35-35/36", "z"},
+		{"bytes=30-100", http.StatusPartialContent, "bytes 30-35/36", "uvwxyz"},
+		{"bytes=36-", http.StatusRequestedRangeNotSatisfiable, "bytes */36", ""},
+		{"bytes=1000-2000", http.StatusRequestedRangeNotSatisfiable, "bytes */36", ""},
+	}
+
+	for _, tt := range tests {
+		w := serve(c, "GET", "/video.mp4", nil, "Range", tt.rng)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.rng, w.Code, tt.status)
+			continue
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: got Content-Range %q, want %q", tt.rng, got, tt.contentRange)
+		}
+		if tt.status == http.StatusPartialContent && w.Body.String() != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.rng, w.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestMultiRange(t *testing.T) {
+	c, dir := newTestConfig(t)
+	writeTestFile(t, dir, "video.mp4", rangeContent)
+
+	w := serve(c, "GET", "/video.mp4", nil, "Range", "bytes=0-1,10-12,34-")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusPartialContent)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("got Content-Type %q, want multipart/byteranges", w.Header().Get("Content-Type"))
+	}
+
+	want := []struct {
+		contentRange string
+		body         string
+	}{
+		{"bytes 0-1/36", "01"},
+		{"bytes 10-12/36", "abc"},
+		{"bytes 34-35/36", "yz"},
+	}
+
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	for i, part := range want {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %s", i, err)
+		}
+
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatalf("part %d: %s", i, err)
+		}
+		if got := p.Header.Get("Content-Range"); got != part.contentRange {
+			t.Errorf("part %d: got Content-Range %q, want %q", i, got, part.contentRange)
+		}
+		if string(body) != part.body {
+			t.Errorf("part %d: got body %q, want %q", i, body, part.body)
+		}
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("got more than %d parts", len(want))
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	c, dir := newTestConfig(t)
+	writeTestFile(t, dir, "video.mp4", rangeContent)
+
+	w := serve(c, "HEAD", "/video.mp4", nil)
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q, want both", etag, lastModified)
+	}
+
+	tests := []struct {
+		name    string
+		ifRange string
+		status  int
+		body    string
+	}{
+		{"matching ETag", etag, http.StatusPartialContent, "4567"},
+		{"stale ETag", `"stale"`, http.StatusOK, rangeContent},
+		{"matching date", lastModified, http.StatusPartialContent, "4567"},
+		{"stale date", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK, rangeContent},
+	}
+
+	for _, tt := range tests {
+		w := serve(c, "GET", "/video.mp4", nil, "Range", "bytes=4-7", "If-Range", tt.ifRange)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+
+	// The ETag changes with the file, so a player resuming with the old one
+	// gets the new file whole instead of mixing both.
+	writeTestFile(t, dir, "video.mp4", strings.ToUpper(rangeContent)+"!")
+	w = serve(c, "GET", "/video.mp4", nil, "Range", "bytes=4-7", "If-Range", etag)
+	if w.Code != http.StatusOK || w.Body.String() != strings.ToUpper(rangeContent)+"!" {
+		t.Errorf("modified file: got status %d and body %q, want the whole new file", w.Code, w.Body.String())
+	}
+}
+
+func TestRangeOpenEnded(t *testing.T) {
+	c, dir := newTestConfig(t)
+	writeTestFile(t, dir, "video.mp4", rangeContent)
+
+	tests := []struct {
+		rng          string
+		contentRange string
+		body         string
+	}{
+		{"bytes=30-", "bytes 30-35/36", "uvwxyz"},
+		{"bytes=0-", "bytes 0-35/36", rangeContent},
+		{"bytes=-4", "bytes 32-35/36", "wxyz"},
+		{"bytes=-100", "bytes 0-35/36", rangeContent},
+	}
+
+	for _, tt := range tests {
+		w := serve(c, "GET", "/video.mp4", nil, "Range", tt.rng)
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("%s: got status %d, want %d", tt.rng, w.Code, http.StatusPartialContent)
+			continue
+		}
+		if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+			t.Errorf("%s: got Accept-Ranges %q, want bytes", tt.rng, got)
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: got Content-Range %q, want %q", tt.rng, got, tt.contentRange)
+		}
+		if w.Body.String() != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.rng, w.Body.String(), tt.body)
+		}
+	}
+}
+
+// mediaContent returns size bytes that differ from one offset to the next,
+// so that a range read from the wrong offset is caught.
+func mediaContent(size int) []byte {
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+func TestRangeThroughWrappers(t *testing.T) {
+	c, dir := newTestConfig(t)
+	content := mediaContent(3 << 20)
+	writeTestFile(t, dir, "video.mp4", string(content))
+
+	var reported int64
+	c.OnProgress = func(id, path string, transferred, total int64) {
+		reported = transferred
+	}
+
+	w := serve(c, "GET", "/video.mp4", nil, "Range", "bytes=1000000-2999999")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 1000000-2999999/3145728" {
+		t.Errorf("got Content-Range %q", got)
+	}
+	if got := w.Header().Get("Content-Length"); got != "2000000" {
+		t.Errorf("got Content-Length %q, want 2000000", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), content[1000000:3000000]) {
+		t.Errorf("got the wrong bytes")
+	}
+
+	// The statistics and the progress count the bytes of the range only.
+	if out := c.TotalStats().BytesOut; out != 2000000 {
+		t.Errorf("got %d bytes out in the statistics, want 2000000", out)
+	}
+	if reported != 2000000 {
+		t.Errorf("got %d bytes reported as progress, want 2000000", reported)
+	}
+}
+
+func TestRangeEncrypted(t *testing.T) {
+	c, _ := newTestConfig(t)
+	fs, err := NewCryptFS(webdav.Dir(c.User.Scope), bytes.Repeat([]byte{1}, CryptKeySize), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.User.Handler.FileSystem = WebDavDir{FileSystem: fs}
+
+	content := mediaContent(5*cryptChunkSize + 123)
+	if w := serve(c, "PUT", "/video.mp4", bytes.NewReader(content)); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: got status %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	// Ranges within a chunk, across chunk boundaries, and up to the end.
+	ranges := [][2]int{
+		{10, 20},
+		{cryptChunkSize - 5, cryptChunkSize + 5},
+		{cryptChunkSize, 3*cryptChunkSize + 1},
+		{len(content) - 200, len(content) - 1},
+	}
+
+	for _, rng := range ranges {
+		header := "bytes=" + strconv.Itoa(rng[0]) + "-" + strconv.Itoa(rng[1])
+		w := serve(c, "GET", "/video.mp4", nil, "Range", header)
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("%s: got status %d, want %d", header, w.Code, http.StatusPartialContent)
+			continue
+		}
+		if !bytes.Equal(w.Body.Bytes(), content[rng[0]:rng[1]+1]) {
+			t.Errorf("%s: got the wrong bytes", header)
+		}
+	}
+}
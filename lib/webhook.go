@@ -0,0 +1,229 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Event types. The changes are sent to the webhook, and every event to the
+// OnEvent callback.
+const (
+	EventCreated  = "created"
+	EventModified = "modified"
+	EventDeleted  = "deleted"
+	EventMoved    = "moved"
+
+	// EventUploadComplete and EventDownloadComplete are sent when the
+	// whole file of a PUT or GET request was transferred.
+	EventUploadComplete   = "upload_complete"
+	EventDownloadComplete = "download_complete"
+)
+
+// Event is a filesystem change or transfer notification.
+type Event struct {
+	Type        string    `json:"type"`
+	Path        string    `json:"path"`
+	Destination string    `json:"destination,omitempty"`
+	Size        int64     `json:"size"`
+	User        string    `json:"user"`
+	Time        time.Time `json:"time"`
+	// Duration is the duration of a transfer, in milliseconds.
+	Duration int64 `json:"duration_ms,omitempty"`
+}
+
+// Webhook delivers events asynchronously to an URL. Events are queued so
+// that a slow webhook doesn't block requests, and dropped if the queue is
+// full. Failed deliveries are retried with an exponential backoff.
+type Webhook struct {
+	URL     string
+	Secret  string
+	Retries int
+	Client  *http.Client
+
+	queue chan Event
+}
+
+// NewWebhook creates a new Webhook and starts delivering events.
+func NewWebhook(url, secret string, queueSize int) *Webhook {
+	h := &Webhook{
+		URL:     url,
+		Secret:  secret,
+		Retries: 5,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan Event, queueSize),
+	}
+
+	go h.run()
+	return h
+}
+
+// Send queues an event.
+func (h *Webhook) Send(e Event) {
+	select {
+	case h.queue <- e:
+	default:
+		zap.L().Warn("webhook queue is full, dropping event", zap.String("type", e.Type), zap.String("path", e.Path))
+	}
+}
+
+func (h *Webhook) run() {
+	for e := range h.queue {
+		body, err := json.Marshal(e)
+		if err != nil {
+			zap.L().Error("webhook event encoding failed", zap.Error(err))
+			continue
+		}
+
+		backoff := time.Second
+		for attempt := 1; ; attempt++ {
+			err = h.deliver(body)
+			if err == nil {
+				break
+			}
+
+			if attempt >= h.Retries {
+				zap.L().Warn("webhook delivery failed", zap.String("type", e.Type), zap.String("path", e.Path), zap.Error(err))
+				break
+			}
+
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (h *Webhook) deliver(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Webdav-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	return nil
+}
+
+// sendEvent notifies the webhook of the change made by a successful request.
+// Existed reports whether the target of a PUT existed before the request.
+func (c *Config) sendEvent(u *User, r *http.Request, status int, existed bool) {
+	if status < 200 || status > 299 {
+		return
+	}
+
+	e := Event{
+		Path: r.URL.Path,
+		User: u.Username,
+		Time: time.Now(),
+	}
+
+	destination := ""
+	if dst, err := url.Parse(r.Header.Get("Destination")); err == nil {
+		destination = dst.Path
+	}
+
+	switch r.Method {
+	case "PUT":
+		e.Type = EventCreated
+		if existed {
+			e.Type = EventModified
+		}
+	case "MKCOL":
+		e.Type = EventCreated
+	case "DELETE":
+		e.Type = EventDeleted
+	case "MOVE":
+		e.Type = EventMoved
+		e.Destination = destination
+	case "COPY":
+		e.Type = EventCreated
+		e.Path = destination
+	default:
+		return
+	}
+
+	if e.Type != EventDeleted {
+		name := e.Path
+		if e.Destination != "" {
+			name = e.Destination
+		}
+
+		info, err := u.Handler.FileSystem.Stat(r.Context(), strings.TrimPrefix(name, u.Handler.Prefix))
+		if err == nil && !info.IsDir() {
+			e.Size = info.Size()
+		}
+	}
+
+	c.emit(e)
+}
+
+// sendTransferEvent notifies that the transfer of a PUT or GET request
+// completed. Failed and interrupted transfers aren't notified.
+func (c *Config) sendTransferEvent(u *User, r *http.Request, rec *responseWriterRecorder, body *readCounter, start time.Time) {
+	e := Event{
+		Path:     r.URL.Path,
+		User:     u.Username,
+		Time:     time.Now(),
+		Duration: time.Since(start).Milliseconds(),
+	}
+
+	switch r.Method {
+	case "PUT":
+		if rec.status < 200 || rec.status > 299 {
+			return
+		}
+		e.Type = EventUploadComplete
+		e.Size = body.n
+	case "GET":
+		if rec.status != http.StatusOK && rec.status != http.StatusPartialContent {
+			return
+		}
+		if length, err := strconv.ParseInt(rec.Header().Get("Content-Length"), 10, 64); err == nil && rec.written != length {
+			return
+		}
+		e.Type = EventDownloadComplete
+		e.Size = rec.written
+	default:
+		return
+	}
+
+	c.emit(e)
+}
+
+// emit sends an event to the OnEvent callback, and the changes to the
+// webhook.
+func (c *Config) emit(e Event) {
+	if c.OnEvent != nil {
+		c.OnEvent(e)
+	}
+
+	if c.Webhook != nil && e.Type != EventUploadComplete && e.Type != EventDownloadComplete {
+		c.Webhook.Send(e)
+	}
+}